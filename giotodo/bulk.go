@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gioui.org/layout"
+	"gioui.org/widget"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+
+	. "github.com/fjl/gio-demos/internal/cd"
+)
+
+// selection is the set of selected items of the current list. Bulk actions apply
+// to all selected items.
+type selection struct {
+	ids     map[todostore.ID]bool
+	anchor  todostore.ID // start of Shift-click ranges
+	version uint64       // version of the list when removed items were last dropped
+}
+
+func (s *selection) len() int {
+	return len(s.ids)
+}
+
+func (s *selection) has(it *item) bool {
+	return s.ids[it.id]
+}
+
+// clear deselects all items.
+func (s *selection) clear() {
+	s.ids = nil
+	s.anchor = ""
+}
+
+// toggle selects or deselects an item.
+func (s *selection) toggle(it *item) {
+	if s.ids == nil {
+		s.ids = make(map[todostore.ID]bool)
+	}
+	if s.ids[it.id] {
+		delete(s.ids, it.id)
+	} else {
+		s.ids[it.id] = true
+	}
+	s.anchor = it.id
+}
+
+// extend selects the shown items between the anchor and it. Without anchor, only it
+// is selected.
+func (s *selection) extend(items []*item, it *item) {
+	from, to := -1, indexOf(items, it)
+	for i, other := range items {
+		if other.id == s.anchor {
+			from = i
+		}
+	}
+	if from < 0 {
+		from = to
+	}
+	if from > to {
+		from, to = to, from
+	}
+	if s.ids == nil {
+		s.ids = make(map[todostore.ID]bool)
+	}
+	for _, other := range items[from : to+1] {
+		s.ids[other.id] = true
+	}
+	if s.anchor == "" {
+		s.anchor = it.id
+	}
+}
+
+// selectAll selects the given items.
+func (s *selection) selectAll(items []*item) {
+	s.ids = make(map[todostore.ID]bool, len(items))
+	for _, it := range items {
+		s.ids[it.id] = true
+	}
+	s.anchor = ""
+}
+
+// prune forgets selected items which were removed. The items are only checked when
+// the list has changed.
+func (s *selection) prune(m *todoModel) {
+	if s.version == m.version {
+		return
+	}
+	s.version = m.version
+	for id := range s.ids {
+		if m.items[id] == nil {
+			delete(s.ids, id)
+		}
+	}
+}
+
+// items returns the selected items of a list, in tree order.
+func (s *selection) items(m *todoModel) []*item {
+	m.updateTree()
+	var items []*item
+	for id := range s.ids {
+		if it := m.items[id]; it != nil {
+			items = append(items, it)
+		}
+	}
+	sortItems(items)
+	return items
+}
+
+// withSubtasks returns the items and their subtasks, in tree order.
+func (m *todoModel) withSubtasks(items []*item) []*item {
+	m.updateTree()
+	set := make(map[*item]bool)
+	for _, it := range items {
+		for _, sub := range it.subtree() {
+			set[sub] = true
+		}
+	}
+	result := make([]*item, 0, len(set))
+	for it := range set {
+		result = append(result, it)
+	}
+	sortItems(result)
+	return result
+}
+
+// bulkSetDone marks items and their subtasks as done or not done.
+func (m *todoModel) bulkSetDone(items []*item, done bool) {
+	verb := "Completed"
+	if !done {
+		verb = "Reopened"
+	}
+	m.setDoneItems(m.withSubtasks(items), done, verb)
+}
+
+// bulkRemove deletes items and their subtasks.
+func (m *todoModel) bulkRemove(items []*item) {
+	set := make(map[*item]bool, len(items))
+	for _, it := range items {
+		set[it] = true
+	}
+	m.removeItems(func(it *item) bool { return set[it] })
+}
+
+// bulkMove moves items and their subtasks to the end of another list. Subtasks stay
+// below their parent, other items become top-level items.
+func (m *todoModel) bulkMove(items []*item, to *todoModel) {
+	if to == m {
+		return
+	}
+	items = m.withSubtasks(items)
+	moved := make(map[todostore.ID]bool, len(items))
+	for _, it := range items {
+		moved[it.id] = true
+	}
+	var (
+		changes, inverse []todostore.Event
+		fields           = []todostore.Field{todostore.FieldList, todostore.FieldParent}
+		pos              = to.endPos()
+	)
+	for _, it := range items {
+		var parent todostore.ID
+		if it.parentItem != nil && moved[it.parentItem.id] {
+			parent = it.parentItem.id
+		}
+		changes = append(changes,
+			&todostore.ItemChanged{ID: it.id, Item: todostore.Item{List: to.id, Parent: parent}, Fields: fields},
+			&todostore.ItemMoved{ID: it.id, Pos: pos},
+		)
+		inverse = append(inverse,
+			&todostore.ItemChanged{ID: it.id, Item: todostore.Item{List: m.id, Parent: it.parent}, Fields: fields},
+			&todostore.ItemMoved{ID: it.id, Pos: it.pos},
+		)
+		pos = todostore.PosAfter(pos)
+	}
+	m.bulk(itemsDesc("Moved", len(items)), false, changes, inverse)
+}
+
+// bulkTag adds a tag to items which don't have it.
+func (m *todoModel) bulkTag(items []*item, tag string) {
+	var (
+		changes, inverse []todostore.Event
+		fields           = []todostore.Field{todostore.FieldTags}
+	)
+	for _, it := range items {
+		if it.stored.HasTag(tag) {
+			continue
+		}
+		tags := append(it.tags[:len(it.tags):len(it.tags)], tag)
+		changes = append(changes, &todostore.ItemChanged{ID: it.id, Item: todostore.Item{Tags: tags}, Fields: fields})
+		inverse = append(inverse, &todostore.ItemChanged{ID: it.id, Item: todostore.Item{Tags: it.tags}, Fields: fields})
+	}
+	m.bulk(itemsDesc("Tagged", len(changes)), false, changes, inverse)
+}
+
+// processBulk handles the buttons of the bulk bar.
+func (ui *todoUI) processBulk(gtx C) {
+	ui.selected.prune(ui.todos)
+	// The selection can be large, so its items are only collected for an action.
+	items := func() []*item { return ui.selected.items(ui.todos) }
+	switch {
+	case ui.bulkClear.Clicked(gtx):
+		ui.selected.clear()
+	case ui.bulkDone.Clicked(gtx):
+		ui.todos.bulkSetDone(items(), true)
+	case ui.bulkReopen.Clicked(gtx):
+		ui.todos.bulkSetDone(items(), false)
+	case ui.bulkRemove.Clicked(gtx):
+		ui.todos.bulkRemove(items())
+		ui.selected.clear()
+	case ui.bulkMove.Clicked(gtx):
+		ui.showBulkMove = !ui.showBulkMove
+	}
+	for _, l := range ui.lists.lists {
+		if l.btn.move.Clicked(gtx) {
+			ui.todos.bulkMove(items(), l)
+			ui.selected.clear()
+		}
+	}
+	for {
+		e, ok := ui.bulkTag.Update(gtx)
+		if !ok {
+			break
+		}
+		if e, ok := e.(widget.SubmitEvent); ok {
+			tag := strings.TrimPrefix(strings.TrimSpace(e.Text), "#")
+			if tag != "" && !strings.ContainsAny(tag, " \t#") {
+				ui.todos.bulkTag(items(), tag)
+				ui.bulkTag.SetText("")
+			}
+		}
+	}
+	if ui.selected.len() == 0 {
+		ui.showBulkMove = false
+	}
+}
+
+// layoutBulkBar draws the actions for the selected items.
+func (ui *todoUI) layoutBulkBar(gtx C) D {
+	label := ui.theme.StatusLabel(fmt.Sprintf("%d selected.", ui.selected.len()))
+	var (
+		done    = ui.theme.StatusButton(&ui.bulkDone, "Done", false)
+		reopen  = ui.theme.StatusButton(&ui.bulkReopen, "Reopen", false)
+		remove  = ui.theme.StatusButton(&ui.bulkRemove, "Delete", false)
+		move    = ui.theme.StatusButton(&ui.bulkMove, "Move ▼", ui.showBulkMove)
+		tag     = ui.theme.Editor(&ui.bulkTag, "#tag")
+		clear   = ui.theme.StatusButton(&ui.bulkClear, "×", false)
+		actions = layout.Flex{Alignment: layout.Baseline}
+	)
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return actions.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					return ui.theme.Pad.Button.Layout(gtx, label.Layout)
+				}),
+				layout.Rigid(done.Layout),
+				layout.Rigid(reopen.Layout),
+				layout.Rigid(remove.Layout),
+				layout.Rigid(move.Layout),
+				layout.Flexed(1.0, func(gtx C) D {
+					return ui.theme.Pad.Button.Layout(gtx, tag.Layout)
+				}),
+				layout.Rigid(clear.Layout),
+			)
+		}),
+		layout.Rigid(func(gtx C) D {
+			return showIf(ui.showBulkMove, gtx, ui.layoutMoveTargets)
+		}),
+	)
+}
+
+// layoutMoveTargets draws the lists which selected items can be moved to.
+func (ui *todoUI) layoutMoveTargets(gtx C) D {
+	var children []layout.FlexChild
+	for _, l := range ui.lists.lists {
+		if l == ui.todos {
+			continue
+		}
+		btn := ui.theme.StatusButton(&l.btn.move, l.name, false)
+		children = append(children, layout.Rigid(btn.Layout))
+	}
+	return layout.Flex{Alignment: layout.Baseline}.Layout(gtx, children...)
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/itemtext"
+	"github.com/fjl/gio-demos/giotodo/internal/todoio"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+	"golang.org/x/term"
+)
+
+// commands are the subcommands of the giotodo executable. When the first argument
+// is not one of them, the app window opens.
+var commands = map[string]func(store *todostore.Store, args []string) error{
+	"add":     addCommand,
+	"ls":      listCommand,
+	"done":    doneCommand,
+	"rm":      removeCommand,
+	"compact": compactCommand,
+	"passwd":  passwdCommand,
+	"export":  exportCommand,
+	"import":  importCommand,
+}
+
+// cliOutput and cliInput are the standard output and input of commands.
+var (
+	cliOutput io.Writer = os.Stdout
+	cliInput  io.Reader = os.Stdin
+)
+
+const commandUsage = `Usage:
+  giotodo add [-list NAME] [-json] TEXT...
+  giotodo ls [-active] [-done] [-list NAME] [-json]
+  giotodo done ID...
+  giotodo rm ID...
+  giotodo compact
+  giotodo passwd
+  giotodo export [-format F] [-list NAME] [FILE]
+  giotodo import [-format F] [-list NAME] [FILE]
+
+TEXT uses the same syntax as the app, e.g. 'buy milk #shop due:tomorrow'.
+Items are identified by a prefix of the ID shown by 'ls'. Subtasks are
+shown indented below their parent, and are removed along with it.
+
+Formats: todomvc, csv, markdown, todotxt. The format is chosen by the
+extension of FILE when not given. Without FILE, stdin/stdout are used.
+
+The app can be running while commands are used.
+
+The passphrase of an encrypted store is read from the terminal, or from
+the GIOTODO_PASSPHRASE environment variable. The passwd command sets a new
+passphrase. An empty passphrase removes the encryption.
+`
+
+// runCommand runs a subcommand on the store in dir and returns the exit code.
+func runCommand(dir string, args []string) int {
+	cmd := commands[args[0]]
+	if cmd == nil {
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+	key, err := unlockCLI(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "giotodo:", err)
+		return 1
+	}
+	store := todostore.NewEncryptedStore(dir, key)
+	err = cmd(store, args[1:])
+	store.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "giotodo:", err)
+		return 1
+	}
+	return 0
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), commandUsage) }
+	return fs
+}
+
+// idPrefixLen is the length of item IDs printed by the ls command.
+const idPrefixLen = 8
+
+// shortID returns the prefix of id printed by the ls command.
+func shortID(id todostore.ID) todostore.ID {
+	return id[:min(len(id), idPrefixLen)]
+}
+
+// cliItem is the JSON output of the add and ls commands.
+type cliItem struct {
+	ID       todostore.ID `json:"id"`
+	List     string       `json:"list"`
+	Parent   todostore.ID `json:"parent,omitempty"`
+	Text     string       `json:"text"`
+	Done     bool         `json:"done"`
+	Due      *time.Time   `json:"due,omitempty"`
+	Priority int          `json:"priority,omitempty"`
+	Tags     []string     `json:"tags,omitempty"`
+	Notes    string       `json:"notes,omitempty"`
+	Repeat   string       `json:"repeat,omitempty"`
+}
+
+func newCLIItem(id todostore.ID, list string, it todostore.Item) cliItem {
+	return cliItem{
+		ID:       id,
+		List:     list,
+		Parent:   it.Parent,
+		Text:     it.Text,
+		Done:     it.Done,
+		Due:      it.Due,
+		Priority: int(it.Priority),
+		Tags:     it.Tags,
+		Notes:    it.Notes,
+		Repeat:   it.Repeat,
+	}
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(cliOutput)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func addCommand(store *todostore.Store, args []string) error {
+	var (
+		fs       = newFlagSet("add")
+		list     = fs.String("list", "", "name of the list")
+		jsonFlag = fs.Bool("json", false, "print the item as JSON")
+	)
+	fs.Parse(args)
+	text := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if text == "" {
+		return fmt.Errorf("missing item text")
+	}
+
+	item := itemtext.Parse(text, time.Now())
+	listName := defaultListName
+	if *list != "" {
+		snap, err := store.Snapshot()
+		if err != nil {
+			return err
+		}
+		id, ok := todoio.SnapshotLookup(snap, defaultListName)(*list)
+		if !ok {
+			if id, err = store.AddListContext(context.Background(), *list); err != nil {
+				return err
+			}
+		}
+		item.List, listName = id, *list
+	}
+	id, err := store.AddItemContext(context.Background(), item)
+	if err != nil {
+		return err
+	}
+	if *jsonFlag {
+		return printJSON(newCLIItem(id, listName, item))
+	}
+	fmt.Fprintln(cliOutput, shortID(id))
+	return nil
+}
+
+func listCommand(store *todostore.Store, args []string) error {
+	var (
+		fs       = newFlagSet("ls")
+		active   = fs.Bool("active", false, "show active items only")
+		done     = fs.Bool("done", false, "show completed items only")
+		list     = fs.String("list", "", "show items of this list only")
+		jsonFlag = fs.Bool("json", false, "print items as JSON")
+	)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	snap, err := store.Snapshot()
+	if err != nil {
+		return err
+	}
+	listNames := make(map[todostore.ID]string, len(snap.Lists))
+	for _, l := range snap.Lists {
+		listNames[l.ID] = l.Name
+	}
+	listNames[todostore.DefaultList] = defaultListName
+	if *list != "" {
+		if _, ok := todoio.SnapshotLookup(snap, defaultListName)(*list); !ok {
+			return fmt.Errorf("no list named %q", *list)
+		}
+	}
+
+	// Snapshot items are grouped by list already, and subtasks follow their parent.
+	var (
+		items    = make([]cliItem, 0)
+		depth    = make(map[todostore.ID]int)
+		lastList todostore.ID
+		first    = true
+	)
+	for _, info := range snap.Items {
+		if info.Item.Parent != "" {
+			depth[info.ID] = depth[info.Item.Parent] + 1
+		}
+		name := listNames[info.Item.List]
+		if (*active && info.Item.Done) || (*done && !info.Item.Done) || (*list != "" && name != *list) {
+			continue
+		}
+		if *jsonFlag {
+			items = append(items, newCLIItem(info.ID, name, info.Item))
+			continue
+		}
+		if first || info.Item.List != lastList {
+			if !first {
+				fmt.Fprintln(cliOutput)
+			}
+			fmt.Fprintf(cliOutput, "%s:\n", name)
+			lastList, first = info.Item.List, false
+		}
+		check := " "
+		if info.Item.Done {
+			check = "x"
+		}
+		indent := strings.Repeat("  ", depth[info.ID])
+		fmt.Fprintf(cliOutput, "  %s %s[%s] %s\n", shortID(info.ID), indent, check, itemtext.Format(info.Item))
+	}
+	if *jsonFlag {
+		return printJSON(items)
+	}
+	return nil
+}
+
+func doneCommand(store *todostore.Store, args []string) error {
+	fs := newFlagSet("done")
+	fs.Parse(args)
+	ids, err := resolveItems(store, fs.Args())
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err := store.UpdateItemContext(context.Background(), id, todostore.Item{Done: true}, todostore.FieldDone)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeCommand(store *todostore.Store, args []string) error {
+	fs := newFlagSet("rm")
+	fs.Parse(args)
+	ids, err := resolveItems(store, fs.Args())
+	if err != nil {
+		return err
+	}
+	snap, err := store.Snapshot()
+	if err != nil {
+		return err
+	}
+	// Subtasks are removed along with their parent.
+	removed := make(map[todostore.ID]bool)
+	for _, id := range ids {
+		removed[id] = true
+	}
+	for _, info := range snap.Items {
+		if removed[info.Item.Parent] {
+			removed[info.ID] = true
+		}
+	}
+	for _, info := range snap.Items {
+		if removed[info.ID] {
+			if err := store.RemoveItemContext(context.Background(), info.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveItems finds the items identified by the given ID prefixes.
+func resolveItems(store *todostore.Store, prefixes []string) ([]todostore.ID, error) {
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("missing item ID")
+	}
+	snap, err := store.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]todostore.ID, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		var match []todostore.ID
+		for _, info := range snap.Items {
+			if strings.HasPrefix(string(info.ID), prefix) {
+				match = append(match, info.ID)
+			}
+		}
+		switch {
+		case len(match) == 0:
+			return nil, fmt.Errorf("no item with ID %s", prefix)
+		case len(match) > 1:
+			return nil, fmt.Errorf("ID %s is ambiguous", prefix)
+		}
+		ids = append(ids, match[0])
+	}
+	return ids, nil
+}
+
+func compactCommand(store *todostore.Store, args []string) error {
+	fs := newFlagSet("compact")
+	fs.Parse(args)
+	result, err := store.Compact()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cliOutput, "%d events, %d removed\n", result.After, result.Before-result.After)
+	return nil
+}
+
+// unlockCLI returns the key of an encrypted store.
+func unlockCLI(dir string) (*todostore.Key, error) {
+	if enc, err := todostore.IsEncrypted(dir); !enc {
+		return nil, err
+	}
+	passphrase, ok := os.LookupEnv("GIOTODO_PASSPHRASE")
+	if !ok {
+		var err error
+		if passphrase, err = readPassphrase("Passphrase: "); err != nil {
+			return nil, err
+		}
+	}
+	return todostore.Unlock(dir, passphrase)
+}
+
+// readPassphrase reads a line from the terminal without echoing it.
+func readPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("can't read passphrase, stdin is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(b), err
+}
+
+func passwdCommand(store *todostore.Store, args []string) error {
+	fs := newFlagSet("passwd")
+	fs.Parse(args)
+	passphrase, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return err
+	}
+	repeated, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return err
+	}
+	if passphrase != repeated {
+		return fmt.Errorf("passphrases don't match")
+	}
+	return store.SetPassphrase(passphrase)
+}
+
+// transferFlags are the flags of the import and export commands.
+type transferFlags struct {
+	fs     *flag.FlagSet
+	format string
+	list   string
+}
+
+func newTransferFlags(name string) *transferFlags {
+	f := &transferFlags{fs: newFlagSet(name)}
+	f.fs.StringVar(&f.format, "format", "", "file format")
+	f.fs.StringVar(&f.list, "list", "", "name of the list")
+	return f
+}
+
+// parse parses the flags and returns the file name and format.
+func (f *transferFlags) parse(args []string) (file string, format todoio.Format, err error) {
+	if err := f.fs.Parse(args); err != nil {
+		return "", "", err
+	}
+	switch f.fs.NArg() {
+	case 0:
+	case 1:
+		file = f.fs.Arg(0)
+	default:
+		return "", "", fmt.Errorf("too many arguments")
+	}
+	switch {
+	case f.format != "":
+		format, err = todoio.ParseFormat(f.format)
+	case file != "":
+		var ok bool
+		if format, ok = todoio.FormatOf(file); !ok {
+			err = fmt.Errorf("can't determine format of %s, use -format", file)
+		}
+	default:
+		format = todoio.Markdown
+	}
+	return file, format, err
+}
+
+func exportCommand(store *todostore.Store, args []string) error {
+	flags := newTransferFlags("export")
+	file, format, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+	snap, err := store.Snapshot()
+	if err != nil {
+		return err
+	}
+	lists := todoio.FromSnapshot(snap, defaultListName)
+	if flags.list != "" {
+		lists = selectList(lists, flags.list)
+		if lists == nil {
+			return fmt.Errorf("no list named %q", flags.list)
+		}
+	}
+
+	w := cliOutput
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return todoio.Export(w, format, lists)
+}
+
+func selectList(lists []todoio.List, name string) []todoio.List {
+	for _, l := range lists {
+		if l.Name == name {
+			return []todoio.List{l}
+		}
+	}
+	return nil
+}
+
+func importCommand(store *todostore.Store, args []string) error {
+	flags := newTransferFlags("import")
+	file, format, err := flags.parse(args)
+	if err != nil {
+		return err
+	}
+	r := cliInput
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	lists, err := todoio.Import(r, format)
+	if err != nil {
+		return err
+	}
+
+	snap, err := store.Snapshot()
+	if err != nil {
+		return err
+	}
+	lookup := todoio.SnapshotLookup(snap, defaultListName)
+	target := todostore.DefaultList
+	if flags.list != "" {
+		// Everything goes into the given list.
+		id, ok := lookup(flags.list)
+		if !ok {
+			if id, err = store.AddListContext(context.Background(), flags.list); err != nil {
+				return err
+			}
+		}
+		for i := range lists {
+			lists[i].Name = ""
+		}
+		target = id
+	}
+	added, err := todoio.AddContext(context.Background(), store, lists, target, lookup)
+	if err != nil {
+		return fmt.Errorf("import failed after %d items: %v", len(added.Items), err)
+	}
+	fmt.Fprintf(cliOutput, "imported %d items\n", len(added.Items))
+	return nil
+}
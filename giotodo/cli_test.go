@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+func TestShortID(t *testing.T) {
+	tests := map[todostore.ID]todostore.ID{
+		todostore.DefaultList: "",
+		"abc":                 "abc",
+		"0123456789abcdef":    "01234567",
+	}
+	for id, want := range tests {
+		if got := shortID(id); got != want {
+			t.Errorf("shortID(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+// runCLI runs a command on the store in dir. It returns the exit code and the output.
+func runCLI(dir string, input string, args ...string) (int, string) {
+	var out bytes.Buffer
+	cliOutput, cliInput = &out, strings.NewReader(input)
+	defer func() { cliOutput, cliInput = os.Stdout, os.Stdin }()
+	code := runCommand(dir, args)
+	return code, out.String()
+}
+
+// mustRunCLI runs a command and fails the test if it doesn't succeed.
+func mustRunCLI(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	code, out := runCLI(dir, "", args...)
+	if code != 0 {
+		t.Fatalf("%v: exit code %d, output:\n%s", args, code, out)
+	}
+	return out
+}
+
+// storedItems returns the items of the store in dir by their text.
+func storedItems(t *testing.T, dir string) map[string]todostore.Item {
+	t.Helper()
+	store := todostore.NewStore(dir)
+	defer store.Close()
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := make(map[string]todostore.Item, len(snap.Items))
+	for _, info := range snap.Items {
+		items[info.Item.Text] = info.Item
+	}
+	return items
+}
+
+func TestCommandAddList(t *testing.T) {
+	dir := t.TempDir()
+	milk := strings.TrimSpace(mustRunCLI(t, dir, "add", "buy", "milk", "#shop"))
+	report := strings.TrimSpace(mustRunCLI(t, dir, "add", "-list", "Work", "write report"))
+	if len(milk) != idPrefixLen || len(report) != idPrefixLen {
+		t.Fatalf("add printed IDs %q, %q", milk, report)
+	}
+
+	want := fmt.Sprintf("Todo:\n  %s [ ] buy milk #shop\n\nWork:\n  %s [ ] write report\n", milk, report)
+	if out := mustRunCLI(t, dir, "ls"); out != want {
+		t.Fatalf("ls output:\n%s\nwant:\n%s", out, want)
+	}
+	want = fmt.Sprintf("Work:\n  %s [ ] write report\n", report)
+	if out := mustRunCLI(t, dir, "ls", "-list", "Work"); out != want {
+		t.Fatalf("ls -list output:\n%s\nwant:\n%s", out, want)
+	}
+
+	items := storedItems(t, dir)
+	if it := items["buy milk"]; !it.HasTag("shop") || it.List != todostore.DefaultList {
+		t.Fatalf("wrong stored item %+v", it)
+	}
+	if it := items["write report"]; it.List == todostore.DefaultList {
+		t.Fatal("item not added to list")
+	}
+}
+
+func TestCommandDoneRemove(t *testing.T) {
+	dir := t.TempDir()
+	a := strings.TrimSpace(mustRunCLI(t, dir, "add", "a"))
+	b := strings.TrimSpace(mustRunCLI(t, dir, "add", "b"))
+	mustRunCLI(t, dir, "done", a)
+	mustRunCLI(t, dir, "rm", b)
+
+	want := fmt.Sprintf("Todo:\n  %s [x] a\n", a)
+	if out := mustRunCLI(t, dir, "ls", "-done"); out != want {
+		t.Fatalf("ls -done output:\n%s\nwant:\n%s", out, want)
+	}
+	if out := mustRunCLI(t, dir, "ls", "-active"); out != "" {
+		t.Fatalf("ls -active output:\n%s", out)
+	}
+	items := storedItems(t, dir)
+	if len(items) != 1 || !items["a"].Done {
+		t.Fatalf("wrong stored items %+v", items)
+	}
+}
+
+func TestCommandCompact(t *testing.T) {
+	dir := t.TempDir()
+	a := strings.TrimSpace(mustRunCLI(t, dir, "add", "a"))
+	b := strings.TrimSpace(mustRunCLI(t, dir, "add", "b"))
+	mustRunCLI(t, dir, "done", a)
+	mustRunCLI(t, dir, "rm", b)
+
+	out := mustRunCLI(t, dir, "compact")
+	if !regexp.MustCompile(`^\d+ events, [1-9]\d* removed\n$`).MatchString(out) {
+		t.Fatalf("wrong compact output %q", out)
+	}
+	items := storedItems(t, dir)
+	if len(items) != 1 || !items["a"].Done {
+		t.Fatalf("wrong stored items after compaction %+v", items)
+	}
+}
+
+func TestCommandExportImport(t *testing.T) {
+	dir := t.TempDir()
+	a := strings.TrimSpace(mustRunCLI(t, dir, "add", "buy milk #shop"))
+	mustRunCLI(t, dir, "add", "-list", "Work", "write report")
+	mustRunCLI(t, dir, "done", a)
+
+	file := filepath.Join(t.TempDir(), "todo.md")
+	mustRunCLI(t, dir, "export", file)
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Todo\n\n- [x] buy milk #shop\n\n# Work\n\n- [ ] write report\n"
+	if string(content) != want {
+		t.Fatalf("exported:\n%s\nwant:\n%s", content, want)
+	}
+	if out := mustRunCLI(t, dir, "export", "-list", "Work"); out != "# Work\n\n- [ ] write report\n" {
+		t.Fatalf("export -list output:\n%s", out)
+	}
+
+	// Import into another store.
+	dir2 := t.TempDir()
+	if out := mustRunCLI(t, dir2, "import", file); out != "imported 2 items\n" {
+		t.Fatalf("import output %q", out)
+	}
+	items := storedItems(t, dir2)
+	if it := items["buy milk"]; !it.Done || !it.HasTag("shop") {
+		t.Fatalf("wrong imported item %+v", it)
+	}
+	if it := items["write report"]; it.List == todostore.DefaultList {
+		t.Fatal("imported item not in list")
+	}
+
+	// Import from stdin into a list.
+	code, out := runCLI(dir2, "- [ ] call mom\n", "import", "-format", "markdown", "-list", "Home")
+	if code != 0 || out != "imported 1 items\n" {
+		t.Fatalf("import from stdin: exit code %d, output %q", code, out)
+	}
+	if out := mustRunCLI(t, dir2, "ls", "-list", "Home"); !strings.HasSuffix(out, " [ ] call mom\n") {
+		t.Fatalf("ls output after import:\n%s", out)
+	}
+}
+
+func TestCommandExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	mustRunCLI(t, dir, "add", "a")
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"unknown"}, 2},
+		{[]string{"done", "nonexistent"}, 1},
+		{[]string{"add"}, 1},
+		{[]string{"ls", "-list", "nonexistent"}, 1},
+		{[]string{"export", "file.unknown"}, 1},
+	}
+	for _, test := range tests {
+		if code, _ := runCLI(dir, "", test.args...); code != test.code {
+			t.Errorf("%v: exit code %d, want %d", test.args, code, test.code)
+		}
+	}
+	if items := storedItems(t, dir); len(items) != 1 {
+		t.Fatalf("store changed by failed commands: %+v", items)
+	}
+}
+
+// This checks that commands can be used while the app has the store open.
+func TestCommandWhileOpen(t *testing.T) {
+	dir := t.TempDir()
+	store := todostore.NewStore(dir)
+	defer store.Close()
+	sub, _, err := store.SubscribeSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	// Items added by the command are loaded by the app.
+	mustRunCLI(t, dir, "add", "from cli")
+	timeout := time.After(5 * time.Second)
+wait:
+	for {
+		select {
+		case <-sub.Ready():
+			for _, ev := range sub.Events() {
+				if ev, ok := ev.(*todostore.ItemAdded); ok && ev.Item.Text == "from cli" {
+					break wait
+				}
+			}
+		case <-timeout:
+			t.Fatal("item added by command not loaded")
+		}
+	}
+
+	// Items added by the app are seen by commands.
+	if _, err := store.AddItemContext(context.Background(), todostore.Item{Text: "from app"}); err != nil {
+		t.Fatal(err)
+	}
+	out := mustRunCLI(t, dir, "ls")
+	if !strings.Contains(out, "from cli") || !strings.Contains(out, "from app") {
+		t.Fatalf("ls output:\n%s", out)
+	}
+}
+
+func TestCommandEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	store := todostore.NewStore(dir)
+	store.AddItem(todostore.Item{Text: "secret"})
+	if err := store.SetPassphrase("pass"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	t.Setenv("GIOTODO_PASSPHRASE", "wrong")
+	if code, _ := runCLI(dir, "", "ls"); code != 1 {
+		t.Fatalf("exit code %d with wrong passphrase", code)
+	}
+	t.Setenv("GIOTODO_PASSPHRASE", "pass")
+	mustRunCLI(t, dir, "add", "another")
+	if out := mustRunCLI(t, dir, "ls"); !strings.Contains(out, "secret") || !strings.Contains(out, "another") {
+		t.Fatalf("ls output:\n%s", out)
+	}
+}
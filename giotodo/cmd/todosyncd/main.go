@@ -0,0 +1,44 @@
+// Command todosyncd runs a sync server for giotodo.
+//
+// Start it with
+//
+//	todosyncd -addr :8080 -data todosync.json
+//
+// and run giotodo with GIOTODO_SYNC=http://host:8080 on each device.
+//
+// Anyone who can connect to the server can read and change all items. When the server
+// listens on anything but localhost or a trusted network, set a token in the
+// environment variable TODOSYNCD_TOKEN, and use GIOTODO_SYNC=http://:TOKEN@host:8080
+// on the devices. The token isn't encrypted, so the server should also be run behind
+// an HTTPS proxy then.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/fjl/gio-demos/giotodo/internal/syncserver"
+)
+
+func main() {
+	var (
+		addr = flag.String("addr", "localhost:8080", "listening address")
+		data = flag.String("data", "", "data file (default: keep events in memory)")
+	)
+	flag.Parse()
+
+	srv := syncserver.New()
+	if *data != "" {
+		var err error
+		if srv, err = syncserver.Open(*data); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if token := os.Getenv("TODOSYNCD_TOKEN"); token != "" {
+		srv.RequireToken(token)
+	}
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/widget"
+
+	. "github.com/fjl/gio-demos/internal/cd"
+)
+
+// layoutDebug draws the store metrics. The overlay refreshes itself once per second
+// while it is shown.
+func (ui *todoUI) layoutDebug(gtx C) D {
+	gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(time.Second)})
+
+	v := ui.stats.Values()
+	lines := []string{
+		fmt.Sprintf("written: %d records, %d bytes", v.RecordsWritten, v.BytesWritten),
+		fmt.Sprintf("fsync: %d, %d failed, last %v, max %v", v.Fsyncs, v.FsyncErrors, v.LastFsync, v.MaxFsync),
+		fmt.Sprintf("replay: %d records, %d skipped, %v", v.ReplayRecords, v.ReplaySkipped, v.ReplayDuration),
+		fmt.Sprintf("queue: %d, max %d", v.QueueDepth, v.MaxQueueDepth),
+		fmt.Sprintf("batches: %d, max %d requests", v.Batches, v.MaxBatch),
+		fmt.Sprintf("dropped events: %d", v.EventsDropped),
+		fmt.Sprintf("pending writes: %d", len(ui.lists.writer.byReq)),
+	}
+	children := make([]layout.FlexChild, len(lines))
+	for i, line := range lines {
+		label := ui.theme.StatusLabel(line)
+		children[i] = layout.Rigid(label.Layout)
+	}
+
+	border := widget.Border{Color: ui.theme.Color.Border, CornerRadius: ui.theme.Size.CornerRadius, Width: 1}
+	return ui.theme.Pad.Main.Layout(gtx, func(gtx C) D {
+		r := op.Record(gtx.Ops)
+		dim := border.Layout(gtx, func(gtx C) D {
+			return ui.theme.Pad.Button.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+			})
+		})
+		call := r.Stop()
+
+		rect := image.Rectangle{Max: dim.Size}
+		rr := clip.UniformRRect(rect, gtx.Dp(ui.theme.Size.CornerRadius))
+		paint.FillShape(gtx.Ops, ui.theme.Color.Background, rr.Op(gtx.Ops))
+		call.Add(gtx.Ops)
+		return dim
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"gioui.org/io/event"
+	"gioui.org/io/input"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/unit"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// driver runs the app without a window. It draws frames and routes input events
+// like app.Window does, so tests can use the app through the keyboard.
+type driver struct {
+	t      testing.TB
+	store  *todostore.Store
+	sub    *todostore.Subscription
+	model  *todoLists
+	ui     *todoUI
+	router input.Router
+	ops    op.Ops
+}
+
+func newDriver(t testing.TB) *driver {
+	store := todostore.NewStore(t.TempDir())
+	model := newTodoLists(store)
+	d := &driver{
+		t:     t,
+		store: store,
+		sub:   store.Subscribe(nil),
+		model: model,
+		ui:    newTodoUI(newTodoTheme(), model),
+	}
+	d.ui.transfer = &fileTransfer{store: store}
+	d.ui.passChange = &passphraseChange{store: store}
+	t.Cleanup(func() {
+		d.sub.Close()
+		store.Close()
+	})
+	d.frame()
+	return d
+}
+
+// frame applies the events of the store and draws a frame.
+func (d *driver) frame() {
+	for _, e := range d.sub.Events() {
+		d.model.handleStoreEvent(e)
+	}
+	d.ops.Reset()
+	gtx := layout.Context{
+		Ops:         &d.ops,
+		Now:         time.Now(),
+		Metric:      unit.Metric{PxPerDp: 1, PxPerSp: 1},
+		Constraints: layout.Exact(image.Pt(400, 600)),
+		Source:      d.router.Source(),
+	}
+	d.ui.Layout(gtx)
+	d.router.Frame(&d.ops)
+}
+
+// sync waits until the store has handled all changes, and applies its events.
+func (d *driver) sync() {
+	d.t.Helper()
+	if _, err := d.store.Snapshot(); err != nil {
+		d.t.Fatal(err)
+	}
+	d.frame()
+}
+
+// focus gives keyboard focus to tag.
+func (d *driver) focus(tag event.Tag) {
+	d.router.Source().Execute(key.FocusCmd{Tag: tag})
+	d.frame()
+	d.frame()
+}
+
+// press presses and releases a key.
+func (d *driver) press(name key.Name) {
+	d.router.Queue(
+		key.Event{Name: name, State: key.Press},
+		key.Event{Name: name, State: key.Release},
+	)
+	d.frame()
+	d.frame()
+}
+
+// add adds an item to the current list.
+func (d *driver) add(text string) *item {
+	d.t.Helper()
+	d.ui.todos.add(todostore.Item{Text: text})
+	d.sync()
+	items := d.ui.todos.filteredItems(filterAll, "", "")
+	return items[len(items)-1]
+}
+
+// storedText returns the stored text of an item, and whether it exists.
+func (d *driver) storedText(id todostore.ID) (string, bool) {
+	d.t.Helper()
+	snap, err := d.store.Snapshot()
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	for _, info := range snap.Items {
+		if info.ID == id {
+			return info.Item.Text, true
+		}
+	}
+	return "", false
+}
+
+// storedDone returns whether the stored item is done.
+func (d *driver) storedDone(id todostore.ID) bool {
+	d.t.Helper()
+	snap, err := d.store.Snapshot()
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	for _, info := range snap.Items {
+		if info.ID == id {
+			return info.Item.Done
+		}
+	}
+	d.t.Fatalf("item %s not stored", id)
+	return false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gioui.org/io/key"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// TestItemEditStored checks that the edited text of an item is stored as a change
+// of the text field only.
+func TestItemEditStored(t *testing.T) {
+	dir := t.TempDir()
+	store := todostore.NewStore(dir)
+	sub := store.Subscribe(nil)
+	model := newTodoLists(store)
+	ui := newTodoUI(newTodoTheme(), model)
+
+	id := store.AddItem(todostore.Item{Text: "buy milk"})
+	var it *item
+	for deadline := time.Now().Add(5 * time.Second); it == nil; {
+		for _, e := range sub.Events() {
+			model.handleStoreEvent(e)
+		}
+		if it = model.get(todostore.DefaultList).items[id]; it == nil {
+			if time.Now().After(deadline) {
+				t.Fatal("item not added")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	ui.itemBeingEdited = it
+	ui.itemEditor.SetText("buy oat milk")
+	ui.endItemEdit()
+	store.Close()
+
+	// Find the change in the data file.
+	f, err := os.Open(filepath.Join(dir, "events.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var found bool
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var rec struct {
+			Type  string
+			Event struct {
+				ID     todostore.ID
+				Item   todostore.Item
+				Fields []todostore.Field
+			}
+		}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Type != "change" || rec.Event.ID != id {
+			continue
+		}
+		found = true
+		if rec.Event.Item.Text != "buy oat milk" {
+			t.Errorf("stored text %q", rec.Event.Item.Text)
+		}
+		if want := []todostore.Field{todostore.FieldText}; !reflect.DeepEqual(rec.Event.Fields, want) {
+			t.Errorf("stored fields %v, want %v", rec.Event.Fields, want)
+		}
+	}
+	if !found {
+		t.Fatal("edit not stored")
+	}
+}
+
+// This checks that editing an item with subtasks doesn't store its derived
+// completion state.
+func TestItemEditParentDone(t *testing.T) {
+	d := newDriver(t)
+	parent := d.add("parent")
+	d.ui.todos.add(todostore.Item{Text: "sub", Parent: parent.id, Done: true})
+	d.sync()
+	if !parent.isDone {
+		t.Fatal("parent of done subtask not shown as done")
+	}
+
+	d.ui.itemBeingEdited = parent
+	d.ui.itemEditor.SetText("renamed")
+	d.ui.endItemEdit()
+	d.sync()
+	if text, _ := d.storedText(parent.id); text != "renamed" {
+		t.Fatalf("stored text %q", text)
+	}
+	if d.storedDone(parent.id) {
+		t.Fatal("derived completion was stored")
+	}
+}
+
+// setText replaces the text of the focused editor, like an input method does.
+func (d *driver) setText(text string) {
+	n := d.ui.itemEditor.Len()
+	d.router.Queue(key.EditEvent{Range: key.Range{Start: 0, End: n}, Text: text})
+	d.frame()
+}
+
+// edit focuses an item and starts editing it with the keyboard.
+func (d *driver) edit(it *item) {
+	d.t.Helper()
+	d.ui.focusItem = it
+	d.focus(&d.ui.list)
+	d.press(key.NameReturn)
+	if d.ui.itemBeingEdited != it {
+		d.t.Fatalf("editing %v, want %q", d.ui.itemBeingEdited, it.text)
+	}
+	if !d.router.Source().Focused(&d.ui.itemEditor) {
+		d.t.Fatal("editor not focused")
+	}
+}
+
+func TestItemEditSubmit(t *testing.T) {
+	d := newDriver(t)
+	it := d.add("buy milk")
+
+	d.edit(it)
+	d.setText("  buy oat milk #shop  ")
+	d.press(key.NameReturn)
+	if d.ui.itemBeingEdited != nil {
+		t.Fatal("still editing after submit")
+	}
+	if !d.router.Source().Focused(&d.ui.list) {
+		t.Fatal("list not focused after submit")
+	}
+	if text, _ := d.storedText(it.id); text != "buy oat milk" {
+		t.Fatalf("stored text %q", text)
+	}
+	if !it.stored.HasTag("shop") {
+		t.Fatalf("tags %q not applied", it.tags)
+	}
+}
+
+func TestItemEditCancel(t *testing.T) {
+	d := newDriver(t)
+	it := d.add("buy milk")
+
+	d.edit(it)
+	d.setText("something else")
+	d.press(key.NameEscape)
+	if d.ui.itemBeingEdited != nil {
+		t.Fatal("still editing after Escape")
+	}
+	if it.text != "buy milk" {
+		t.Fatalf("item text changed to %q", it.text)
+	}
+	if text, _ := d.storedText(it.id); text != "buy milk" {
+		t.Fatalf("stored text %q", text)
+	}
+	// Editing again shows the original text.
+	d.edit(it)
+	if text := d.ui.itemEditor.Text(); text != "buy milk" {
+		t.Fatalf("editor text %q", text)
+	}
+}
+
+func TestItemEditEmptyDeletes(t *testing.T) {
+	d := newDriver(t)
+	it := d.add("buy milk")
+
+	d.edit(it)
+	d.setText("   ")
+	d.press(key.NameReturn)
+	if _, ok := d.storedText(it.id); ok {
+		t.Fatal("item not removed")
+	}
+	d.sync()
+	if d.ui.todos.len() != 0 {
+		t.Fatalf("%d items shown", d.ui.todos.len())
+	}
+
+	// The removal can be undone.
+	d.model.history.undo()
+	if text, _ := d.storedText(it.id); text != "buy milk" {
+		t.Fatalf("restored text %q", text)
+	}
+}
+
+func TestItemEditFocusLoss(t *testing.T) {
+	d := newDriver(t)
+	it := d.add("buy milk")
+
+	d.edit(it)
+	d.setText("buy bread")
+	d.focus(&d.ui.mainInput)
+	if d.ui.itemBeingEdited != nil {
+		t.Fatal("still editing after focus loss")
+	}
+	if text, _ := d.storedText(it.id); text != "buy bread" {
+		t.Fatalf("stored text %q", text)
+	}
+}
@@ -0,0 +1,130 @@
+// Package itemtext implements the text syntax of todo items.
+//
+// Item attributes are entered as part of the item text. The following markers
+// are recognized when they appear as separate words:
+//
+//	#tag         adds a tag
+//	!, !!, !!!   sets the priority to low, medium or high
+//	due:DATE     sets the due date
+//	every:RULE   makes the item recur
+//
+// DATE is 'today', 'tomorrow', YYYY-MM-DD or YYYY-MM-DDTHH:MM, in local time.
+// RULE is a recurrence rule as accepted by recur.Parse, e.g. 'weekly' or 'mon,thu'.
+// Recurring items without due date are due today.
+// Everything after " // " is stored as the item notes.
+package itemtext
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/recur"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+const (
+	notesSeparator = " // "
+	dueDateFormat  = "2006-01-02"
+	dueTimeFormat  = "2006-01-02T15:04"
+)
+
+// Parse creates an item from the text typed by the user.
+func Parse(input string, now time.Time) todostore.Item {
+	var it todostore.Item
+	if i := strings.Index(input, notesSeparator); i >= 0 {
+		it.Notes = strings.TrimSpace(input[i+len(notesSeparator):])
+		input = input[:i]
+	}
+
+	var words []string
+	for _, w := range strings.Fields(input) {
+		switch {
+		case len(w) > 1 && w[0] == '#':
+			if !it.HasTag(w[1:]) {
+				it.Tags = append(it.Tags, w[1:])
+			}
+		case w == "!":
+			it.Priority = todostore.PriorityLow
+		case w == "!!":
+			it.Priority = todostore.PriorityMedium
+		case w == "!!!":
+			it.Priority = todostore.PriorityHigh
+		case strings.HasPrefix(w, "due:"):
+			if due, ok := ParseDue(w[4:], now); ok {
+				it.Due = &due
+			} else {
+				words = append(words, w)
+			}
+		case strings.HasPrefix(w, "every:"):
+			if rule, err := recur.Parse(w[6:]); err == nil {
+				it.Repeat = rule.String()
+			} else {
+				words = append(words, w)
+			}
+		default:
+			words = append(words, w)
+		}
+	}
+	it.Text = strings.Join(words, " ")
+	if it.Repeat != "" && it.Due == nil {
+		due, _ := ParseDue("today", now)
+		it.Due = &due
+	}
+	return it
+}
+
+// ParseDue parses the DATE of a due: marker.
+func ParseDue(s string, now time.Time) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch s {
+	case "today":
+		return today, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), true
+	}
+	for _, layout := range []string{dueDateFormat, dueTimeFormat} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Format is the inverse of Parse. It creates the text of an item, e.g. for editing.
+func Format(it todostore.Item) string {
+	var b strings.Builder
+	b.WriteString(it.Text)
+	switch it.Priority {
+	case todostore.PriorityLow:
+		b.WriteString(" !")
+	case todostore.PriorityMedium:
+		b.WriteString(" !!")
+	case todostore.PriorityHigh:
+		b.WriteString(" !!!")
+	}
+	for _, tag := range it.Tags {
+		b.WriteString(" #")
+		b.WriteString(tag)
+	}
+	if it.Due != nil {
+		b.WriteString(" due:")
+		b.WriteString(FormatDue(*it.Due))
+	}
+	if rule, err := recur.Parse(it.Repeat); err == nil {
+		b.WriteString(" every:")
+		b.WriteString(rule.Short())
+	}
+	if it.Notes != "" {
+		b.WriteString(notesSeparator)
+		b.WriteString(it.Notes)
+	}
+	return b.String()
+}
+
+// FormatDue formats a due date for due: markers.
+func FormatDue(t time.Time) string {
+	if h, m, s := t.Clock(); h == 0 && m == 0 && s == 0 {
+		return t.Format(dueDateFormat)
+	}
+	return t.Format(dueTimeFormat)
+}
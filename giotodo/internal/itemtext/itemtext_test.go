@@ -0,0 +1,65 @@
+package itemtext
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	due := func(s string) *time.Time {
+		t, ok := ParseDue(s, now)
+		if !ok {
+			panic("bad due " + s)
+		}
+		return &t
+	}
+	tests := []struct {
+		input string
+		want  todostore.Item
+	}{
+		{
+			input: "buy milk",
+			want:  todostore.Item{Text: "buy milk"},
+		},
+		{
+			input: "buy  milk #shop !! due:tomorrow",
+			want:  todostore.Item{Text: "buy milk", Tags: []string{"shop"}, Priority: todostore.PriorityMedium, Due: due("2026-10-19")},
+		},
+		{
+			input: "call #work #work !!! due:2026-10-20T14:30 // ask about the invoice",
+			want:  todostore.Item{Text: "call", Tags: []string{"work"}, Priority: todostore.PriorityHigh, Due: due("2026-10-20T14:30"), Notes: "ask about the invoice"},
+		},
+		{
+			input: "water plants every:thu,mon due:2026-10-19",
+			want:  todostore.Item{Text: "water plants", Due: due("2026-10-19"), Repeat: "FREQ=WEEKLY;BYDAY=MO,TH"},
+		},
+		{
+			input: "pay rent every:monthly",
+			want:  todostore.Item{Text: "pay rent", Due: due("today"), Repeat: "FREQ=MONTHLY"},
+		},
+		{
+			input: "every:sometimes",
+			want:  todostore.Item{Text: "every:sometimes"},
+		},
+		{
+			input: "keep due:someday and # alone",
+			want:  todostore.Item{Text: "keep due:someday and # alone"},
+		},
+	}
+	for _, test := range tests {
+		it := Parse(test.input, now)
+		if !reflect.DeepEqual(it, test.want) {
+			t.Errorf("Parse(%q):\n got %+v\nwant %+v", test.input, it, test.want)
+			continue
+		}
+		// Check round trip.
+		text := Format(it)
+		if it2 := Parse(text, now); !reflect.DeepEqual(it2, it) {
+			t.Errorf("round trip of %q via %q:\n got %+v\nwant %+v", test.input, text, it2, it)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+	"github.com/godbus/dbus/v5"
+)
+
+// DBus shows reminders using the freedesktop notification service.
+type DBus struct {
+	conn    *dbus.Conn
+	appName string
+}
+
+// NewDBus connects to the session bus.
+func NewDBus(appName string) (*DBus, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	return &DBus{conn: conn, appName: appName}, nil
+}
+
+// Notify implements todostore.Notifier.
+func (d *DBus) Notify(r todostore.Reminder) error {
+	title, body := Message(r)
+	obj := d.conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.Call("org.freedesktop.Notifications.Notify", 0,
+		d.appName,                 // app_name
+		uint32(0),                 // replaces_id
+		"",                        // app_icon
+		title,                     // summary
+		body,                      // body
+		[]string{},                // actions
+		map[string]dbus.Variant{}, // hints
+		int32(-1),                 // expire_timeout: server default
+	)
+	return call.Err
+}
+
+// Close disconnects from the session bus.
+func (d *DBus) Close() error {
+	return d.conn.Close()
+}
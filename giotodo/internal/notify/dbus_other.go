@@ -0,0 +1,29 @@
+//go:build !linux
+
+package notify
+
+import (
+	"errors"
+	"runtime"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// DBus shows reminders using the freedesktop notification service. It is only
+// available on Linux.
+type DBus struct{}
+
+// NewDBus returns an error on this platform.
+func NewDBus(appName string) (*DBus, error) {
+	return nil, errors.New("D-Bus notifications are not supported on " + runtime.GOOS)
+}
+
+// Notify implements todostore.Notifier.
+func (d *DBus) Notify(r todostore.Reminder) error {
+	return errors.New("not supported")
+}
+
+// Close does nothing.
+func (d *DBus) Close() error {
+	return nil
+}
@@ -0,0 +1,17 @@
+// Package notify shows reminders of todo items as desktop notifications.
+package notify
+
+import (
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// Message returns the title and body text of a reminder.
+func Message(r todostore.Reminder) (title, body string) {
+	due := *r.Item.Due
+	if h, m, s := due.Clock(); h == 0 && m == 0 && s == 0 {
+		return r.Item.Text, "Due today"
+	}
+	return r.Item.Text, "Due at " + due.Local().Format(time.Kitchen)
+}
@@ -0,0 +1,219 @@
+// Package recur implements recurrence rules of todo items.
+//
+// Rules are stored in a subset of the iCalendar RRULE syntax (RFC 5545), e.g.
+// "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH". Only FREQ, INTERVAL and BYDAY without
+// ordinals are supported. Parse also accepts the short forms used in item text:
+//
+//	daily, weekly, monthly, yearly   every day, week, month or year
+//	mon,thu                          weekly on the given days
+package recur
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Freq is the base unit of a rule.
+type Freq int
+
+const (
+	Daily Freq = iota + 1
+	Weekly
+	Monthly
+	Yearly
+)
+
+var freqNames = map[Freq]string{Daily: "DAILY", Weekly: "WEEKLY", Monthly: "MONTHLY", Yearly: "YEARLY"}
+
+var shortFreqNames = map[Freq]string{Daily: "daily", Weekly: "weekly", Monthly: "monthly", Yearly: "yearly"}
+
+// Weekday names in RRULE and short syntax, indexed by time.Weekday.
+var (
+	dayNames      = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+	shortDayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// Rule is a recurrence rule.
+type Rule struct {
+	Freq     Freq
+	Interval int            // number of Freq units between occurrences, at least 1
+	Weekdays []time.Weekday // for Weekly: the days of the week, sorted
+}
+
+// Parse parses a rule in RRULE or short syntax.
+func Parse(s string) (Rule, error) {
+	if strings.Contains(s, "=") {
+		return parseRRule(s)
+	}
+	for f, name := range shortFreqNames {
+		if s == name {
+			return Rule{Freq: f, Interval: 1}, nil
+		}
+	}
+	r := Rule{Freq: Weekly, Interval: 1}
+	for _, name := range strings.Split(s, ",") {
+		d, ok := lookupDay(shortDayNames, strings.ToLower(name))
+		if !ok {
+			return Rule{}, fmt.Errorf("invalid recurrence %q", s)
+		}
+		r.Weekdays = append(r.Weekdays, d)
+	}
+	return r.normalize(), nil
+}
+
+func parseRRule(s string) (Rule, error) {
+	var r Rule
+	for _, part := range strings.Split(strings.TrimPrefix(s, "RRULE:"), ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return Rule{}, fmt.Errorf("invalid rule part %q", part)
+		}
+		switch key {
+		case "FREQ":
+			for f, name := range freqNames {
+				if value == name {
+					r.Freq = f
+				}
+			}
+			if r.Freq == 0 {
+				return Rule{}, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return Rule{}, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+			r.Interval = n
+		case "BYDAY":
+			for _, name := range strings.Split(value, ",") {
+				d, ok := lookupDay(dayNames, name)
+				if !ok {
+					return Rule{}, fmt.Errorf("unsupported BYDAY %q", name)
+				}
+				r.Weekdays = append(r.Weekdays, d)
+			}
+		default:
+			return Rule{}, fmt.Errorf("unsupported rule part %s", key)
+		}
+	}
+	if r.Freq == 0 {
+		return Rule{}, errors.New("missing FREQ")
+	}
+	if len(r.Weekdays) > 0 && r.Freq != Weekly {
+		return Rule{}, errors.New("BYDAY is only supported with FREQ=WEEKLY")
+	}
+	if r.Interval == 0 {
+		r.Interval = 1
+	}
+	return r.normalize(), nil
+}
+
+func lookupDay(names []string, name string) (time.Weekday, bool) {
+	for i := range names {
+		if names[i] == name {
+			return time.Weekday(i), true
+		}
+	}
+	return 0, false
+}
+
+// normalize sorts the weekdays and removes duplicates.
+func (r Rule) normalize() Rule {
+	sort.Slice(r.Weekdays, func(i, j int) bool { return r.Weekdays[i] < r.Weekdays[j] })
+	days := r.Weekdays[:0]
+	for i, d := range r.Weekdays {
+		if i == 0 || d != r.Weekdays[i-1] {
+			days = append(days, d)
+		}
+	}
+	r.Weekdays = days
+	if len(r.Weekdays) == 0 {
+		r.Weekdays = nil
+	}
+	return r
+}
+
+// String returns the rule in RRULE syntax. This is the form stored in items.
+func (r Rule) String() string {
+	s := "FREQ=" + freqNames[r.Freq]
+	if r.Interval > 1 {
+		s += ";INTERVAL=" + strconv.Itoa(r.Interval)
+	}
+	if len(r.Weekdays) > 0 {
+		names := make([]string, len(r.Weekdays))
+		for i, d := range r.Weekdays {
+			names[i] = dayNames[d]
+		}
+		s += ";BYDAY=" + strings.Join(names, ",")
+	}
+	return s
+}
+
+// Short returns the rule in short syntax if possible, and in RRULE syntax otherwise.
+func (r Rule) Short() string {
+	switch {
+	case r.Interval > 1:
+		return r.String()
+	case len(r.Weekdays) > 0:
+		names := make([]string, len(r.Weekdays))
+		for i, d := range r.Weekdays {
+			names[i] = shortDayNames[d]
+		}
+		return strings.Join(names, ",")
+	default:
+		return shortFreqNames[r.Freq]
+	}
+}
+
+// Next returns the first occurrence after t. The time of day is kept. Monthly and
+// yearly rules keep the day of the month, using the last day of shorter months.
+func (r Rule) Next(t time.Time) time.Time {
+	switch r.Freq {
+	case Daily:
+		return t.AddDate(0, 0, r.Interval)
+	case Weekly:
+		if len(r.Weekdays) == 0 {
+			return t.AddDate(0, 0, 7*r.Interval)
+		}
+		// Find the next day in the current week, which starts on Monday.
+		for _, d := range r.Weekdays {
+			if weekIndex(d) > weekIndex(t.Weekday()) {
+				return t.AddDate(0, 0, weekIndex(d)-weekIndex(t.Weekday()))
+			}
+		}
+		// Otherwise use the first day of the week Interval weeks later.
+		first := r.Weekdays[0]
+		for _, d := range r.Weekdays {
+			if weekIndex(d) < weekIndex(first) {
+				first = d
+			}
+		}
+		monday := t.AddDate(0, 0, -weekIndex(t.Weekday()))
+		return monday.AddDate(0, 0, 7*r.Interval+weekIndex(first))
+	case Monthly:
+		return addMonths(t, r.Interval)
+	case Yearly:
+		return addMonths(t, 12*r.Interval)
+	}
+	panic(fmt.Errorf("invalid rule frequency %d", r.Freq))
+}
+
+// weekIndex returns the position of d in a week starting on Monday.
+func weekIndex(d time.Weekday) int {
+	return (int(d) + 6) % 7
+}
+
+// addMonths adds n months to t. Unlike time.AddDate, it doesn't overflow into the
+// following month when the day doesn't exist.
+func addMonths(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return first.AddDate(0, 0, d-1)
+}
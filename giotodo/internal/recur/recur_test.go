@@ -0,0 +1,85 @@
+package recur
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		rule  string // RRULE form, empty for errors
+		short string
+	}{
+		{"daily", "FREQ=DAILY", "daily"},
+		{"weekly", "FREQ=WEEKLY", "weekly"},
+		{"monthly", "FREQ=MONTHLY", "monthly"},
+		{"yearly", "FREQ=YEARLY", "yearly"},
+		{"thu,mon,thu", "FREQ=WEEKLY;BYDAY=MO,TH", "mon,thu"},
+		{"FREQ=DAILY;INTERVAL=3", "FREQ=DAILY;INTERVAL=3", "FREQ=DAILY;INTERVAL=3"},
+		{"RRULE:FREQ=WEEKLY;BYDAY=FR", "FREQ=WEEKLY;BYDAY=FR", "fri"},
+		{"FREQ=WEEKLY;INTERVAL=1", "FREQ=WEEKLY", "weekly"},
+		{"sometimes", "", ""},
+		{"FREQ=HOURLY", "", ""},
+		{"FREQ=DAILY;INTERVAL=0", "", ""},
+		{"FREQ=MONTHLY;BYDAY=MO", "", ""},
+		{"FREQ=WEEKLY;COUNT=3", "", ""},
+		{"INTERVAL=2", "", ""},
+	}
+	for _, test := range tests {
+		r, err := Parse(test.input)
+		if test.rule == "" {
+			if err == nil {
+				t.Errorf("Parse(%q): expected error, got %v", test.input, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.input, err)
+			continue
+		}
+		if s := r.String(); s != test.rule {
+			t.Errorf("Parse(%q) = %s, want %s", test.input, s, test.rule)
+		}
+		if s := r.Short(); s != test.short {
+			t.Errorf("Parse(%q).Short() = %s, want %s", test.input, s, test.short)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	date := func(s string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		rule       string
+		from, want string
+	}{
+		{"daily", "2026-10-18 09:30", "2026-10-19 09:30"},
+		{"FREQ=DAILY;INTERVAL=2", "2026-12-31 00:00", "2027-01-02 00:00"},
+		{"weekly", "2026-10-18 00:00", "2026-10-25 00:00"},
+		// 2026-10-18 is a Sunday, the last day of the week.
+		{"mon,thu", "2026-10-18 00:00", "2026-10-19 00:00"},
+		{"mon,thu", "2026-10-19 00:00", "2026-10-22 00:00"},
+		{"mon,thu", "2026-10-22 00:00", "2026-10-26 00:00"},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU", "2026-10-12 00:00", "2026-10-18 00:00"},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU", "2026-10-18 00:00", "2026-10-26 00:00"},
+		{"monthly", "2026-01-31 08:00", "2026-02-28 08:00"},
+		{"monthly", "2026-11-15 00:00", "2026-12-15 00:00"},
+		{"FREQ=MONTHLY;INTERVAL=3", "2026-11-30 00:00", "2027-02-28 00:00"},
+		{"yearly", "2028-02-29 00:00", "2029-02-28 00:00"},
+	}
+	for _, test := range tests {
+		r, err := Parse(test.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Next(date(test.from)); !got.Equal(date(test.want)) {
+			t.Errorf("%s: Next(%s) = %v, want %s", test.rule, test.from, got, test.want)
+		}
+	}
+}
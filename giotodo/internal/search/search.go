@@ -0,0 +1,199 @@
+// Package search implements case- and diacritic-insensitive text search.
+//
+// Text is folded before matching: letters are converted to lower case, and accents
+// are removed by decomposing characters and dropping the combining marks. "Café"
+// and "CAFE" fold to the same text.
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Fold returns the folded form of s.
+func Fold(s string) string {
+	f, _ := fold(s, false)
+	return f
+}
+
+// fold folds s. When withOffsets is true, it also returns the byte offset in s
+// of each byte of the result, plus one element for the end of the text.
+func fold(s string, withOffsets bool) (string, []int) {
+	var (
+		b       strings.Builder
+		offsets []int
+		buf     [utf8.UTFMax]byte
+	)
+	b.Grow(len(s))
+	for i, r := range s {
+		start := b.Len()
+		if r < utf8.RuneSelf {
+			// Fast path for ASCII.
+			b.WriteByte(byte(unicode.ToLower(r)))
+		} else {
+			n := utf8.EncodeRune(buf[:], r)
+			for _, dr := range norm.NFD.String(string(buf[:n])) {
+				if !unicode.Is(unicode.Mn, dr) {
+					b.WriteRune(unicode.ToLower(dr))
+				}
+			}
+		}
+		if withOffsets {
+			for j := start; j < b.Len(); j++ {
+				offsets = append(offsets, i)
+			}
+		}
+	}
+	if withOffsets {
+		offsets = append(offsets, len(s))
+	}
+	return b.String(), offsets
+}
+
+// Range is a byte range in a text.
+type Range struct {
+	Start, End int
+}
+
+// Find returns the ranges of text in which query occurs. Query must be folded.
+func Find(text, query string) []Range {
+	if query == "" {
+		return nil
+	}
+	folded, offsets := fold(text, true)
+	var ranges []Range
+	for pos := 0; pos < len(folded); {
+		i := strings.Index(folded[pos:], query)
+		if i < 0 {
+			break
+		}
+		start, end := pos+i, pos+i+len(query)
+		r := Range{offsets[start], offsets[end]}
+		// A match ending inside a decomposed character covers all of it.
+		if end < len(folded) && offsets[end] == offsets[end-1] {
+			r.End = nextRune(text, offsets[end])
+		}
+		ranges = append(ranges, r)
+		pos = end
+	}
+	return ranges
+}
+
+func nextRune(s string, i int) int {
+	_, n := utf8.DecodeRuneInString(s[i:])
+	return i + n
+}
+
+// Index finds documents containing a query. Documents are identified by keys of
+// type K. The index holds the trigrams of all documents, so that only documents
+// containing all trigrams of the query need to be checked.
+type Index[K comparable] struct {
+	docs  map[K]string              // folded text
+	grams map[string]map[K]struct{} // documents by trigram
+}
+
+// NewIndex creates an empty index.
+func NewIndex[K comparable]() *Index[K] {
+	return &Index[K]{
+		docs:  make(map[K]string),
+		grams: make(map[string]map[K]struct{}),
+	}
+}
+
+// Len returns the number of documents.
+func (ix *Index[K]) Len() int {
+	return len(ix.docs)
+}
+
+// Set adds a document or replaces its text.
+func (ix *Index[K]) Set(key K, text string) {
+	folded := Fold(text)
+	if old, ok := ix.docs[key]; ok {
+		if old == folded {
+			return
+		}
+		ix.Remove(key)
+	}
+	ix.docs[key] = folded
+	eachTrigram(folded, func(g string) {
+		set := ix.grams[g]
+		if set == nil {
+			set = make(map[K]struct{})
+			ix.grams[g] = set
+		}
+		set[key] = struct{}{}
+	})
+}
+
+// Remove removes a document.
+func (ix *Index[K]) Remove(key K) {
+	folded, ok := ix.docs[key]
+	if !ok {
+		return
+	}
+	delete(ix.docs, key)
+	eachTrigram(folded, func(g string) {
+		if set := ix.grams[g]; set != nil {
+			delete(set, key)
+			if len(set) == 0 {
+				delete(ix.grams, g)
+			}
+		}
+	})
+}
+
+// Search returns the keys of all documents containing query. The query is folded
+// before matching.
+func (ix *Index[K]) Search(query string) map[K]bool {
+	query = Fold(query)
+	result := make(map[K]bool)
+
+	// Find the trigram with the fewest documents. Queries shorter than a trigram
+	// check all documents.
+	var candidates map[K]struct{}
+	eachTrigram(query, func(g string) {
+		set := ix.grams[g]
+		if candidates == nil || len(set) < len(candidates) {
+			candidates = set
+			if candidates == nil {
+				candidates = map[K]struct{}{}
+			}
+		}
+	})
+	if candidates == nil {
+		for key, doc := range ix.docs {
+			if strings.Contains(doc, query) {
+				result[key] = true
+			}
+		}
+		return result
+	}
+	for key := range candidates {
+		if strings.Contains(ix.docs[key], query) {
+			result[key] = true
+		}
+	}
+	return result
+}
+
+// Match reports whether the document contains query. Query must be folded.
+func (ix *Index[K]) Match(key K, query string) bool {
+	doc, ok := ix.docs[key]
+	return ok && strings.Contains(doc, query)
+}
+
+// eachTrigram calls fn for each sequence of three runes in s.
+func eachTrigram(s string, fn func(string)) {
+	var starts [3]int
+	n := 0
+	for i := range s {
+		starts[n%3] = i
+		n++
+		if n >= 3 {
+			fn(s[starts[(n-3)%3]:nextRune(s, i)])
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package search
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct{ input, want string }{
+		{"Buy MILK", "buy milk"},
+		{"Café crème", "cafe creme"},
+		{"Ærøskøbing", "ærøskøbing"}, // no decomposition
+		{"naïve Zürich", "naive zurich"},
+		{"Ελληνικά", "ελληνικα"},
+	}
+	for _, test := range tests {
+		if got := Fold(test.input); got != test.want {
+			t.Errorf("Fold(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		text, query string
+		want        []Range
+	}{
+		{"buy milk", "milk", []Range{{4, 8}}},
+		{"Café CAFE", "cafe", []Range{{0, 5}, {6, 10}}},
+		{"crème brûlée", "brule", []Range{{7, 14}}},
+		{"aaaa", "aa", []Range{{0, 2}, {2, 4}}},
+		{"buy milk", "tea", nil},
+		{"buy milk", "", nil},
+		{"café", "cafe", []Range{{0, 5}}},
+		{"cafe", "café", []Range{{0, 4}}},
+	}
+	for _, test := range tests {
+		got := Find(test.text, Fold(test.query))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Find(%q, %q) = %v, want %v", test.text, test.query, got, test.want)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	ix := NewIndex[int]()
+	ix.Set(1, "Buy milk")
+	ix.Set(2, "Call Zoë")
+	ix.Set(3, "buy café")
+	ix.Set(4, "mi")
+
+	check := func(query string, want ...int) {
+		t.Helper()
+		wantSet := make(map[int]bool)
+		for _, k := range want {
+			wantSet[k] = true
+		}
+		if got := ix.Search(query); !reflect.DeepEqual(got, wantSet) {
+			t.Errorf("Search(%q) = %v, want %v", query, got, wantSet)
+		}
+	}
+	check("buy", 1, 3)
+	check("BUY M", 1)
+	check("zoe", 2)
+	check("CAFÉ", 3)
+	check("mi", 1, 4)
+	check("", 1, 2, 3, 4)
+	check("xyz")
+
+	ix.Set(1, "Buy tea")
+	check("milk")
+	check("tea", 1)
+	ix.Remove(3)
+	check("buy", 1)
+	if len(ix.grams["caf"]) != 0 {
+		t.Error("trigrams of removed document not deleted")
+	}
+	if ix.Len() != 3 {
+		t.Errorf("wrong Len %d", ix.Len())
+	}
+}
+
+func BenchmarkIndexSearch(b *testing.B) {
+	ix := NewIndex[int]()
+	for i := 0; i < 50000; i++ {
+		ix.Set(i, fmt.Sprintf("item number %d with some text", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ix.Search("number 4242")
+	}
+}
@@ -0,0 +1,211 @@
+// Package syncserver implements the server side of the todostore sync protocol.
+//
+// The server stores the records sent by devices in arrival order, and does not
+// interpret their content. Conflicts are resolved by the devices.
+//
+// The server has no accounts: anyone who can send requests to it can read and write
+// all records, which are not encrypted. Unless a token is required (see RequireToken),
+// the server must only be reachable from localhost or a trusted network. The token is
+// sent in the clear, so on other networks the server should be behind an HTTPS proxy.
+package syncserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// maxPull is the maximum number of records returned by a single pull request.
+const maxPull = 1000
+
+// maxPushSize is the maximum size of a push request body.
+const maxPushSize = 16 << 20
+
+// Server is the sync server. It implements http.Handler.
+type Server struct {
+	mu      sync.Mutex
+	events  []json.RawMessage
+	devices map[todostore.ID]uint64
+	file    *os.File
+	token   string
+}
+
+// New creates a server which keeps records in memory only.
+func New() *Server {
+	return &Server{devices: make(map[todostore.ID]uint64)}
+}
+
+// Open creates a server which stores records in the given file.
+// Records already in the file are loaded.
+func Open(filename string) (*Server, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := New()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxPushSize)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.Clone(scanner.Bytes())
+		if err := s.add(data); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.file = f
+	return s, nil
+}
+
+// Close closes the server's data file.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// RequireToken makes the server reject requests which don't have token as the password
+// of HTTP basic authentication. Clients put it into the server URL, as in
+// http://:token@host:8080. This must be called before the server handles requests.
+func (s *Server) RequireToken(token string) {
+	s.token = token
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		_, password, _ := r.BasicAuth()
+		if subtle.ConstantTimeCompare([]byte(password), []byte(s.token)) != 1 {
+			w.Header().Set("www-authenticate", `Basic realm="todosync"`)
+			http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.URL.Path != "/events" {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		s.handlePull(w, r)
+	case "POST":
+		s.handlePush(w, r)
+	default:
+		w.Header().Set("allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handlePull(w http.ResponseWriter, r *http.Request) {
+	var after uint64
+	if v := r.URL.Query().Get("after"); v != "" {
+		var err error
+		if after, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "invalid 'after' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	resp := &todostore.PullResponse{Devices: s.copyDevices()}
+	if after < uint64(len(s.events)) {
+		end := after + maxPull
+		if end >= uint64(len(s.events)) {
+			end = uint64(len(s.events))
+		} else {
+			resp.More = true
+		}
+		resp.Events = s.events[after:end]
+		resp.Next = end
+	} else {
+		resp.Next = uint64(len(s.events))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, resp)
+}
+
+func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
+	var req todostore.PushRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, data := range req.Events {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, data); err != nil {
+			http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.store(buf.Bytes()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, &todostore.PushResponse{Devices: s.copyDevices()})
+}
+
+// store adds a record and writes it to the data file.
+// Records which the server already has are ignored.
+func (s *Server) store(data []byte) error {
+	n := len(s.events)
+	if err := s.add(data); err != nil {
+		return err
+	}
+	if s.file == nil || len(s.events) == n {
+		return nil
+	}
+	_, err := s.file.Write(append(data, '\n'))
+	return err
+}
+
+// add adds a record to the log.
+func (s *Server) add(data []byte) error {
+	var st struct {
+		Device todostore.ID `json:"dev"`
+		Seq    uint64       `json:"seq"`
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	if st.Device == "" || st.Seq == 0 {
+		return fmt.Errorf("event has no device or sequence number")
+	}
+	// Sequence numbers can have gaps because compaction drops records from the data
+	// file before they are pushed. They are still sent in order though.
+	if st.Seq <= s.devices[st.Device] {
+		return nil // duplicate
+	}
+	s.devices[st.Device] = st.Seq
+	s.events = append(s.events, data)
+	return nil
+}
+
+func (s *Server) copyDevices() map[todostore.ID]uint64 {
+	m := make(map[todostore.ID]uint64, len(s.devices))
+	for dev, seq := range s.devices {
+		m[dev] = seq
+	}
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("content-type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
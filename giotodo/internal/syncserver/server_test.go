@@ -0,0 +1,239 @@
+package syncserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+const testSyncInterval = 10 * time.Millisecond
+
+func TestSync(t *testing.T) {
+	srv := httptest.NewServer(New())
+	defer srv.Close()
+	dirA, dirB := t.TempDir(), t.TempDir()
+
+	// Items added on one device appear on the other.
+	a := openDevice(t, dirA, srv.URL)
+	b := openDevice(t, dirB, srv.URL)
+	id1 := a.store.AddItem(todostore.Item{Text: "one"})
+	id2 := a.store.AddItem(todostore.Item{Text: "two"})
+	b.waitFor(t, func(items map[todostore.ID]todostore.Item) bool {
+		return items[id1].Text == "one" && items[id2].Text == "two"
+	})
+	a.close()
+	b.close()
+
+	// Make conflicting changes while offline.
+	a = openDevice(t, dirA, "")
+	b = openDevice(t, dirB, "")
+	a.waitFor(t, hasItems(id1, id2))
+	b.waitFor(t, hasItems(id1, id2))
+	b.store.UpdateItem(id1, todostore.Item{Text: "one from B"})
+	a.store.RemoveItem(id2)
+	b.waitFor(t, func(items map[todostore.ID]todostore.Item) bool {
+		return items[id1].Text == "one from B"
+	})
+	time.Sleep(5 * time.Millisecond)
+	a.store.UpdateItem(id1, todostore.Item{Text: "one from A"})
+	b.store.UpdateItem(id2, todostore.Item{Text: "two from B"})
+	a.waitFor(t, func(items map[todostore.ID]todostore.Item) bool {
+		return items[id1].Text == "one from A"
+	})
+	b.waitFor(t, func(items map[todostore.ID]todostore.Item) bool {
+		return items[id2].Text == "two from B"
+	})
+	a.close()
+	b.close()
+
+	// After syncing, the later edit wins and the removal wins against the edit.
+	a = openDevice(t, dirA, srv.URL)
+	b = openDevice(t, dirB, srv.URL)
+	defer a.close()
+	defer b.close()
+	want := map[todostore.ID]todostore.Item{id1: {Text: "one from A"}}
+	for _, dev := range []*device{a, b} {
+		dev.waitFor(t, func(items map[todostore.ID]todostore.Item) bool {
+			return reflect.DeepEqual(items, want)
+		})
+	}
+}
+
+func TestSyncStatus(t *testing.T) {
+	srv := httptest.NewServer(New())
+	dev := openDevice(t, t.TempDir(), srv.URL)
+	defer dev.close()
+	dev.store.AddItem(todostore.Item{Text: "x"})
+	dev.waitForPush(t, 1)
+
+	srv.Close()
+	dev.store.AddItem(todostore.Item{Text: "y"})
+	dev.waitForStatus(t, func(s *todostore.SyncStatus) bool { return s.Err != nil })
+}
+
+func TestServerPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sync.json")
+	s, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	dev := openDevice(t, t.TempDir(), srv.URL)
+	id := dev.store.AddItem(todostore.Item{Text: "x"})
+	dev.waitForPush(t, 1)
+	dev.close()
+	srv.Close()
+	s.Close()
+
+	// Reopen the server. A new device should receive the item.
+	if s, err = Open(file); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	srv = httptest.NewServer(s)
+	defer srv.Close()
+	dev = openDevice(t, t.TempDir(), srv.URL)
+	defer dev.close()
+	dev.waitFor(t, hasItems(id))
+}
+
+// device is a store with a view of its items.
+type device struct {
+	store  *todostore.Store
+	sub    *todostore.Subscription
+	items  map[todostore.ID]todostore.Item
+	status *todostore.SyncStatus // latest status
+	pushed int                   // total pushed events
+}
+
+func openDevice(t *testing.T, dir, url string) *device {
+	t.Helper()
+	dev := &device{
+		store: todostore.NewStore(dir),
+		items: make(map[todostore.ID]todostore.Item),
+	}
+	dev.sub = dev.store.Subscribe(nil)
+	if url != "" {
+		dev.store.StartSync(url, testSyncInterval)
+	}
+	return dev
+}
+
+func (dev *device) close() {
+	dev.store.Close()
+}
+
+// update applies pending store events to the view.
+func (dev *device) update(t *testing.T) {
+	for _, ev := range dev.sub.Events() {
+		switch ev := ev.(type) {
+		case *todostore.ItemAdded:
+			dev.items[ev.ID] = ev.Item
+		case *todostore.ItemChanged:
+			dev.items[ev.ID] = ev.Item
+		case *todostore.ItemRemoved:
+			delete(dev.items, ev.ID)
+		case *todostore.IOError:
+			t.Fatal("store error:", ev.Err)
+		case *todostore.SyncStatus:
+			dev.status = ev
+			dev.pushed += ev.Pushed
+		}
+	}
+}
+
+func (dev *device) waitFor(t *testing.T, cond func(map[todostore.ID]todostore.Item) bool) {
+	t.Helper()
+	dev.wait(t, func() bool { return cond(dev.items) })
+}
+
+func (dev *device) waitForPush(t *testing.T, n int) {
+	t.Helper()
+	dev.wait(t, func() bool { return dev.pushed >= n })
+}
+
+func (dev *device) waitForStatus(t *testing.T, cond func(*todostore.SyncStatus) bool) {
+	t.Helper()
+	dev.wait(t, func() bool { return dev.status != nil && cond(dev.status) })
+}
+
+func (dev *device) wait(t *testing.T, cond func() bool) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		dev.update(t)
+		if cond() {
+			return
+		}
+		select {
+		case <-dev.sub.Ready():
+		case <-timeout:
+			t.Fatalf("timeout: items %v, sync status %+v", dev.items, dev.status)
+		}
+	}
+}
+
+func hasItems(ids ...todostore.ID) func(map[todostore.ID]todostore.Item) bool {
+	return func(items map[todostore.ID]todostore.Item) bool {
+		for _, id := range ids {
+			if _, ok := items[id]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestServerToken(t *testing.T) {
+	s := New()
+	s.RequireToken("secret")
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	for _, u := range []string{srv.URL, strings.Replace(srv.URL, "://", "://:wrong@", 1)} {
+		resp, err := http.Get(u + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("status %s without valid token", resp.Status)
+		}
+	}
+
+	// Devices sync when the token is in the URL.
+	url := strings.Replace(srv.URL, "://", "://:secret@", 1)
+	a := openDevice(t, t.TempDir(), url)
+	defer a.close()
+	b := openDevice(t, t.TempDir(), url)
+	defer b.close()
+	id := a.store.AddItem(todostore.Item{Text: "x"})
+	b.waitFor(t, hasItems(id))
+}
+
+func TestServerFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes on windows")
+	}
+	file := filepath.Join(t.TempDir(), "sync.json")
+	s, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		t.Fatalf("data file has mode %v", mode)
+	}
+}
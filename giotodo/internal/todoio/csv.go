@@ -0,0 +1,135 @@
+package todoio
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/itemtext"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+var csvHeader = []string{"list", "text", "done", "due", "priority", "tags", "notes"}
+
+var priorityNames = map[todostore.Priority]string{
+	todostore.PriorityNone:   "",
+	todostore.PriorityLow:    "low",
+	todostore.PriorityMedium: "medium",
+	todostore.PriorityHigh:   "high",
+}
+
+func writeCSV(w io.Writer, lists []List) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, l := range lists {
+		for _, it := range l.Items {
+			var due string
+			if it.Due != nil {
+				due = itemtext.FormatDue(*it.Due)
+			}
+			cw.Write([]string{
+				l.Name,
+				it.Text,
+				strconv.FormatBool(it.Done),
+				due,
+				priorityNames[it.Priority],
+				strings.Join(it.Tags, " "),
+				it.Notes,
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// readCSV reads CSV with a header row. Columns are identified by name, and
+// unknown columns are ignored. Only the text column is required.
+func readCSV(r io.Reader) ([]List, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	col := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "title":
+			name = "text"
+		case "completed":
+			name = "done"
+		}
+		col[name] = i
+	}
+	if _, ok := col["text"]; !ok {
+		return nil, fmt.Errorf("CSV header has no 'text' column")
+	}
+
+	var (
+		lists []List
+		index = make(map[string]int)
+		now   = time.Now()
+	)
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return lists, nil
+		} else if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := col[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		line, _ := cr.FieldPos(0)
+
+		it := todostore.Item{Text: field("text"), Notes: field("notes")}
+		if tags := strings.Fields(field("tags")); len(tags) > 0 {
+			it.Tags = tags
+		}
+		switch strings.ToLower(field("done")) {
+		case "", "false", "0", "no":
+		case "true", "1", "x", "yes":
+			it.Done = true
+		default:
+			return nil, fmt.Errorf("line %d: invalid 'done' value %q", line, field("done"))
+		}
+		if s := field("due"); s != "" {
+			due, ok := itemtext.ParseDue(s, now)
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid due date %q", line, s)
+			}
+			it.Due = &due
+		}
+		if it.Priority, err = parsePriorityName(field("priority")); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		name := field("list")
+		i, ok := index[name]
+		if !ok {
+			i = len(lists)
+			index[name] = i
+			lists = append(lists, List{Name: name})
+		}
+		lists[i].Items = append(lists[i].Items, it)
+	}
+}
+
+func parsePriorityName(s string) (todostore.Priority, error) {
+	s = strings.ToLower(s)
+	for p, name := range priorityNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid priority %q", s)
+}
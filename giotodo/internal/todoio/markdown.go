@@ -0,0 +1,75 @@
+package todoio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/itemtext"
+)
+
+// Markdown files contain a checklist for each list, under a heading with the list
+// name. Item attributes use the syntax of the giotodo input line, e.g.
+//
+//	# Groceries
+//
+//	- [ ] buy milk #shop due:2026-10-18
+//	- [x] eggs
+
+var (
+	mdHeading = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	mdItem    = regexp.MustCompile(`^\s*[-*+]\s+\[([ xX])\]\s+(.*)$`)
+)
+
+func writeMarkdown(w io.Writer, lists []List) error {
+	bw := bufio.NewWriter(w)
+	for i, l := range lists {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		if l.Name != "" {
+			fmt.Fprintf(bw, "# %s\n\n", l.Name)
+		}
+		for _, it := range l.Items {
+			check := " "
+			if it.Done {
+				check = "x"
+			}
+			it.Notes = strings.Join(strings.Fields(it.Notes), " ")
+			fmt.Fprintf(bw, "- [%s] %s\n", check, itemtext.Format(it))
+		}
+	}
+	return bw.Flush()
+}
+
+func readMarkdown(r io.Reader) ([]List, error) {
+	var (
+		lists   []List
+		current = -1
+		now     = time.Now()
+		scanner = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := mdHeading.FindStringSubmatch(line); m != nil {
+			lists = append(lists, List{Name: m[1]})
+			current = len(lists) - 1
+			continue
+		}
+		m := mdItem.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if current < 0 {
+			lists = append(lists, List{})
+			current = 0
+		}
+		it := itemtext.Parse(m[2], now)
+		it.Done = m[1] != " "
+		lists[current].Items = append(lists[current].Items, it)
+	}
+	return lists, scanner.Err()
+}
@@ -0,0 +1,203 @@
+// Package todoio imports and exports todo items in the file formats of other
+// todo apps.
+package todoio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// Format is a file format.
+type Format string
+
+const (
+	TodoMVC  Format = "todomvc"  // JSON stored in localStorage by TodoMVC apps
+	CSV      Format = "csv"      // comma-separated values with a header row
+	Markdown Format = "markdown" // checklists, one per list
+	TodoTxt  Format = "todotxt"  // see http://todotxt.org
+)
+
+// Formats lists all supported formats.
+var Formats = []Format{TodoMVC, CSV, Markdown, TodoTxt}
+
+var formatExt = map[Format]string{
+	TodoMVC:  ".json",
+	CSV:      ".csv",
+	Markdown: ".md",
+	TodoTxt:  ".txt",
+}
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q", name)
+}
+
+// FormatOf returns the format of a file based on its name.
+func FormatOf(filename string) (Format, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".markdown" {
+		return Markdown, true
+	}
+	for f, fext := range formatExt {
+		if ext == fext {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Ext returns the file name extension of the format.
+func (f Format) Ext() string {
+	return formatExt[f]
+}
+
+// List is a todo list in a file.
+type List struct {
+	Name  string // empty for items without list
+	Items []todostore.Item
+}
+
+// Export writes lists in the given format. The List field of the items is ignored.
+// Formats that don't support multiple lists contain the items of all lists.
+func Export(w io.Writer, f Format, lists []List) error {
+	switch f {
+	case TodoMVC:
+		return writeTodoMVC(w, lists)
+	case CSV:
+		return writeCSV(w, lists)
+	case Markdown:
+		return writeMarkdown(w, lists)
+	case TodoTxt:
+		return writeTodoTxt(w, lists)
+	}
+	return fmt.Errorf("unknown format %q", f)
+}
+
+// Import reads lists in the given format.
+func Import(r io.Reader, f Format) ([]List, error) {
+	switch f {
+	case TodoMVC:
+		return readTodoMVC(r)
+	case CSV:
+		return readCSV(r)
+	case Markdown:
+		return readMarkdown(r)
+	case TodoTxt:
+		return readTodoTxt(r)
+	}
+	return nil, fmt.Errorf("unknown format %q", f)
+}
+
+// FromSnapshot returns the lists of a store snapshot for export.
+// DefaultList is exported with the given name.
+func FromSnapshot(snap *todostore.Snapshot, defaultName string) []List {
+	lists := make([]List, len(snap.Lists))
+	index := make(map[todostore.ID]int, len(snap.Lists))
+	for i, l := range snap.Lists {
+		lists[i].Name = l.Name
+		if l.ID == todostore.DefaultList {
+			lists[i].Name = defaultName
+		}
+		index[l.ID] = i
+	}
+	for _, it := range snap.Items {
+		l := &lists[index[it.Item.List]]
+		l.Items = append(l.Items, it.Item)
+	}
+	return lists
+}
+
+// Added describes the lists and items created by Add.
+type Added struct {
+	Lists []todostore.ListInfo
+	Items []todostore.ItemInfo
+}
+
+// Add adds imported lists to the store. Items are added to the list of the same
+// name, which is created if it doesn't exist yet. Items of lists without name go
+// to the target list. The lookup function returns the ID of an existing list.
+// The items of each list are added by a single bulk change.
+func Add(store *todostore.Store, lists []List, target todostore.ID, lookup func(name string) (todostore.ID, bool)) *Added {
+	addList := func(name string) (todostore.ID, error) {
+		return store.AddList(name), nil
+	}
+	addItems := func(changes []todostore.Event) error {
+		store.Bulk(changes...)
+		return nil
+	}
+	added, _ := add(lists, target, lookup, addList, addItems)
+	return added
+}
+
+// AddContext is like Add, but waits until the lists and items are stored. When a
+// change fails, it returns the error and the lists and items stored before it.
+func AddContext(ctx context.Context, store *todostore.Store, lists []List, target todostore.ID, lookup func(name string) (todostore.ID, bool)) (*Added, error) {
+	addList := func(name string) (todostore.ID, error) {
+		return store.AddListContext(ctx, name)
+	}
+	addItems := func(changes []todostore.Event) error {
+		return store.BulkContext(ctx, changes...)
+	}
+	return add(lists, target, lookup, addList, addItems)
+}
+
+func add(lists []List, target todostore.ID, lookup func(string) (todostore.ID, bool), addList func(string) (todostore.ID, error), addItems func([]todostore.Event) error) (*Added, error) {
+	added := new(Added)
+	created := make(map[string]todostore.ID)
+	for _, l := range lists {
+		id := target
+		if l.Name != "" {
+			var ok bool
+			if id, ok = lookup(l.Name); !ok {
+				if id, ok = created[l.Name]; !ok {
+					var err error
+					if id, err = addList(l.Name); err != nil {
+						return added, err
+					}
+					created[l.Name] = id
+					added.Lists = append(added.Lists, todostore.ListInfo{ID: id, Name: l.Name})
+				}
+			}
+		}
+		if len(l.Items) == 0 {
+			continue
+		}
+		changes := make([]todostore.Event, len(l.Items))
+		infos := make([]todostore.ItemInfo, len(l.Items))
+		for i, it := range l.Items {
+			it.List = id
+			infos[i] = todostore.ItemInfo{ID: todostore.NewID(), Item: it}
+			changes[i] = &todostore.ItemAdded{ID: infos[i].ID, Item: it}
+		}
+		if err := addItems(changes); err != nil {
+			return added, err
+		}
+		added.Items = append(added.Items, infos...)
+	}
+	return added, nil
+}
+
+// SnapshotLookup returns a lookup function for Add, which finds lists in a snapshot.
+func SnapshotLookup(snap *todostore.Snapshot, defaultName string) func(string) (todostore.ID, bool) {
+	return func(name string) (todostore.ID, bool) {
+		if name == defaultName {
+			return todostore.DefaultList, true
+		}
+		for _, l := range snap.Lists {
+			if l.ID != todostore.DefaultList && l.Name == name {
+				return l.ID, true
+			}
+		}
+		return "", false
+	}
+}
@@ -0,0 +1,212 @@
+package todoio
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+func testLists() []List {
+	due := time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local)
+	dueTime := time.Date(2026, 10, 20, 14, 30, 0, 0, time.Local)
+	return []List{
+		{Name: "Todo", Items: []todostore.Item{
+			{Text: "buy milk", Tags: []string{"shop"}, Due: &due},
+			{Text: "call mom", Done: true, Priority: todostore.PriorityHigh},
+		}},
+		{Name: "Work stuff", Items: []todostore.Item{
+			{Text: "write report", Priority: todostore.PriorityMedium, Due: &dueTime, Notes: "see mail, from: Bob"},
+			{Text: "file taxes", Done: true, Tags: []string{"home", "money"}, Priority: todostore.PriorityLow},
+		}},
+		{Name: "Empty"},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, f := range []Format{CSV, Markdown, TodoTxt} {
+		var buf bytes.Buffer
+		if err := Export(&buf, f, testLists()); err != nil {
+			t.Fatalf("%s: export error: %v", f, err)
+		}
+		lists, err := Import(&buf, f)
+		if err != nil {
+			t.Fatalf("%s: import error: %v", f, err)
+		}
+		want := testLists()
+		if f != Markdown {
+			want = want[:2] // empty lists are lost
+		}
+		if !reflect.DeepEqual(lists, want) {
+			t.Errorf("%s: wrong lists after round trip\n got %+v\nwant %+v\nexported:\n%s", f, lists, want, buf.String())
+		}
+	}
+}
+
+func TestTodoMVC(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, TodoMVC, testLists()); err != nil {
+		t.Fatal(err)
+	}
+	lists, err := Import(&buf, TodoMVC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []List{{Items: []todostore.Item{
+		{Text: "buy milk"},
+		{Text: "call mom", Done: true},
+		{Text: "write report"},
+		{Text: "file taxes", Done: true},
+	}}}
+	if !reflect.DeepEqual(lists, want) {
+		t.Errorf("wrong lists\n got %+v\nwant %+v", lists, want)
+	}
+
+	// The vanilla JS version stores an object.
+	input := `{"todos":[{"title":"a","completed":true,"id":"123"},{"title":"b","completed":false,"id":"456"}]}`
+	lists, err = Import(strings.NewReader(input), TodoMVC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []List{{Items: []todostore.Item{{Text: "a", Done: true}, {Text: "b"}}}}
+	if !reflect.DeepEqual(lists, want) {
+		t.Errorf("wrong lists\n got %+v\nwant %+v", lists, want)
+	}
+}
+
+func TestImport(t *testing.T) {
+	due := time.Date(2011, 3, 2, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		format Format
+		input  string
+		want   []List
+	}{
+		{
+			format: TodoTxt,
+			input: "(A) Call Mom @phone +family\n" +
+				"x 2011-03-03 2011-03-01 Review proposal pri:B\n" +
+				"\n" +
+				"2011-03-01 (B) not a priority due:2011-03-02\n",
+			want: []List{{Items: []todostore.Item{
+				{Text: "Call Mom", Priority: todostore.PriorityHigh, Tags: []string{"phone", "family"}},
+				{Text: "Review proposal", Done: true, Priority: todostore.PriorityMedium},
+				{Text: "(B) not a priority", Due: &due},
+			}}},
+		},
+		{
+			format: Markdown,
+			input: "Some text.\n" +
+				"* [X] first\n" +
+				"## Next ##\n" +
+				"  - [ ] second !\n" +
+				"- not an item\n",
+			want: []List{
+				{Items: []todostore.Item{{Text: "first", Done: true}}},
+				{Name: "Next", Items: []todostore.Item{{Text: "second", Priority: todostore.PriorityLow}}},
+			},
+		},
+		{
+			format: CSV,
+			input:  "Title,Completed,Other\n\"a, b\",yes,1\nc,,\n",
+			want: []List{{Items: []todostore.Item{
+				{Text: "a, b", Done: true},
+				{Text: "c"},
+			}}},
+		},
+	}
+	for _, test := range tests {
+		lists, err := Import(strings.NewReader(test.input), test.format)
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(lists, test.want) {
+			t.Errorf("%s: wrong lists\n got %+v\nwant %+v", test.format, lists, test.want)
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []struct {
+		format Format
+		input  string
+		err    string
+	}{
+		{CSV, "a,b\n1,2\n", "CSV header has no 'text' column"},
+		{CSV, "text,done\nx,maybe\n", `line 2: invalid 'done' value "maybe"`},
+		{TodoTxt, "a\nb due:soon\n", `line 2: invalid due date "soon"`},
+		{TodoMVC, `[{"title":1}]`, "invalid TodoMVC data: json: cannot unmarshal number"},
+	}
+	for _, test := range tests {
+		_, err := Import(strings.NewReader(test.input), test.format)
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: wrong error %v, want %q", test.format, err, test.err)
+		}
+	}
+}
+
+// This checks that imported items are added to the store, and that exporting
+// the store returns them.
+func TestAddAndExport(t *testing.T) {
+	dir := t.TempDir()
+	store := todostore.NewStore(dir)
+	work := store.AddList("Work stuff")
+	store.AddItem(todostore.Item{Text: "existing", List: work})
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := Add(store, testLists(), todostore.DefaultList, SnapshotLookup(snap, "Todo"))
+	if len(added.Items) != 4 || len(added.Lists) != 1 || added.Lists[0].Name != "Empty" {
+		t.Fatalf("wrong result %+v", added)
+	}
+	store.Close()
+
+	// Reopen to check that the items were stored.
+	store = todostore.NewStore(dir)
+	defer store.Close()
+	if snap, err = store.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	want := testLists()
+	want[1].Items = append([]todostore.Item{{Text: "existing"}}, want[1].Items...)
+	var got, wantOutput bytes.Buffer
+	Export(&got, Markdown, FromSnapshot(snap, "Todo"))
+	Export(&wantOutput, Markdown, want)
+	if got.String() != wantOutput.String() {
+		t.Errorf("wrong export\n got %s\nwant %s", got.String(), wantOutput.String())
+	}
+}
+
+func TestAddContext(t *testing.T) {
+	store := todostore.NewStore(t.TempDir())
+	snap, err := store.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := SnapshotLookup(snap, "Todo")
+	added, err := AddContext(context.Background(), store, testLists(), todostore.DefaultList, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap, err = store.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Items) != len(added.Items) || len(added.Items) != 4 {
+		t.Fatalf("%d items stored, %d added", len(snap.Items), len(added.Items))
+	}
+
+	// Writes to a closed store fail.
+	store.Close()
+	added, err = AddContext(context.Background(), store, testLists(), todostore.DefaultList, lookup)
+	if err == nil {
+		t.Fatal("no error for closed store")
+	}
+	if len(added.Items) != 0 {
+		t.Fatalf("%d items reported as added", len(added.Items))
+	}
+}
@@ -0,0 +1,58 @@
+package todoio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// todoMVCItem is an item as stored by TodoMVC apps. Most implementations store
+// an array of these. The vanilla JS version wraps it in an object.
+type todoMVCItem struct {
+	ID        any    `json:"id,omitempty"`
+	Title     string `json:"title"`
+	Completed bool   `json:"completed"`
+}
+
+type todoMVCObject struct {
+	Todos []todoMVCItem `json:"todos"`
+}
+
+func writeTodoMVC(w io.Writer, lists []List) error {
+	items := make([]todoMVCItem, 0)
+	for _, l := range lists {
+		for _, it := range l.Items {
+			items = append(items, todoMVCItem{ID: len(items) + 1, Title: it.Text, Completed: it.Done})
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(items)
+}
+
+func readTodoMVC(r io.Reader) ([]List, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var items []todoMVCItem
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' {
+		var obj todoMVCObject
+		err = json.Unmarshal(data, &obj)
+		items = obj.Todos
+	} else {
+		err = json.Unmarshal(data, &items)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid TodoMVC data: %v", err)
+	}
+
+	var list List
+	for _, item := range items {
+		list.Items = append(list.Items, todostore.Item{Text: item.Title, Done: item.Completed})
+	}
+	return []List{list}, nil
+}
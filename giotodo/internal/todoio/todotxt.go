@@ -0,0 +1,151 @@
+package todoio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/itemtext"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// In todo.txt files, tags are written as +project. Contexts (@context) are also
+// imported as tags. Priorities map to (A), (B) and (C). The due date, list and notes
+// are stored as key:value pairs with the keys due, list and note.
+
+var (
+	todoTxtPriority = regexp.MustCompile(`^\(([A-Z])\)\s+`)
+	todoTxtDate     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}\s+`)
+)
+
+var priorityLetters = map[todostore.Priority]string{
+	todostore.PriorityLow:    "C",
+	todostore.PriorityMedium: "B",
+	todostore.PriorityHigh:   "A",
+}
+
+func writeTodoTxt(w io.Writer, lists []List) error {
+	bw := bufio.NewWriter(w)
+	for _, l := range lists {
+		for _, it := range l.Items {
+			pri := priorityLetters[it.Priority]
+			// Completed tasks keep their priority in a pri: tag, by convention.
+			switch {
+			case it.Done:
+				bw.WriteString("x ")
+			case pri != "":
+				bw.WriteString("(" + pri + ") ")
+			}
+			bw.WriteString(it.Text)
+			for _, tag := range it.Tags {
+				bw.WriteString(" +" + tag)
+			}
+			if it.Due != nil {
+				bw.WriteString(" due:" + itemtext.FormatDue(*it.Due))
+			}
+			if l.Name != "" {
+				bw.WriteString(" list:" + url.PathEscape(l.Name))
+			}
+			if it.Notes != "" {
+				bw.WriteString(" note:" + url.PathEscape(it.Notes))
+			}
+			if it.Done && pri != "" {
+				bw.WriteString(" pri:" + pri)
+			}
+			bw.WriteString("\n")
+		}
+	}
+	return bw.Flush()
+}
+
+func readTodoTxt(r io.Reader) ([]List, error) {
+	var (
+		lists   []List
+		index   = make(map[string]int)
+		now     = time.Now()
+		scanner = bufio.NewScanner(r)
+	)
+	for line := 1; scanner.Scan(); line++ {
+		it, list, err := parseTodoTxt(scanner.Text(), now)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if it == nil {
+			continue
+		}
+		i, ok := index[list]
+		if !ok {
+			i = len(lists)
+			index[list] = i
+			lists = append(lists, List{Name: list})
+		}
+		lists[i].Items = append(lists[i].Items, *it)
+	}
+	return lists, scanner.Err()
+}
+
+// parseTodoTxt parses a single task. It returns a nil item for empty lines.
+func parseTodoTxt(s string, now time.Time) (it *todostore.Item, list string, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, "", nil
+	}
+	it = new(todostore.Item)
+	if strings.HasPrefix(s, "x ") {
+		it.Done = true
+		s = strings.TrimLeft(s[2:], " ")
+		s = todoTxtDate.ReplaceAllString(s, "") // completion date
+	}
+	if m := todoTxtPriority.FindStringSubmatch(s); m != nil {
+		it.Priority = priorityOfLetter(m[1])
+		s = s[len(m[0]):]
+	}
+	s = todoTxtDate.ReplaceAllString(s, "") // creation date
+
+	var words []string
+	for _, w := range strings.Fields(s) {
+		switch {
+		case len(w) > 1 && (w[0] == '+' || w[0] == '@'):
+			if !it.HasTag(w[1:]) {
+				it.Tags = append(it.Tags, w[1:])
+			}
+		case strings.HasPrefix(w, "due:"):
+			due, ok := itemtext.ParseDue(w[4:], now)
+			if !ok {
+				return nil, "", fmt.Errorf("invalid due date %q", w[4:])
+			}
+			it.Due = &due
+		case strings.HasPrefix(w, "pri:") && len(w) == 5:
+			it.Priority = priorityOfLetter(w[4:])
+		case strings.HasPrefix(w, "list:"):
+			if list, err = url.PathUnescape(w[5:]); err != nil {
+				return nil, "", err
+			}
+		case strings.HasPrefix(w, "note:"):
+			if it.Notes, err = url.PathUnescape(w[5:]); err != nil {
+				return nil, "", err
+			}
+		default:
+			words = append(words, w)
+		}
+	}
+	it.Text = strings.Join(words, " ")
+	return it, list, nil
+}
+
+func priorityOfLetter(letter string) todostore.Priority {
+	switch letter {
+	case "A":
+		return todostore.PriorityHigh
+	case "B":
+		return todostore.PriorityMedium
+	case "":
+		return todostore.PriorityNone
+	default:
+		return todostore.PriorityLow
+	}
+}
@@ -0,0 +1,103 @@
+package todostore
+
+// Requests are written in batches, a group commit. The records of all requests in a
+// batch are encoded into a buffer, which is appended to the data file with a single
+// write. With FlushAlways, the file is also flushed once per batch.
+//
+// Records are applied to the state while the batch is assembled, because later
+// requests depend on earlier ones, e.g. for the position of new items. The output
+// events are held back until the batch is stored though. When writing fails, the
+// events are dropped, and the state is read from the data file again.
+
+// writeBatch collects the output events of the requests in a batch.
+type writeBatch struct {
+	events [][]Event // events of each request
+	sizes  []int     // encoded size of each record
+}
+
+// hold adds an output event of the current request.
+func (b *writeBatch) hold(ev Event) {
+	i := len(b.events) - 1
+	b.events[i] = append(b.events[i], ev)
+}
+
+// handleBatch stores the events of several requests and reports the results. The
+// events of each request are followed by its WriteResult. It returns the first error.
+func (s *Store) handleBatch(reqs []*inputRequest) error {
+	var (
+		b    = new(writeBatch)
+		errs = make([]error, len(reqs))
+	)
+	err := s.initFile()
+	if err == nil {
+		err = s.withLock(func() error {
+			s.batch = b
+			defer func() { s.batch = nil }()
+			s.wbuf.Reset()
+			for i, req := range reqs {
+				b.events = append(b.events, nil)
+				errs[i] = s.storeInputEvent(req.ev)
+			}
+			return s.commitBatch(b)
+		})
+	}
+
+	var first error
+	for i, req := range reqs {
+		if err != nil {
+			errs[i] = err
+		} else if len(b.events) > i {
+			for _, ev := range b.events[i] {
+				s.enqueueOutputEvent(ev)
+			}
+		}
+		if req.done != nil {
+			req.done <- errs[i]
+		}
+		s.enqueueOutputEvent(&WriteResult{Request: req.id, Target: eventTarget(req.ev), Err: errs[i], ev: req.ev})
+		if first == nil {
+			first = errs[i]
+		}
+	}
+	return first
+}
+
+// commitBatch writes the buffered records of a batch to the data file.
+func (s *Store) commitBatch(b *writeBatch) error {
+	if s.wbuf.Len() == 0 {
+		return nil
+	}
+	start := s.offset
+	n, err := s.dataFile.Write(s.wbuf.Bytes())
+	s.offset += int64(n)
+	if err == nil {
+		err = s.wrote()
+	}
+	if err != nil {
+		s.discardBatch(start)
+		return err
+	}
+	for _, size := range b.sizes {
+		s.metrics.RecordWritten(size)
+	}
+	s.metrics.BatchWritten(len(b.events))
+	s.wbuf.Reset()
+	return nil
+}
+
+// discardBatch removes the records of a failed batch from the data file, and restores
+// the state by reading the file from the start.
+func (s *Store) discardBatch(start int64) {
+	s.wbuf.Reset()
+	if s.offset > start {
+		if err := s.dataFile.Truncate(start); err != nil {
+			s.log.Error("can't remove incomplete records", "err", err)
+		}
+	}
+	s.log.Warn("write failed, reading data file again")
+	s.state, s.offset, s.seq = newState(), 0, 0
+	s.reminders.reset()
+	if _, err := s.readNew(); err != nil {
+		s.log.Error("can't read data file", "err", err)
+	}
+}
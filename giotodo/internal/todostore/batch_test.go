@@ -0,0 +1,239 @@
+package todostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// blockLoop keeps mainLoop busy until the returned function is called.
+func blockLoop(s *Store) (unblock func()) {
+	release := make(chan struct{})
+	blocked := make(chan struct{})
+	go s.runInLoop(func() error {
+		close(blocked)
+		<-release
+		return nil
+	})
+	<-blocked
+	return func() { close(release) }
+}
+
+// This checks that requests are accepted while the store is busy, and are then
+// written in batches.
+func TestBatchNonBlocking(t *testing.T) {
+	stats := new(Stats)
+	s := Open(t.TempDir(), Options{Metrics: stats})
+	defer s.Close()
+	snapshotOf(t, s)
+
+	const n = 2000
+	unblock := blockLoop(s)
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			s.AddItem(Item{Text: fmt.Sprint(i)})
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AddItem blocked while store is busy")
+	}
+	unblock()
+
+	if snap := snapshotOf(t, s); len(snap.Items) != n {
+		t.Fatalf("got %d items, want %d", len(snap.Items), n)
+	}
+	v := stats.Values()
+	if v.MaxBatch != maxBatch {
+		t.Errorf("MaxBatch = %d, want %d", v.MaxBatch, maxBatch)
+	}
+	if v.Batches >= n {
+		t.Errorf("%d batches for %d requests", v.Batches, n)
+	}
+}
+
+// This checks that all requests of a failed batch fail, and none of their events
+// are emitted.
+func TestBatchFailure(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+	sub := s.Subscribe(nil)
+	defer sub.Close()
+
+	id, err := s.AddItemContext(context.Background(), Item{Text: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore := breakWrites(t, s)
+	unblock := blockLoop(s)
+	var last RequestID
+	for i := 0; i < 10; i++ {
+		s.AddItem(Item{Text: fmt.Sprint(i)})
+		last = s.UpdateItem(id, Item{Text: fmt.Sprint(i)}, FieldText)
+	}
+	unblock()
+	waitResult(t, sub, last)
+
+	for _, ev := range sub.Events() {
+		switch ev := ev.(type) {
+		case *WriteResult:
+			if ev.Err == nil {
+				t.Errorf("request %d succeeded", ev.Request)
+			}
+		case *ItemAdded, *ItemChanged:
+			t.Errorf("event of failed request emitted: %+v", ev)
+		}
+	}
+	if snap := snapshotOf(t, s); len(snap.Items) != 1 || snap.Items[0].Item.Text != "a" {
+		t.Fatalf("failed changes applied: %+v", snap.Items)
+	}
+
+	// The state read from the file is used for new requests.
+	restore()
+	if err := s.UpdateItemContext(context.Background(), id, Item{Text: "b"}, FieldText); err != nil {
+		t.Fatal(err)
+	}
+	if snap := snapshotOf(t, s); len(snap.Items) != 1 || snap.Items[0].Item.Text != "b" {
+		t.Fatalf("wrong items after failure: %+v", snap.Items)
+	}
+}
+
+// This checks that requests with context wait while the queue is full.
+func TestBatchBackpressure(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+
+	unblock := blockLoop(s)
+	for i := 0; i < maxQueued; i++ {
+		s.AddItem(Item{Text: fmt.Sprint(i)})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.AddItemContext(ctx, Item{Text: "waiting"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wrong error %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.AddItemContext(context.Background(), Item{Text: "last"})
+		done <- err
+	}()
+	unblock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// The request which timed out while waiting was not sent.
+	if snap := snapshotOf(t, s); len(snap.Items) != maxQueued+1 {
+		t.Fatalf("got %d items, want %d", len(snap.Items), maxQueued+1)
+	}
+}
+
+// This checks that requests without context fail when the queue is full, instead of
+// growing it without limit.
+func TestBatchQueueFull(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+	snapshotOf(t, s)
+	sub := s.Subscribe(nil)
+	defer sub.Close()
+
+	unblock := blockLoop(s)
+	for i := 0; i < maxQueued; i++ {
+		s.AddItem(Item{Text: fmt.Sprint(i)})
+	}
+	res := waitResult(t, sub, s.RestoreItem("rejected", Item{Text: "rejected"}, ""))
+	if !errors.Is(res.Err, ErrQueueFull) {
+		t.Fatalf("wrong error %v", res.Err)
+	}
+	if _, queued := s.in.take(0); queued != maxQueued {
+		t.Fatalf("%d requests queued", queued)
+	}
+
+	// The rejected request can be retried when there is space.
+	unblock()
+	snapshotOf(t, s)
+	if res := waitResult(t, sub, s.Retry(res)); res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if snap := snapshotOf(t, s); len(snap.Items) != maxQueued+1 {
+		t.Fatalf("got %d items, want %d", len(snap.Items), maxQueued+1)
+	}
+}
+
+// waitSpace waits until the queue of s has space for another request, like the
+// methods with context do.
+func waitSpace(tb testing.TB, s *Store) {
+	if err := s.in.waitSpace(context.Background(), s.quitCh); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// BenchmarkWrite measures the throughput of item changes. With batch=1, every
+// request is written separately, as before group commits were added.
+func BenchmarkWrite(b *testing.B) {
+	for _, d := range []Durability{FlushOS, FlushAlways} {
+		for _, size := range []int{1, maxBatch} {
+			name := fmt.Sprintf("flush=%v/batch=%d", d, size)
+			b.Run(name, func(b *testing.B) {
+				opts := Options{Logger: discardLogger, Durability: d}
+				benchmarkWrite(b, opts, size)
+			})
+		}
+	}
+}
+
+func benchmarkWrite(b *testing.B, opts Options, batchSize int) {
+	s := Open(b.TempDir(), opts)
+	defer s.Close()
+	s.batchSize = batchSize
+	ids := make([]ID, 100)
+	for i := range ids {
+		ids[i] = s.AddItem(Item{Text: "item"})
+	}
+	if _, err := s.Snapshot(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		waitSpace(b, s)
+		s.UpdateItem(ids[i%len(ids)], Item{Text: fmt.Sprint(i)}, FieldText)
+	}
+	// Snapshot returns when all requests are stored.
+	if _, err := s.Snapshot(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkEnqueue measures the time taken by the app to send a request while the
+// store is busy.
+func BenchmarkEnqueue(b *testing.B) {
+	s := Open(b.TempDir(), Options{Logger: discardLogger})
+	defer s.Close()
+	id := s.AddItem(Item{Text: "item"})
+	unblock := blockLoop(s)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%maxQueued == 0 {
+			// Drop the requests, so the queue doesn't fill up.
+			b.StopTimer()
+			s.in.take(maxQueued)
+			b.StartTimer()
+		}
+		s.UpdateItem(id, Item{Text: fmt.Sprint(i)}, FieldText)
+	}
+	b.StopTimer()
+	// Drop the requests, so Close doesn't store them.
+	s.in.take(b.N)
+	unblock()
+}
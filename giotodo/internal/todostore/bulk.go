@@ -0,0 +1,223 @@
+package todostore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// BulkChange changes several items at once. It is stored as a single record, so its
+// changes are applied together or not at all, on this and on other devices. Changes
+// holds ItemAdded, ItemRemoved, ItemChanged and ItemMoved events.
+//
+// All changes have the same stamp, so they can't overwrite each other: each field,
+// the position and the existence of an item may only be set once.
+//
+// The store doesn't emit BulkChange events. Applying one creates the usual events
+// of the changed items.
+type BulkChange struct {
+	Changes []Event
+}
+
+func (*BulkChange) evType() string { return "bulk" }
+
+// bulkEntry is the JSON encoding of a change in a BulkChange.
+type bulkEntry struct {
+	Type    string          `json:"type"`
+	Version int             `json:"v"`
+	Event   json.RawMessage `json:"event"`
+}
+
+func (b *BulkChange) MarshalJSON() ([]byte, error) {
+	entries := make([]bulkEntry, len(b.Changes))
+	for i, ev := range b.Changes {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = bulkEntry{Type: ev.evType(), Version: eventVersions[ev.evType()], Event: data}
+		if ev, ok := ev.(*UnknownEvent); ok {
+			entries[i].Version, entries[i].Event = ev.Version, ev.Data
+		}
+	}
+	return json.Marshal(struct{ Changes []bulkEntry }{entries})
+}
+
+// decodeBulk decodes a BulkChange. Its changes are migrated to the versions of sc,
+// like the record containing them.
+func (sc *schema) decodeBulk(data json.RawMessage) (*BulkChange, error) {
+	var dec struct{ Changes []bulkEntry }
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return nil, err
+	}
+	b := &BulkChange{Changes: make([]Event, len(dec.Changes))}
+	for i, e := range dec.Changes {
+		ev, err := sc.decodeEvent(e.Type, e.Version, e.Event)
+		if err != nil {
+			return nil, err
+		}
+		b.Changes[i] = ev
+	}
+	return b, nil
+}
+
+// Bulk tells the store to make several changes of items at once. See BulkChange.
+// The events are copied, so the caller may reuse them.
+func (s *Store) Bulk(changes ...Event) RequestID {
+	return s.enqueueInputEvent(&BulkChange{Changes: copyEvents(changes)})
+}
+
+// BulkContext makes several changes of items at once, and waits until they are
+// stored.
+func (s *Store) BulkContext(ctx context.Context, changes ...Event) error {
+	return s.do(ctx, &BulkChange{Changes: copyEvents(changes)})
+}
+
+func copyEvents(evs []Event) []Event {
+	cpy := make([]Event, len(evs))
+	for i, ev := range evs {
+		cpy[i] = copyEvent(ev)
+	}
+	return cpy
+}
+
+// copyEvent returns a shallow copy of an item event.
+func copyEvent(ev Event) Event {
+	switch ev := ev.(type) {
+	case *ItemAdded:
+		cpy := *ev
+		return &cpy
+	case *ItemRemoved:
+		cpy := *ev
+		return &cpy
+	case *ItemChanged:
+		cpy := *ev
+		return &cpy
+	case *ItemMoved:
+		cpy := *ev
+		return &cpy
+	}
+	return ev
+}
+
+// check verifies that the changes are item events which don't set anything twice.
+func (b *BulkChange) check() error {
+	type register struct {
+		id   ID
+		name string
+	}
+	seen := make(map[register]bool)
+	set := func(id ID, names ...string) error {
+		for _, name := range names {
+			r := register{id, name}
+			if seen[r] {
+				return fmt.Errorf("bulk change sets %s of item %s twice", name, id)
+			}
+			seen[r] = true
+		}
+		return nil
+	}
+	fieldNames := func(fields []Field) []string {
+		if len(fields) == 0 {
+			fields = itemFields
+		}
+		names := make([]string, len(fields))
+		for i, f := range fields {
+			names[i] = string(f)
+		}
+		return names
+	}
+
+	for _, ev := range b.Changes {
+		var err error
+		switch ev := ev.(type) {
+		case *ItemAdded:
+			err = set(ev.ID, append(fieldNames(nil), "existence", "position")...)
+		case *ItemRemoved:
+			err = set(ev.ID, "existence")
+		case *ItemChanged:
+			err = set(ev.ID, fieldNames(ev.Fields)...)
+		case *ItemMoved:
+			err = set(ev.ID, "position")
+		default:
+			err = fmt.Errorf("%T can't be part of a bulk change", ev)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeBulk stamps and stores a bulk change created by the app. As in storeInputEvent,
+// new items without position are placed at the end of their list, the changed fields
+// of items are computed, and next occurrences of completed recurring items are added.
+// The given event isn't modified, so it can be retried.
+// This must be called with the lock held.
+func (s *Store) storeBulk(b *BulkChange) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	var (
+		stored = &BulkChange{Changes: make([]Event, 0, len(b.Changes))}
+		ends   = make(map[ID]Pos) // end position of lists, for new items
+		next   []Event
+	)
+	for _, ev := range b.Changes {
+		switch ev := copyEvent(ev).(type) {
+		case *ItemAdded:
+			if ev.Pos == "" {
+				end, ok := ends[ev.Item.List]
+				if !ok {
+					end = s.state.endPos(ev.Item.List)
+				}
+				ev.Pos, ends[ev.Item.List] = end, PosAfter(end)
+			}
+			stored.Changes = append(stored.Changes, ev)
+		case *ItemChanged:
+			if len(ev.Fields) == 0 {
+				if ev.Fields = s.state.changedFields(ev.ID, &ev.Item); len(ev.Fields) == 0 {
+					continue
+				}
+			}
+			if occ := s.state.nextOccurrence(ev); occ != nil {
+				next = append(next, occ)
+			}
+			stored.Changes = append(stored.Changes, ev)
+		default:
+			stored.Changes = append(stored.Changes, ev)
+		}
+	}
+	stored.Changes = append(stored.Changes, next...)
+	if len(stored.Changes) == 0 {
+		return nil
+	}
+	if err := s.writeNewRecord(stored); err != nil {
+		return err
+	}
+	s.triggerSync()
+	return nil
+}
+
+// applyBulk applies the changes of a bulk change record.
+func (st *state) applyBulk(rec *record, b *BulkChange) []Event {
+	var evs []Event
+	for _, ev := range b.Changes {
+		switch ev.(type) {
+		case *ItemAdded, *ItemRemoved, *ItemChanged, *ItemMoved:
+			evs = append(evs, st.applyItem(&record{stamp: rec.stamp, ev: ev})...)
+		}
+	}
+	return evs
+}
+
+// affectsBulk reports whether any change of a bulk change record is needed to compute
+// the state.
+func (st *state) affectsBulk(rec *record, b *BulkChange) bool {
+	for _, ev := range b.Changes {
+		if st.affects(&record{stamp: rec.stamp, ev: ev}) {
+			return true
+		}
+	}
+	return false
+}
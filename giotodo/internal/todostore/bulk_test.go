@@ -0,0 +1,168 @@
+package todostore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dataFileLines returns the number of records in the data file.
+func dataFileLines(t *testing.T, dir string) int {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, "events.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(content), "\n")
+}
+
+func itemsByText(snap *Snapshot) map[string]ItemInfo {
+	m := make(map[string]ItemInfo, len(snap.Items))
+	for _, info := range snap.Items {
+		m[info.Item.Text] = info
+	}
+	return m
+}
+
+func TestBulkChange(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir)
+	ctx := context.Background()
+	list := s.AddList("other")
+	var ids []ID
+	for _, text := range []string{"a", "b", "c"} {
+		id, err := s.AddItemContext(ctx, Item{Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	before := dataFileLines(t, dir)
+
+	err := s.BulkContext(ctx,
+		&ItemChanged{ID: ids[0], Item: Item{Done: true}, Fields: []Field{FieldDone}},
+		&ItemRemoved{ID: ids[1]},
+		&ItemChanged{ID: ids[2], Item: Item{List: list}, Fields: []Field{FieldList}},
+		&ItemMoved{ID: ids[2], Pos: "K"},
+		&ItemAdded{ID: NewID(), Item: Item{Text: "d"}},
+		&ItemAdded{ID: NewID(), Item: Item{Text: "e"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := dataFileLines(t, dir) - before; n != 1 {
+		t.Fatalf("bulk change stored as %d records", n)
+	}
+
+	check := func(snap *Snapshot) {
+		t.Helper()
+		items := itemsByText(snap)
+		if len(items) != 4 {
+			t.Fatalf("wrong items %+v", snap.Items)
+		}
+		if !items["a"].Item.Done || items["c"].Item.List != list || items["c"].Pos != "K" {
+			t.Fatalf("changes not applied: %+v", snap.Items)
+		}
+		// New items are placed at the end, in order.
+		if !(items["a"].Pos < items["d"].Pos && items["d"].Pos < items["e"].Pos) {
+			t.Fatalf("wrong positions of new items: %+v", snap.Items)
+		}
+	}
+	check(snapshotOf(t, s))
+	s.Close()
+
+	// Replay gives the same state.
+	s = NewStore(dir)
+	defer s.Close()
+	check(snapshotOf(t, s))
+}
+
+// This checks that a bulk change is applied completely or not at all when the app
+// crashes while writing it.
+func TestBulkAtomic(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir)
+	ctx := context.Background()
+	a, _ := s.AddItemContext(ctx, Item{Text: "a"})
+	b, _ := s.AddItemContext(ctx, Item{Text: "b"})
+	s.Close()
+	file := filepath.Join(dir, "events.json")
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s = NewStore(dir)
+	err = s.BulkContext(ctx, &ItemRemoved{ID: a}, &ItemRemoved{ID: b})
+	s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cut the record in the middle, between the two changes.
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cut := info.Size() + int64(strings.Index(string(content[info.Size():]), string(b)))
+	if err := os.Truncate(file, cut); err != nil {
+		t.Fatal(err)
+	}
+
+	s = NewStore(dir)
+	defer s.Close()
+	if snap := snapshotOf(t, s); len(snap.Items) != 2 {
+		t.Fatalf("partial bulk change applied: %+v", snap.Items)
+	}
+}
+
+func TestBulkRecurring(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir)
+	defer s.Close()
+	ctx := context.Background()
+	due := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	id, err := s.AddItemContext(ctx, Item{Text: "water plants", Due: &due, Repeat: "FREQ=DAILY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := dataFileLines(t, dir)
+	err = s.BulkContext(ctx, &ItemChanged{ID: id, Item: Item{Done: true}, Fields: []Field{FieldDone}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := dataFileLines(t, dir) - before; n != 1 {
+		t.Fatalf("bulk change stored as %d records", n)
+	}
+	if snap := snapshotOf(t, s); len(snap.Items) != 2 {
+		t.Fatalf("next occurrence not added: %+v", snap.Items)
+	}
+}
+
+func TestBulkInvalid(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+	ctx := context.Background()
+
+	tests := [][]Event{
+		{&ItemRemoved{ID: "a"}, &ItemAdded{ID: "a"}},
+		{&ItemMoved{ID: "a", Pos: "K"}, &ItemMoved{ID: "a", Pos: "L"}},
+		{&ItemChanged{ID: "a", Fields: []Field{FieldText}}, &ItemChanged{ID: "a"}},
+		{&ListRemoved{ID: "l"}},
+	}
+	for i, changes := range tests {
+		if err := s.BulkContext(ctx, changes...); err == nil {
+			t.Errorf("bulk change %d accepted", i)
+		}
+	}
+	// Different fields of one item can be changed.
+	err := s.BulkContext(ctx,
+		&ItemChanged{ID: "a", Fields: []Field{FieldText}},
+		&ItemChanged{ID: "a", Fields: []Field{FieldDone}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,122 @@
+package todostore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CompactResult is the outcome of Compact.
+type CompactResult struct {
+	Before int // number of records before compaction
+	After  int // number of records after compaction
+}
+
+// Compact rewrites the data file, dropping all records which no longer affect the
+// content of the store. Records that are kept are not modified, so that merging with
+// other devices works as before.
+//
+// Other processes using the data file notice that it was replaced and read it again.
+// On Windows, compaction fails while the file is open in another process.
+func (s *Store) Compact() (*CompactResult, error) {
+	var result *CompactResult
+	err := s.runInLoop(func() error {
+		if err := s.initFile(); err != nil {
+			return err
+		}
+		if err := s.handlePendingInput(); err != nil {
+			return err
+		}
+		return s.withLock(func() (err error) {
+			result, err = s.compact()
+			return err
+		})
+	})
+	return result, err
+}
+
+func (s *Store) compact() (*CompactResult, error) {
+	before, after, err := s.rewrite(s.key, s.state.affects)
+	if err != nil {
+		return nil, err
+	}
+	return &CompactResult{Before: before, After: after}, nil
+}
+
+// rewrite replaces the data file by a new one containing the records for which keep
+// returns true. The new file is encrypted with the given key. It returns the number
+// of records before and after. This must be called with the lock held.
+func (s *Store) rewrite(key *Key, keep func(*record) bool) (before, after int, err error) {
+	var (
+		filename = filepath.Join(s.dataDir, "events.json")
+		tmpname  = filename + ".tmp"
+	)
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	tmp, err := os.OpenFile(tmpname, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(tmpname)
+	defer tmp.Close()
+
+	// Write the kept records to a new file.
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	if err := writeRecord(enc, &record{ev: key.header(s.state.seen)}); err != nil {
+		return 0, 0, err
+	}
+	var werr error
+	err = scanRecords(json.NewDecoder(f), s.key, s.device, new(uint64), func(rec *record) bool {
+		if _, ok := rec.ev.(*fileHeader); ok {
+			return true
+		}
+		before++
+		if !keep(rec) {
+			return true
+		}
+		after++
+		if key != nil {
+			if rec, werr = key.sealRecord(rec); werr != nil {
+				return false
+			}
+		}
+		werr = writeRecord(enc, rec)
+		return werr == nil
+	})
+	if err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't write new data file: %v", err)
+	}
+	tmp.Close()
+
+	// Replace the data file. It is closed first because open files can't be
+	// replaced on Windows.
+	s.dataFile.Close()
+	s.dataFile = nil
+	renameErr := os.Rename(tmpname, filename)
+	if renameErr == nil {
+		s.key = key
+	}
+	if err := s.openDataFile(); err != nil {
+		return 0, 0, err
+	}
+	if renameErr != nil {
+		return 0, 0, renameErr
+	}
+	_, err = s.readNew()
+	return before, after, err
+}
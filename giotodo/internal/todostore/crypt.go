@@ -0,0 +1,282 @@
+package todostore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encryption of the data file works on individual records, so that appending to the
+// file and reading changes of other processes work as without encryption. Each record
+// is encoded as usual, then encrypted with XChaCha20-Poly1305 using a random nonce, and
+// stored as a "sealed" record. The key is derived from a passphrase with scrypt. The
+// parameters of the derivation are stored in the file header, which is not encrypted.
+//
+// Since records are authenticated individually, modified records are detected, but
+// records which were removed from the file are not. No associated data is used: the
+// stamp of a record (device, sequence number and time) is part of the encrypted data,
+// so it is authenticated along with the event. What this doesn't protect against is
+// someone with write access to the file changing the set of records:
+//
+//   - Removing records, or replacing the file with an older copy, reverts changes.
+//   - Records can be reordered or duplicated, also by inserting them from an older
+//     copy. This doesn't change the items, because records are applied by their
+//     stamp, and applying a record twice does nothing.
+//
+// Records of files encrypted by another call of SetPassphrase can't be mixed in,
+// because every call uses a new salt and thus a new key.
+
+var (
+	// ErrWrongPassphrase is returned by Unlock when the passphrase is wrong.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	// ErrNotEncrypted is returned by Unlock when the store is not encrypted.
+	ErrNotEncrypted = errors.New("store is not encrypted")
+
+	errLocked      = errors.New("data file is encrypted")
+	errKeyChanged  = errors.New("data file was encrypted with another passphrase")
+	errUnsealed    = errors.New("unencrypted record in encrypted data file")
+	errDecryptFail = errors.New("can't decrypt record, data file was modified")
+)
+
+// scryptN is the scrypt cost parameter used for new keys. It is a variable so that
+// tests can use a cheaper setting.
+var scryptN = 1 << 15
+
+// keyCheck is encrypted into the file header. It is used to verify the passphrase
+// before any records are read.
+var keyCheck = []byte("giotodo key check")
+
+// cryptParams is stored in the file header of encrypted data files.
+type cryptParams struct {
+	KDF   string `json:"kdf"`
+	Salt  []byte `json:"salt"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Check []byte `json:"check"`
+}
+
+// Key is the encryption key of a store. It is created by Unlock.
+type Key struct {
+	params *cryptParams
+	aead   cipher.AEAD
+}
+
+// newKey derives a key from passphrase using new random parameters.
+func newKey(passphrase string) (*Key, error) {
+	p := &cryptParams{KDF: "scrypt", Salt: make([]byte, 16), N: scryptN, R: 8, P: 1}
+	if _, err := crand.Read(p.Salt); err != nil {
+		return nil, err
+	}
+	k, err := deriveKey(passphrase, p)
+	if err != nil {
+		return nil, err
+	}
+	p.Check, err = k.seal(keyCheck)
+	return k, err
+}
+
+func deriveKey(passphrase string, p *cryptParams) (*Key, error) {
+	if p.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation function %q", p.KDF)
+	}
+	secret, err := scrypt.Key([]byte(passphrase), p.Salt, p.N, p.R, p.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{params: p, aead: aead}, nil
+}
+
+// IsEncrypted reports whether the store in datadir is encrypted.
+func IsEncrypted(datadir string) (bool, error) {
+	h, err := readHeader(datadir)
+	return h != nil && h.Crypt != nil, err
+}
+
+// Unlock derives the key of the store in datadir from a passphrase. This takes
+// a while on purpose, to make guessing the passphrase expensive.
+func Unlock(datadir, passphrase string) (*Key, error) {
+	h, err := readHeader(datadir)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil || h.Crypt == nil {
+		return nil, ErrNotEncrypted
+	}
+	k, err := deriveKey(passphrase, h.Crypt)
+	if err != nil {
+		return nil, err
+	}
+	if check, err := k.open(h.Crypt.Check); err != nil || !bytes.Equal(check, keyCheck) {
+		return nil, ErrWrongPassphrase
+	}
+	return k, nil
+}
+
+// readHeader reads the header of the data file in datadir. It returns nil when the
+// file doesn't exist or has no header.
+func readHeader(datadir string) (*fileHeader, error) {
+	f, err := os.Open(filepath.Join(datadir, "events.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rec, err := readRecord(json.NewDecoder(f))
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	h, _ := rec.ev.(*fileHeader)
+	return h, nil
+}
+
+func (k *Key) seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(plaintext)+k.aead.Overhead())
+	if _, err := crand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (k *Key) open(data []byte) ([]byte, error) {
+	if len(data) < k.aead.NonceSize() {
+		return nil, errDecryptFail
+	}
+	nonce, ciphertext := data[:k.aead.NonceSize()], data[k.aead.NonceSize():]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errDecryptFail
+	}
+	return plaintext, nil
+}
+
+// SetPassphrase encrypts the store with a new passphrase, replacing the previous one.
+// An empty passphrase removes the encryption. The data file is rewritten, so other
+// processes using the store must be restarted to unlock it again.
+func (s *Store) SetPassphrase(passphrase string) error {
+	var key *Key
+	if passphrase != "" {
+		var err error
+		if key, err = newKey(passphrase); err != nil {
+			return err
+		}
+	}
+	return s.runInLoop(func() error {
+		if err := s.initFile(); err != nil {
+			return err
+		}
+		if err := s.handlePendingInput(); err != nil {
+			return err
+		}
+		return s.withLock(func() error {
+			_, _, err := s.rewrite(key, func(*record) bool { return true })
+			return err
+		})
+	})
+}
+
+// header creates the header of a data file encrypted with the key.
+func (k *Key) header(seqs map[ID]uint64) *fileHeader {
+	h := &fileHeader{Version: formatVersion, Seqs: seqs}
+	if k != nil {
+		h.Crypt = k.params
+	}
+	return h
+}
+
+// isCryptError reports whether err means that the data file can't be decrypted.
+// Unlike other decoding errors, these stop the store from writing to the file.
+func isCryptError(err error) bool {
+	switch err {
+	case errLocked, errKeyChanged, errUnsealed, errDecryptFail, ErrNotEncrypted:
+		return true
+	}
+	return false
+}
+
+// checkHeader verifies that records following the header can be decrypted with the key.
+func (k *Key) checkHeader(h *fileHeader) error {
+	switch {
+	case k == nil && h.Crypt != nil:
+		return errLocked
+	case k != nil && h.Crypt == nil:
+		return ErrNotEncrypted
+	case k != nil && !bytes.Equal(k.params.Salt, h.Crypt.Salt):
+		return errKeyChanged
+	}
+	return nil
+}
+
+// sealRecord encrypts a record.
+func (k *Key) sealRecord(rec *record) (*record, error) {
+	var buf bytes.Buffer
+	if err := writeRecord(json.NewEncoder(&buf), rec); err != nil {
+		return nil, err
+	}
+	data, err := k.seal(bytes.TrimSpace(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	return &record{ev: &sealedRecord{Data: data}}, nil
+}
+
+// openRecord decrypts a record read from the data file. Records other than the
+// header must be encrypted when there is a key, and must not be encrypted when
+// there is none.
+func (k *Key) openRecord(rec *record) (*record, error) {
+	sealed, isSealed := rec.ev.(*sealedRecord)
+	_, isHeader := rec.ev.(*fileHeader)
+	switch {
+	case isHeader:
+		return rec, nil
+	case k == nil && isSealed:
+		return nil, errLocked
+	case k == nil:
+		return rec, nil
+	case !isSealed:
+		return nil, errUnsealed
+	}
+	plaintext, err := k.open(sealed.Data)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := readRecord(json.NewDecoder(bytes.NewReader(plaintext)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted record: %v", err)
+	}
+	if _, ok := inner.ev.(*sealedRecord); ok {
+		return nil, fmt.Errorf("invalid encrypted record: nested encryption")
+	}
+	return inner, nil
+}
+
+// sealedRecord is an encrypted record in the data file.
+type sealedRecord struct {
+	Data []byte
+}
+
+func (*sealedRecord) evType() string { return "sealed" }
+
+func (ev *sealedRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ev.Data)
+}
+
+func (ev *sealedRecord) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &ev.Data)
+}
@@ -0,0 +1,219 @@
+package todostore
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cheapKeys makes key derivation fast for the duration of the test.
+func cheapKeys(t *testing.T) {
+	n := scryptN
+	scryptN = 1 << 10
+	t.Cleanup(func() { scryptN = n })
+}
+
+// newEncryptedTestStore creates an encrypted store containing one item.
+func newEncryptedTestStore(t *testing.T, dir, passphrase string) {
+	s := NewStore(dir)
+	defer s.Close()
+	s.AddItem(Item{Text: "secret item"})
+	if err := s.SetPassphrase(passphrase); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	cheapKeys(t)
+	dir := t.TempDir()
+	newEncryptedTestStore(t, dir, "pass1")
+
+	content, err := os.ReadFile(filepath.Join(dir, "events.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("secret item")) {
+		t.Fatal("data file contains plaintext")
+	}
+	if enc, err := IsEncrypted(dir); !enc || err != nil {
+		t.Fatalf("IsEncrypted = %v, %v", enc, err)
+	}
+
+	// Check passphrase.
+	if _, err := Unlock(dir, "wrong"); err != ErrWrongPassphrase {
+		t.Fatalf("wrong error for wrong passphrase: %v", err)
+	}
+	key, err := Unlock(dir, "pass1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewEncryptedStore(dir, key)
+	s.AddItem(Item{Text: "another item"})
+	if snap := snapshotOf(t, s); len(snap.Items) != 2 {
+		t.Fatalf("wrong items %+v", snap.Items)
+	}
+
+	// Change the passphrase.
+	if err := s.SetPassphrase("pass2"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := Unlock(dir, "pass1"); err != ErrWrongPassphrase {
+		t.Fatalf("wrong error for old passphrase: %v", err)
+	}
+	if key, err = Unlock(dir, "pass2"); err != nil {
+		t.Fatal(err)
+	}
+	s = NewEncryptedStore(dir, key)
+	defer s.Close()
+	if snap := snapshotOf(t, s); len(snap.Items) != 2 {
+		t.Fatalf("wrong items after passphrase change %+v", snap.Items)
+	}
+}
+
+// This checks that an encrypted store can't be used without the key.
+func TestEncryptedStoreLocked(t *testing.T) {
+	cheapKeys(t)
+	dir := t.TempDir()
+	newEncryptedTestStore(t, dir, "pass")
+	before, _ := os.ReadFile(filepath.Join(dir, "events.json"))
+
+	s := NewStore(dir)
+	s.AddItem(Item{Text: "x"})
+	if _, err := s.Snapshot(); err != errLocked {
+		t.Fatalf("wrong error %v", err)
+	}
+	s.Close()
+	after, _ := os.ReadFile(filepath.Join(dir, "events.json"))
+	if !bytes.Equal(before, after) {
+		t.Fatal("data file was modified")
+	}
+}
+
+func TestEncryptedStoreTampered(t *testing.T) {
+	cheapKeys(t)
+	tests := []struct {
+		name   string
+		modify func(lines []string) []string
+		err    error
+	}{
+		{
+			name: "modified",
+			modify: func(lines []string) []string {
+				// Change a character of the encrypted data.
+				i := strings.Index(lines[1], `"event":"`) + 20
+				c := byte('A')
+				if lines[1][i] == c {
+					c = 'B'
+				}
+				lines[1] = lines[1][:i] + string(c) + lines[1][i+1:]
+				return lines
+			},
+			err: errDecryptFail,
+		},
+		{
+			name: "unencrypted",
+			modify: func(lines []string) []string {
+				ev := `{"type":"add","v":1,"dev":"x","seq":1,"t":1,"event":{"ID":"a1","Item":{"Text":"injected","Done":false}}}`
+				return append(lines[:len(lines)-1], ev, "")
+			},
+			err: errUnsealed,
+		},
+		{
+			name: "header",
+			modify: func(lines []string) []string {
+				lines[0] = `{"type":"header","v":0,"event":{"version":3}}`
+				return lines
+			},
+			err: ErrNotEncrypted,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			newEncryptedTestStore(t, dir, "pass")
+			key, err := Unlock(dir, "pass")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			file := filepath.Join(dir, "events.json")
+			content, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := test.modify(strings.Split(string(content), "\n"))
+			if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			s := NewEncryptedStore(dir, key)
+			defer s.Close()
+			if _, err := s.Snapshot(); err != test.err {
+				t.Fatalf("wrong error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+// This checks that an encrypted store never sends its records to the sync server.
+func TestEncryptedStoreSync(t *testing.T) {
+	cheapKeys(t)
+	dir := t.TempDir()
+	newEncryptedTestStore(t, dir, "pass")
+	key, err := Unlock(dir, "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.String()+" "+string(body))
+		mu.Unlock()
+		w.Write([]byte(`{"events":[],"devices":{}}`))
+	}))
+	defer srv.Close()
+
+	s := NewEncryptedStore(dir, key)
+	defer s.Close()
+	sub := s.Subscribe(nil)
+	s.AddItem(Item{Text: "another secret"})
+	s.StartSync(srv.URL, time.Hour)
+
+	var status *SyncStatus
+	timeout := time.After(5 * time.Second)
+	for status == nil {
+		for _, ev := range sub.Events() {
+			if st, ok := ev.(*SyncStatus); ok {
+				status = st
+			}
+		}
+		if status == nil {
+			select {
+			case <-sub.Ready():
+			case <-timeout:
+				t.Fatal("no sync status")
+			}
+		}
+	}
+	if status.Err != ErrSyncEncrypted {
+		t.Fatalf("wrong sync error %v", status.Err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) > 0 {
+		t.Fatalf("store sent requests to server: %q", requests)
+	}
+}
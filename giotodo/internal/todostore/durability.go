@@ -0,0 +1,107 @@
+package todostore
+
+import (
+	"fmt"
+	"time"
+)
+
+// Durability selects when the data file is flushed to disk. Records are always
+// written to the data file before the result of a request is reported, so changes
+// survive a crash of the app under every policy. The policies differ in the changes
+// lost when the operating system crashes or the device loses power.
+//
+// Under every policy, the file is also flushed by Store.Persist and Store.Close.
+type Durability int
+
+const (
+	// FlushOS leaves flushing to the operating system, which usually writes data
+	// within 30 seconds. This is the default. The amount of work lost on power
+	// failure is unknown.
+	FlushOS Durability = iota
+
+	// FlushAlways flushes after every batch of writes, before reporting the
+	// results. A change with a successful WriteResult is never lost. This is the
+	// slowest policy.
+	FlushAlways
+
+	// FlushPeriodic flushes at most FlushInterval after a write. Changes older
+	// than FlushInterval are never lost.
+	FlushPeriodic
+
+	// FlushIdle flushes when no change was written for FlushInterval. It avoids
+	// flushing during bursts of writes, but continuous writing delays the flush
+	// indefinitely.
+	FlushIdle
+)
+
+// DefaultFlushInterval is used for FlushPeriodic and FlushIdle when
+// Options.FlushInterval is zero.
+const DefaultFlushInterval = time.Second
+
+func (d Durability) String() string {
+	switch d {
+	case FlushOS:
+		return "os"
+	case FlushAlways:
+		return "always"
+	case FlushPeriodic:
+		return "periodic"
+	case FlushIdle:
+		return "idle"
+	}
+	return fmt.Sprintf("Durability(%d)", int(d))
+}
+
+// ParseDurability parses the name of a policy, as returned by String.
+func ParseDurability(s string) (Durability, error) {
+	for d := FlushOS; d <= FlushIdle; d++ {
+		if d.String() == s {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown durability %q", s)
+}
+
+// wrote is called after records were appended to the data file. It flushes the file
+// or schedules the flush, according to the durability policy.
+func (s *Store) wrote() error {
+	s.dirty = true
+	switch s.durability {
+	case FlushAlways:
+		return s.flushFile()
+	case FlushPeriodic:
+		if !s.flushPending {
+			s.flushTimer.Reset(s.flushInterval)
+			s.flushPending = true
+		}
+	case FlushIdle:
+		s.flushTimer.Reset(s.flushInterval)
+		s.flushPending = true
+	}
+	return nil
+}
+
+// flushDue is called by mainLoop when the flush timer has expired.
+func (s *Store) flushDue() {
+	s.flushPending = false
+	if s.dirty && s.dataFile != nil {
+		if err := s.flushFile(); err != nil {
+			s.enqueueOutputEvent(&IOError{Err: err})
+		}
+	}
+}
+
+// flushFile flushes the data file to disk.
+func (s *Store) flushFile() error {
+	begin := time.Now()
+	err := s.dataFile.Sync()
+	d := time.Since(begin)
+	s.metrics.Fsync(d, err)
+	if err != nil {
+		s.log.Error("can't flush data file", "err", err)
+		return err
+	}
+	s.dirty = false
+	s.log.Debug("data file flushed", "duration", d)
+	return nil
+}
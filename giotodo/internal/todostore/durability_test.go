@@ -0,0 +1,242 @@
+package todostore
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// This checks when each policy flushes the data file.
+func TestDurability(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	ctx := context.Background()
+
+	// write adds items for the given duration, faster than the flush interval.
+	write := func(s *Store, d time.Duration) {
+		for end := time.Now().Add(d); time.Now().Before(end); {
+			if _, err := s.AddItemContext(ctx, Item{Text: "x"}); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	open := func(d Durability) (*Store, *Stats) {
+		stats := new(Stats)
+		s := Open(t.TempDir(), Options{Durability: d, FlushInterval: interval, Metrics: stats})
+		snapshotOf(t, s)
+		return s, stats
+	}
+
+	t.Run("always", func(t *testing.T) {
+		s, stats := open(FlushAlways)
+		defer s.Close()
+		for i := 1; i <= 3; i++ {
+			if _, err := s.AddItemContext(ctx, Item{Text: "x"}); err != nil {
+				t.Fatal(err)
+			}
+			// The header is flushed when the file is created.
+			if n := stats.Values().Fsyncs; n != int64(i+1) {
+				t.Fatalf("%d flushes after %d writes", n, i)
+			}
+		}
+	})
+
+	t.Run("periodic", func(t *testing.T) {
+		s, stats := open(FlushPeriodic)
+		defer s.Close()
+		write(s, 5*interval)
+		if n := stats.Values().Fsyncs; n < 2 {
+			t.Fatalf("%d flushes while writing, want >= 2", n)
+		}
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		s, stats := open(FlushIdle)
+		defer s.Close()
+		write(s, 3*interval)
+		if n := stats.Values().Fsyncs; n > 1 {
+			t.Fatalf("%d flushes while writing", n)
+		}
+		time.Sleep(3 * interval)
+		if !flushed(s) {
+			t.Fatal("not flushed when idle")
+		}
+	})
+
+	t.Run("os", func(t *testing.T) {
+		s, stats := open(FlushOS)
+		write(s, interval)
+		time.Sleep(2 * interval)
+		if n := stats.Values().Fsyncs; n != 0 {
+			t.Fatalf("%d flushes", n)
+		}
+		s.Close()
+		if n := stats.Values().Fsyncs; n != 1 {
+			t.Fatalf("%d flushes after Close, want 1", n)
+		}
+	})
+}
+
+// flushed reports whether all changes in s were flushed.
+func flushed(s *Store) bool {
+	var dirty bool
+	s.runInLoop(func() error {
+		dirty = s.dirty
+		return nil
+	})
+	return !dirty
+}
+
+// This checks that changes reported as stored are replayed after the app is killed
+// while writing. The child process writes until it is killed.
+func TestCrashReplay(t *testing.T) {
+	if testing.Short() {
+		t.Skip("slow test")
+	}
+	for d := FlushOS; d <= FlushIdle; d++ {
+		d := d
+		t.Run(d.String(), func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			acked := runCrashChild(t, dir, d, 500)
+
+			s := NewStore(dir)
+			snap := snapshotOf(t, s)
+			stored := make(map[ID]bool, len(snap.Items))
+			for _, info := range snap.Items {
+				stored[info.ID] = true
+			}
+			for _, id := range acked {
+				if !stored[id] {
+					t.Fatalf("item %s lost, %d of %d items stored", id, len(snap.Items), len(acked))
+				}
+			}
+
+			// The store can be written again.
+			id, err := s.AddItemContext(context.Background(), Item{Text: "after crash"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+			s = NewStore(dir)
+			defer s.Close()
+			snap = snapshotOf(t, s)
+			if len(snap.Items) < len(acked)+1 || !hasItem(snap, id) {
+				t.Fatalf("item written after crash lost, %d items stored", len(snap.Items))
+			}
+		})
+	}
+}
+
+// runCrashChild starts TestCrashChild, and kills it after n items were stored. It
+// returns the items reported as stored.
+func runCrashChild(t *testing.T, dir string, d Durability, n int) []ID {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCrashChild$")
+	cmd.Env = append(os.Environ(), "TODOSTORE_CRASH_DIR="+dir, "TODOSTORE_CRASH_DURABILITY="+d.String())
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var acked []ID
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		if id, ok := strings.CutPrefix(scanner.Text(), "stored "); ok {
+			acked = append(acked, ID(id))
+		}
+		if len(acked) == n {
+			cmd.Process.Kill()
+		}
+	}
+	cmd.Wait()
+	if len(acked) < n {
+		t.Fatalf("child exited after storing %d items", len(acked))
+	}
+	return acked
+}
+
+// TestCrashChild is run in a child process by TestCrashReplay. It adds items in
+// bursts, and prints the items of each burst when they are stored.
+func TestCrashChild(t *testing.T) {
+	dir := os.Getenv("TODOSTORE_CRASH_DIR")
+	if dir == "" {
+		t.Skip("run by TestCrashReplay")
+	}
+	d, err := ParseDurability(os.Getenv("TODOSTORE_CRASH_DURABILITY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Open(dir, Options{Durability: d, FlushInterval: 10 * time.Millisecond, Logger: discardLogger})
+	defer s.Close()
+
+	for i := 0; ; i++ {
+		ids := make([]ID, 20)
+		for j := range ids[1:] {
+			ids[j+1] = s.AddItem(Item{Text: fmt.Sprint(i, j)})
+		}
+		// The request is handled after the ones sent before it, so all items are
+		// stored when it is done.
+		id, err := s.AddItemContext(context.Background(), Item{Text: fmt.Sprint(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[0] = id
+		for _, id := range ids {
+			fmt.Println("stored", id)
+		}
+	}
+}
+
+// This checks that an incomplete record left by a crash doesn't hide the records
+// written after it.
+func TestPartialRecordRepair(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir)
+	first, err := s.AddItemContext(context.Background(), Item{Text: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	f, err := os.OpenFile(filepath.Join(dir, "events.json"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"type":"add","v":1,"dev":"crashed","seq":1,"t":1000,"ev`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s = NewStore(dir)
+	second, err := s.AddItemContext(context.Background(), Item{Text: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = NewStore(dir)
+	defer s.Close()
+	snap := snapshotOf(t, s)
+	if len(snap.Items) != 2 || !hasItem(snap, first) || !hasItem(snap, second) {
+		t.Fatalf("wrong items after repair: %+v", snap.Items)
+	}
+}
+
+func hasItem(snap *Snapshot, id ID) bool {
+	for _, info := range snap.Items {
+		if info.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -3,46 +3,160 @@ package todostore
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 type ItemAdded struct {
 	ID   ID
 	Item Item
+	Pos  Pos `json:",omitempty"`
 }
 
 type ItemRemoved struct {
 	ID ID
 }
 
+// ItemChanged sets fields of an item to the values in Item. When Fields is empty,
+// all fields except Parent and Repeat are set, as done by builds before these
+// fields existed.
 type ItemChanged struct {
+	ID     ID
+	Item   Item
+	Fields []Field `json:",omitempty"`
+}
+
+// ItemMoved changes the position of an item within its list.
+type ItemMoved struct {
+	ID  ID
+	Pos Pos
+}
+
+// ListAdded creates a new todo list.
+type ListAdded struct {
 	ID   ID
-	Item Item
+	Name string
+}
+
+// ListRemoved deletes a list. Its items are hidden rather than removed, so they come
+// back when the list is restored. No ItemRemoved events are emitted for them.
+type ListRemoved struct {
+	ID ID
+}
+
+// ListChanged renames a list.
+type ListChanged struct {
+	ID   ID
+	Name string
+}
+
+// ListsReordered sets the display order of lists.
+// Lists which are not mentioned in Order are placed at the end.
+type ListsReordered struct {
+	Order []ID
 }
 
 type IOError struct {
 	Err error
 }
 
+// SyncStatus is sent after each sync with the server.
+type SyncStatus struct {
+	Time   time.Time
+	Err    error
+	Pushed int // number of local events sent
+	Pulled int // number of remote events received
+}
+
 type Event interface {
 	evType() string
 }
 
-func (*ItemAdded) evType() string   { return "add" }
-func (*ItemRemoved) evType() string { return "remove" }
-func (*ItemChanged) evType() string { return "change" }
-func (*IOError) evType() string     { return "ioerror" }
+func (*ItemAdded) evType() string      { return "add" }
+func (*ItemRemoved) evType() string    { return "remove" }
+func (*ItemChanged) evType() string    { return "change" }
+func (*ItemMoved) evType() string      { return "move" }
+func (*ListAdded) evType() string      { return "list-add" }
+func (*ListRemoved) evType() string    { return "list-remove" }
+func (*ListChanged) evType() string    { return "list-change" }
+func (*ListsReordered) evType() string { return "list-order" }
+func (*IOError) evType() string        { return "ioerror" }
+func (*SyncStatus) evType() string     { return "sync-status" }
+
+// record is an event as stored in the data file.
+type record struct {
+	stamp
+	ev Event
+}
+
+// stamp identifies the origin of an event. Events are numbered by the device
+// that created them.
+type stamp struct {
+	Device ID     `json:"dev,omitempty"`
+	Seq    uint64 `json:"seq,omitempty"`
+	hlc
+}
+
+// less orders stamps by time. Stamps with equal time, which can only be created by
+// different devices, are ordered by device.
+func (st stamp) less(other stamp) bool {
+	if st.hlc != other.hlc {
+		return st.hlc.less(other.hlc)
+	}
+	if st.Device != other.Device {
+		return st.Device < other.Device
+	}
+	return st.Seq < other.Seq
+}
+
+// hlc is a hybrid logical clock timestamp. It consists of the wall clock time and a
+// counter, which is incremented instead of the time when the clock of a device is
+// behind the latest timestamp it has seen. Timestamps thus stay close to real time,
+// but an event always has a higher timestamp than all events its device knew about
+// when it was created.
+type hlc struct {
+	Time    int64  `json:"t,omitempty"` // milliseconds since epoch
+	Logical uint32 `json:"l,omitempty"`
+}
+
+func (c hlc) less(other hlc) bool {
+	if c.Time != other.Time {
+		return c.Time < other.Time
+	}
+	return c.Logical < other.Logical
+}
+
+// next returns the timestamp of a new local event, given the current wall clock time.
+func (c hlc) next(now int64) hlc {
+	if now > c.Time {
+		return hlc{Time: now}
+	}
+	return hlc{Time: c.Time, Logical: c.Logical + 1}
+}
 
 type jsonEvent struct {
-	Type  string `json:"type"`
-	Event Event  `json:"event"`
+	Type    string `json:"type"`
+	Version int    `json:"v"`
+	stamp
+	Event any `json:"event"`
 }
 
-func writeEvent(enc *json.Encoder, ev Event) error {
-	jsev := &jsonEvent{Type: ev.evType(), Event: ev}
+func writeRecord(enc *json.Encoder, rec *record) error {
+	ev := rec.ev
+	jsev := &jsonEvent{Type: ev.evType(), Version: eventVersions[ev.evType()], stamp: rec.stamp, Event: ev}
+	if ev, ok := ev.(*UnknownEvent); ok {
+		// Unknown events are written back exactly as they were read.
+		jsev.Version = ev.Version
+		jsev.Event = ev.Data
+	}
 	return enc.Encode(jsev)
 }
 
-func readEvent(dec *json.Decoder) (Event, error) {
+func readRecord(dec *json.Decoder) (*record, error) {
+	return currentSchema.readRecord(dec)
+}
+
+// readRecord decodes a record, migrating its event to the versions of sc.
+func (sc *schema) readRecord(dec *json.Decoder) (*record, error) {
 	tok, err := dec.Token()
 	if err != nil {
 		return nil, err
@@ -52,8 +166,10 @@ func readEvent(dec *json.Decoder) (Event, error) {
 	}
 
 	var (
-		evtype = ""
-		event  Event
+		rec     record
+		evtype  = ""
+		version = 1 // events without version are from schema version 1
+		data    json.RawMessage
 	)
 	for dec.More() {
 		keyTok, err := dec.Token()
@@ -63,28 +179,40 @@ func readEvent(dec *json.Decoder) (Event, error) {
 		switch keyTok.(string) {
 		case "type":
 			evtype, err = readEventType(dec)
-			if err != nil {
-				return nil, err
-			}
+		case "v":
+			err = dec.Decode(&version)
+		case "dev":
+			err = dec.Decode(&rec.Device)
+		case "seq":
+			err = dec.Decode(&rec.Seq)
+		case "t":
+			err = dec.Decode(&rec.Time)
+		case "l":
+			err = dec.Decode(&rec.Logical)
 		case "event":
-			if evtype == "" {
-				return nil, fmt.Errorf("key \"type\" must precede \"event\"")
-			}
-			event, err = makeEvent(evtype)
-			if err != nil {
-				return nil, err
-			}
-			if err := dec.Decode(event); err != nil {
-				return nil, err
-			}
+			err = dec.Decode(&data)
 		default:
-			return nil, fmt.Errorf("unknown key %q", keyTok)
+			// Keys added by future versions are ignored.
+			var ignored json.RawMessage
+			err = dec.Decode(&ignored)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	// read '}'
-	_, err = dec.Token()
-	return event, err
+	if _, err = dec.Token(); err != nil {
+		return nil, err
+	}
+	if evtype == "" {
+		return nil, fmt.Errorf("missing event type")
+	}
+	rec.ev, err = sc.decodeEvent(evtype, version, data)
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
 }
 
 func readEventType(dec *json.Decoder) (string, error) {
@@ -99,15 +227,56 @@ func readEventType(dec *json.Decoder) (string, error) {
 	return typ, nil
 }
 
-func makeEvent(evtype string) (Event, error) {
+// decodeEvent creates an event from its JSON encoding, migrating it to the versions
+// of sc if necessary. Events which can't be understood by this version of the store
+// are returned as *UnknownEvent.
+func (sc *schema) decodeEvent(evtype string, version int, data json.RawMessage) (Event, error) {
+	switch evtype {
+	case (&fileHeader{}).evType():
+		h := new(fileHeader)
+		return h, json.Unmarshal(data, h)
+	case (&sealedRecord{}).evType():
+		sr := new(sealedRecord)
+		return sr, json.Unmarshal(data, sr)
+	}
+
+	current, ok := sc.versions[evtype]
+	if !ok || version > current {
+		return &UnknownEvent{Type: evtype, Version: version, Data: data}, nil
+	}
+	data, err := sc.migrate(evtype, version, data)
+	if err != nil {
+		return nil, err
+	}
+	if evtype == (&BulkChange{}).evType() {
+		return sc.decodeBulk(data)
+	}
+	event := makeEvent(evtype)
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func makeEvent(evtype string) Event {
 	switch evtype {
 	case (&ItemAdded{}).evType():
-		return new(ItemAdded), nil
+		return new(ItemAdded)
 	case (&ItemRemoved{}).evType():
-		return new(ItemRemoved), nil
+		return new(ItemRemoved)
 	case (&ItemChanged{}).evType():
-		return new(ItemChanged), nil
+		return new(ItemChanged)
+	case (&ItemMoved{}).evType():
+		return new(ItemMoved)
+	case (&ListAdded{}).evType():
+		return new(ListAdded)
+	case (&ListRemoved{}).evType():
+		return new(ListRemoved)
+	case (&ListChanged{}).evType():
+		return new(ListChanged)
+	case (&ListsReordered{}).evType():
+		return new(ListsReordered)
 	default:
-		return nil, fmt.Errorf("unknown event type %q", evtype)
+		panic(fmt.Errorf("makeEvent: unknown event type %q", evtype))
 	}
 }
@@ -0,0 +1,164 @@
+package todostore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// This checks that events written by older versions can still be decoded.
+func TestReadEventCompat(t *testing.T) {
+	input := `{"type":"add","event":{"ID":"a1","Item":{"Text":"buy milk","Done":false}}}
+{"type":"change","event":{"ID":"a1","Item":{"Text":"buy milk","Done":true}}}
+{"type":"remove","event":{"ID":"a1"}}
+`
+	want := []Event{
+		&ItemAdded{ID: "a1", Item: Item{Text: "buy milk"}},
+		&ItemChanged{ID: "a1", Item: Item{Text: "buy milk", Done: true}},
+		&ItemRemoved{ID: "a1"},
+	}
+	dec := json.NewDecoder(strings.NewReader(input))
+	for i, w := range want {
+		rec, err := readRecord(dec)
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(rec.ev, w) {
+			t.Fatalf("event %d mismatch:\n got %+v\nwant %+v", i, rec.ev, w)
+		}
+	}
+}
+
+func TestEventRoundTrip(t *testing.T) {
+	due := time.Date(2026, 10, 18, 14, 30, 0, 0, time.UTC)
+	records := []*record{
+		{ev: &fileHeader{Version: formatVersion}},
+		{stamp{"d1", 1, hlc{Time: 1000}}, &ItemAdded{ID: "a1", Item: Item{Text: "buy milk", List: "l1", Due: &due, Priority: PriorityHigh, Tags: []string{"shop"}, Notes: "2 liters"}, Pos: "V"}},
+		{stamp{"d1", 2, hlc{Time: 1001}}, &ItemMoved{ID: "a1", Pos: "G"}},
+		{stamp{"d2", 1, hlc{Time: 1001, Logical: 2}}, &ListAdded{ID: "l1", Name: "Groceries"}},
+		{stamp{"d2", 2, hlc{Time: 1005}}, &ListsReordered{Order: []ID{"l1", DefaultList}}},
+		{stamp{"d2", 3, hlc{Time: 1010}}, &BulkChange{Changes: []Event{
+			&ItemChanged{ID: "a1", Item: Item{Done: true}, Fields: []Field{FieldDone}},
+			&ItemMoved{ID: "a1", Pos: "K"},
+			&ItemRemoved{ID: "a2"},
+		}}},
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := writeRecord(enc, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dec := json.NewDecoder(&buf)
+	for i, w := range records {
+		rec, err := readRecord(dec)
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(rec, w) {
+			t.Fatalf("record %d mismatch:\n got %+v\nwant %+v", i, rec, w)
+		}
+	}
+}
+
+// This checks that unknown events and events of newer schema versions are skipped,
+// and that they are written back unchanged.
+func TestReadEventUnknown(t *testing.T) {
+	input := `{"type":"header","v":0,"event":{"version":3}}
+{"type":"add","v":1,"event":{"ID":"a1","Item":{"Text":"x","Done":false}},"extra":[1,2]}
+{"type":"frobnicate","v":1,"event":{"ID":"a1","Level":9}}
+{"type":"change","v":7,"event":{"ID":"a1","Fields":{"Text":"y"}}}
+{"type":"remove","v":1,"event":{"ID":"a1"}}
+`
+	dec := json.NewDecoder(strings.NewReader(input))
+	var events []Event
+	for {
+		rec, err := readRecord(dec)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, rec.ev)
+	}
+	want := []Event{
+		&fileHeader{Version: 3},
+		&ItemAdded{ID: "a1", Item: Item{Text: "x"}},
+		&UnknownEvent{Type: "frobnicate", Version: 1, Data: json.RawMessage(`{"ID":"a1","Level":9}`)},
+		&UnknownEvent{Type: "change", Version: 7, Data: json.RawMessage(`{"ID":"a1","Fields":{"Text":"y"}}`)},
+		&ItemRemoved{ID: "a1"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("wrong events:\n got %+v\nwant %+v", events, want)
+	}
+
+	// Check that unknown events are preserved.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, ev := range events[2:4] {
+		if err := writeRecord(enc, &record{ev: ev}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := strings.Split(input, "\n")
+	wantOutput := lines[2] + "\n" + lines[3] + "\n"
+	if buf.String() != wantOutput {
+		t.Fatalf("wrong output:\n got %s\nwant %s", buf.String(), wantOutput)
+	}
+}
+
+func TestReadEventMigration(t *testing.T) {
+	// Pretend that "move" events are at version 3. Version 1 had the position
+	// as a number, and version 2 called the field "Position".
+	moveMigrations := map[int]migration{
+		1: func(data json.RawMessage) (json.RawMessage, error) {
+			var v1 struct {
+				ID  ID
+				Pos int
+			}
+			if err := json.Unmarshal(data, &v1); err != nil {
+				return nil, err
+			}
+			return json.Marshal(map[string]any{"ID": v1.ID, "Position": fmt.Sprint(v1.Pos)})
+		},
+		2: func(data json.RawMessage) (json.RawMessage, error) {
+			return bytes.Replace(data, []byte(`"Position"`), []byte(`"Pos"`), 1), nil
+		},
+	}
+	sc := &schema{
+		versions:   map[string]int{"move": 3, "bulk": 1},
+		migrations: map[string]map[int]migration{"move": moveMigrations},
+	}
+
+	input := `{"type":"move","event":{"ID":"a1","Pos":5}}
+{"type":"move","v":2,"event":{"ID":"a1","Position":"V"}}
+{"type":"move","v":3,"event":{"ID":"a1","Pos":"G"}}
+{"type":"bulk","v":1,"event":{"Changes":[{"type":"move","v":1,"event":{"ID":"a1","Pos":7}},{"type":"move","v":2,"event":{"ID":"a2","Position":"W"}}]}}
+`
+	want := []Event{
+		&ItemMoved{ID: "a1", Pos: "5"},
+		&ItemMoved{ID: "a1", Pos: "V"},
+		&ItemMoved{ID: "a1", Pos: "G"},
+		// Changes in a bulk record are migrated like the record.
+		&BulkChange{Changes: []Event{
+			&ItemMoved{ID: "a1", Pos: "7"},
+			&ItemMoved{ID: "a2", Pos: "W"},
+		}},
+	}
+	dec := json.NewDecoder(strings.NewReader(input))
+	for i, w := range want {
+		rec, err := sc.readRecord(dec)
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(rec.ev, w) {
+			t.Fatalf("event %d mismatch:\n got %+v\nwant %+v", i, rec.ev, w)
+		}
+	}
+}
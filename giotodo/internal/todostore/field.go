@@ -0,0 +1,109 @@
+package todostore
+
+// Field identifies an attribute of an item.
+type Field string
+
+const (
+	FieldText     Field = "Text"
+	FieldDone     Field = "Done"
+	FieldList     Field = "List"
+	FieldDue      Field = "Due"
+	FieldPriority Field = "Priority"
+	FieldTags     Field = "Tags"
+	FieldNotes    Field = "Notes"
+	FieldParent   Field = "Parent"
+	FieldRepeat   Field = "Repeat"
+)
+
+// itemFields lists all fields of Item.
+var itemFields = []Field{FieldText, FieldDone, FieldList, FieldDue, FieldPriority, FieldTags, FieldNotes, FieldParent, FieldRepeat}
+
+// legacyFields are the fields set by ItemChanged events without field list. Such
+// events were written by builds which replaced the whole item, but didn't know about
+// fields added later, like Parent and Repeat. Applying them must not reset those
+// fields.
+var legacyFields = []Field{FieldText, FieldDone, FieldList, FieldDue, FieldPriority, FieldTags, FieldNotes}
+
+// ChangedFields returns the fields in which a and b differ.
+func ChangedFields(a, b *Item) []Field {
+	var fields []Field
+	for _, f := range itemFields {
+		if !fieldEqual(a, b, f) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// SetFields copies the given fields from src to dst.
+func SetFields(dst, src *Item, fields []Field) {
+	for _, f := range fields {
+		setField(dst, src, f)
+	}
+}
+
+// fieldEqual reports whether a and b have the same value of field f.
+func fieldEqual(a, b *Item, f Field) bool {
+	switch f {
+	case FieldText:
+		return a.Text == b.Text
+	case FieldDone:
+		return a.Done == b.Done
+	case FieldList:
+		return a.List == b.List
+	case FieldDue:
+		return (a.Due == nil) == (b.Due == nil) && (a.Due == nil || a.Due.Equal(*b.Due))
+	case FieldPriority:
+		return a.Priority == b.Priority
+	case FieldTags:
+		if len(a.Tags) != len(b.Tags) {
+			return false
+		}
+		for i := range a.Tags {
+			if a.Tags[i] != b.Tags[i] {
+				return false
+			}
+		}
+		return true
+	case FieldNotes:
+		return a.Notes == b.Notes
+	case FieldParent:
+		return a.Parent == b.Parent
+	case FieldRepeat:
+		return a.Repeat == b.Repeat
+	}
+	return true
+}
+
+// setField copies field f from src to dst.
+func setField(dst, src *Item, f Field) {
+	switch f {
+	case FieldText:
+		dst.Text = src.Text
+	case FieldDone:
+		dst.Done = src.Done
+	case FieldList:
+		dst.List = src.List
+	case FieldDue:
+		dst.Due = src.Due
+	case FieldPriority:
+		dst.Priority = src.Priority
+	case FieldTags:
+		dst.Tags = src.Tags
+	case FieldNotes:
+		dst.Notes = src.Notes
+	case FieldParent:
+		dst.Parent = src.Parent
+	case FieldRepeat:
+		dst.Repeat = src.Repeat
+	}
+}
+
+func itemEqual(a, b *Item) bool {
+	for _, f := range itemFields {
+		if !fieldEqual(a, b, f) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package todostore
+
+// fileLock does nothing on platforms without file locking.
+type fileLock struct{}
+
+func openLock(name string) (*fileLock, error) { return new(fileLock), nil }
+
+func (l *fileLock) lock() error   { return nil }
+func (l *fileLock) unlock() error { return nil }
+func (l *fileLock) close() error  { return nil }
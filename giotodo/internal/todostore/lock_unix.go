@@ -0,0 +1,35 @@
+//go:build unix
+
+package todostore
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileLock is an advisory lock on a file. It protects the data file against
+// concurrent writes by other processes using the same data directory.
+type fileLock struct {
+	f *os.File
+}
+
+func openLock(name string) (*fileLock, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLock{f}, nil
+}
+
+func (l *fileLock) lock() error {
+	return unix.Flock(int(l.f.Fd()), unix.LOCK_EX)
+}
+
+func (l *fileLock) unlock() error {
+	return unix.Flock(int(l.f.Fd()), unix.LOCK_UN)
+}
+
+func (l *fileLock) close() error {
+	return l.f.Close()
+}
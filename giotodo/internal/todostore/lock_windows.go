@@ -0,0 +1,35 @@
+package todostore
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileLock is a lock on a file. It protects the data file against concurrent
+// writes by other processes using the same data directory.
+type fileLock struct {
+	f *os.File
+}
+
+func openLock(name string) (*fileLock, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLock{f}, nil
+}
+
+func (l *fileLock) lock() error {
+	ol := new(windows.Overlapped)
+	return windows.LockFileEx(windows.Handle(l.f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, ol)
+}
+
+func (l *fileLock) unlock() error {
+	ol := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(l.f.Fd()), 0, 1, 0, ol)
+}
+
+func (l *fileLock) close() error {
+	return l.f.Close()
+}
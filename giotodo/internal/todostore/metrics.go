@@ -0,0 +1,117 @@
+package todostore
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements of the store. Methods are called from store
+// goroutines and must not block.
+type Metrics interface {
+	// RecordWritten is called when a record of size bytes was written to the data file.
+	RecordWritten(size int)
+	// Fsync is called when the data file was flushed to disk.
+	Fsync(d time.Duration, err error)
+	// Replay is called when the data file was read at startup. Skipped records have
+	// an unknown type.
+	Replay(records, skipped int, d time.Duration)
+	// QueueDepth is called with the number of requests waiting to be handled, each
+	// time a batch of requests is taken from the queue.
+	QueueDepth(n int)
+	// BatchWritten is called when the records of a batch of requests were written.
+	BatchWritten(requests int)
+	// EventDropped is called when an output event was discarded because there were
+	// no subscriptions.
+	EventDropped()
+}
+
+// nopMetrics is used when no Metrics are configured.
+type nopMetrics struct{}
+
+func (nopMetrics) RecordWritten(int)              {}
+func (nopMetrics) Fsync(time.Duration, error)     {}
+func (nopMetrics) Replay(int, int, time.Duration) {}
+func (nopMetrics) QueueDepth(int)                 {}
+func (nopMetrics) BatchWritten(int)               {}
+func (nopMetrics) EventDropped()                  {}
+
+// Stats is a Metrics implementation which keeps totals and recent values. It is
+// safe for concurrent use.
+type Stats struct {
+	mu sync.Mutex
+	v  StatsValues
+}
+
+// StatsValues are the values collected by Stats.
+type StatsValues struct {
+	RecordsWritten int64
+	BytesWritten   int64
+
+	Fsyncs      int64
+	FsyncErrors int64
+	LastFsync   time.Duration
+	MaxFsync    time.Duration
+
+	ReplayRecords  int
+	ReplaySkipped  int
+	ReplayDuration time.Duration
+
+	QueueDepth    int // depth when the last batch was taken
+	MaxQueueDepth int
+
+	Batches  int64
+	MaxBatch int
+
+	EventsDropped int64
+}
+
+// Values returns the current values.
+func (s *Stats) Values() StatsValues {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.v
+}
+
+func (s *Stats) RecordWritten(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.v.RecordsWritten++
+	s.v.BytesWritten += int64(size)
+}
+
+func (s *Stats) Fsync(d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.v.Fsyncs++
+	if err != nil {
+		s.v.FsyncErrors++
+	}
+	s.v.LastFsync = d
+	s.v.MaxFsync = max(s.v.MaxFsync, d)
+}
+
+func (s *Stats) Replay(records, skipped int, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.v.ReplayRecords, s.v.ReplaySkipped, s.v.ReplayDuration = records, skipped, d
+}
+
+func (s *Stats) QueueDepth(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.v.QueueDepth = n
+	s.v.MaxQueueDepth = max(s.v.MaxQueueDepth, n)
+}
+
+func (s *Stats) BatchWritten(requests int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.v.Batches++
+	s.v.MaxBatch = max(s.v.MaxBatch, requests)
+}
+
+func (s *Stats) EventDropped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.v.EventsDropped++
+}
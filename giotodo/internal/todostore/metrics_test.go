@@ -0,0 +1,91 @@
+package todostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestStoreMetrics(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		stats = new(Stats)
+		logs  bytes.Buffer
+		opts  = Options{
+			Logger:  slog.New(slog.NewJSONHandler(&logs, nil)),
+			Metrics: stats,
+		}
+	)
+	s := Open(dir, opts)
+	// Block mainLoop while requests are queued.
+	unblock := make(chan struct{})
+	blocked := make(chan struct{})
+	go s.runInLoop(func() error {
+		close(blocked)
+		<-unblock
+		return nil
+	})
+	<-blocked
+	for i := 0; i < 5; i++ {
+		s.AddItem(Item{Text: "item"})
+	}
+	close(unblock)
+	if _, err := s.AddItemContext(context.Background(), Item{Text: "last"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Persist()
+	deadline := time.Now().Add(5 * time.Second)
+	for stats.Values().Fsyncs == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	s.Close()
+
+	v := stats.Values()
+	if v.RecordsWritten != 7 { // header and items
+		t.Errorf("RecordsWritten = %d, want 7", v.RecordsWritten)
+	}
+	if v.BytesWritten == 0 {
+		t.Error("BytesWritten = 0")
+	}
+	if v.Fsyncs != 1 || v.FsyncErrors != 0 {
+		t.Errorf("Fsyncs = %d, FsyncErrors = %d", v.Fsyncs, v.FsyncErrors)
+	}
+	if v.MaxQueueDepth < 4 {
+		t.Errorf("MaxQueueDepth = %d, want >= 4", v.MaxQueueDepth)
+	}
+	// Without subscriptions, all events are dropped.
+	if v.EventsDropped == 0 {
+		t.Error("EventsDropped = 0")
+	}
+
+	// Opening the store again replays the file.
+	s = Open(dir, opts)
+	snapshotOf(t, s)
+	s.Close()
+	if v := stats.Values(); v.ReplayRecords != 7 || v.ReplaySkipped != 0 {
+		t.Errorf("ReplayRecords = %d, ReplaySkipped = %d", v.ReplayRecords, v.ReplaySkipped)
+	}
+
+	// Log records are structured.
+	var found bool
+	dec := json.NewDecoder(&logs)
+	for dec.More() {
+		var rec struct {
+			Msg     string
+			Records int
+			Datadir string
+		}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Msg == "replay done" && rec.Records == 7 && rec.Datadir == dir {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("replay not logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,76 @@
+package todostore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/recur"
+)
+
+// When a recurring item is completed, its next occurrence is added as a new item.
+// The occurrence is created by the device on which the item is completed, and stored
+// as a regular ItemAdded record. Replaying the log only applies that record, it never
+// creates occurrences. The ID of an occurrence is derived from the completed item and
+// the new due date, so when an item is completed on two devices concurrently, both
+// add the same item, which is merged instead of duplicated. Completing an item again
+// doesn't add another occurrence while the previous one exists.
+
+// OccurrenceID returns the ID of the occurrence following item id, due at the given time.
+func OccurrenceID(id ID, due time.Time) ID {
+	h := sha256.Sum256([]byte(string(id) + "\x00" + due.UTC().Format(time.RFC3339Nano)))
+	return ID(hex.EncodeToString(h[:16]))
+}
+
+// NextOccurrence returns the ID and content of the occurrence following a recurring
+// item. It returns false when the item doesn't recur. Only items with due date and a
+// valid recurrence rule recur.
+func NextOccurrence(id ID, it Item) (ID, Item, bool) {
+	if it.Repeat == "" || it.Due == nil {
+		return "", Item{}, false
+	}
+	rule, err := recur.Parse(it.Repeat)
+	if err != nil {
+		return "", Item{}, false
+	}
+	due := rule.Next(*it.Due)
+	it.Done = false
+	it.Due = &due
+	return OccurrenceID(id, due), it, true
+}
+
+// nextOccurrence returns the event which adds the next occurrence of an item when ev
+// completes it. It returns nil when ev doesn't complete a recurring item, or when the
+// next occurrence exists already.
+func (st *state) nextOccurrence(ev *ItemChanged) *ItemAdded {
+	s := st.items[ev.ID]
+	if s == nil || !s.alive() || s.item.Done {
+		return nil
+	}
+	it := s.item
+	for _, f := range ev.Fields {
+		setField(&it, &ev.Item, f)
+	}
+	if !it.Done {
+		return nil
+	}
+	id, next, ok := NextOccurrence(ev.ID, it)
+	if !ok {
+		return nil
+	}
+	if ns := st.items[id]; ns != nil && ns.alive() {
+		return nil
+	}
+	return &ItemAdded{ID: id, Item: next, Pos: st.posAfter(it.List, s.pos)}
+}
+
+// posAfter returns a position directly behind pos in the given list.
+func (st *state) posAfter(list ID, pos Pos) Pos {
+	var next Pos
+	for _, s := range st.items {
+		if s.alive() && s.item.List == list && s.pos > pos && (next == "" || s.pos < next) {
+			next = s.pos
+		}
+	}
+	return PosBetween(pos, next)
+}
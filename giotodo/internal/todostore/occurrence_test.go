@@ -0,0 +1,65 @@
+package todostore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStoreRecurringItem(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir)
+	defer func() { s.Close() }()
+
+	due := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	id := s.AddItem(Item{Text: "water plants", Due: &due, Repeat: "FREQ=WEEKLY;BYDAY=MO,TH"})
+	s.AddItem(Item{Text: "other"})
+	done := func(id ID, done bool) {
+		s.UpdateItem(id, Item{Done: done}, FieldDone)
+	}
+	done(id, true)
+
+	nextID := OccurrenceID(id, due.AddDate(0, 0, 3))
+	check := func(wantTexts ...string) {
+		t.Helper()
+		snap := snapshotOf(t, s)
+		var texts []string
+		for _, info := range snap.Items {
+			texts = append(texts, info.Item.Text)
+		}
+		if len(texts) != len(wantTexts) {
+			t.Fatalf("wrong items %q, want %q", texts, wantTexts)
+		}
+		for i := range texts {
+			if texts[i] != wantTexts[i] {
+				t.Fatalf("wrong items %q, want %q", texts, wantTexts)
+			}
+		}
+	}
+	check("water plants", "water plants", "other")
+	snap := snapshotOf(t, s)
+	if next := snap.Items[1]; next.ID != nextID || next.Item.Done || !next.Item.Due.Equal(due.AddDate(0, 0, 3)) {
+		t.Fatalf("wrong next occurrence %+v", next)
+	}
+
+	// Completing the item again doesn't add another occurrence.
+	done(id, false)
+	done(id, true)
+	check("water plants", "water plants", "other")
+
+	// Replaying the log doesn't add occurrences either.
+	s.Close()
+	s = NewStore(dir)
+	check("water plants", "water plants", "other")
+
+	// When the occurrence was removed, completing the item brings it back.
+	s.RemoveItem(nextID)
+	done(id, false)
+	check("water plants", "other")
+	done(id, true)
+	check("water plants", "water plants", "other")
+
+	// Items without due date don't recur.
+	s.UpdateItem(nextID, Item{}, FieldDue)
+	done(nextID, true)
+	check("water plants", "water plants", "other")
+}
@@ -0,0 +1,99 @@
+package todostore
+
+import "strings"
+
+// Pos is the position of an item within its list. Positions are fractional indices
+// encoded as strings of base-62 digits, and items are ordered by comparing them
+// lexicographically. Since there is always room between two distinct positions,
+// moving an item never requires renumbering any other item, and replaying the moves
+// in log order gives the same result every time.
+//
+// The empty position means 'unset': items added without a position are placed after
+// all other items of the list.
+type Pos string
+
+const posDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// PosBetween returns a position that sorts after a and before b. The empty string
+// is treated as the start of the list for a, and as the end of the list for b.
+//
+// If a is not less than b, there is no position between them. In this case,
+// PosBetween returns a position directly after a.
+func PosBetween(a, b Pos) Pos {
+	if b != "" && a >= b {
+		b = ""
+	}
+	return Pos(posMidpoint(string(a), string(b)))
+}
+
+// PosAfter returns a position that sorts after a, for adding items at the end of a
+// list. Unlike PosBetween(a, ""), which halves the remaining space every time, it
+// counts up in positions of the same length, and doubles the length when they run out.
+// Positions created by appending many items grow with the logarithm of their number,
+// not linearly.
+func PosAfter(a Pos) Pos {
+	if a == "" {
+		return PosBetween("", "")
+	}
+	// Increment the last digit that isn't the highest one. The digits after it
+	// restart at one, because positions can't end in zero.
+	last := posDigits[len(posDigits)-1]
+	for i := len(a) - 1; i >= 0; i-- {
+		if a[i] != last {
+			d := strings.IndexByte(posDigits, a[i])
+			return a[:i] + Pos(posDigits[d+1]) + Pos(strings.Repeat(posDigits[1:2], len(a)-i-1))
+		}
+	}
+	// All digits are the highest one. Continue with a number of twice the length.
+	return a + Pos(strings.Repeat(posDigits[:1], len(a)-1)+posDigits[1:2])
+}
+
+// posMidpoint computes a digit string between a and b. Neither a nor b may have
+// trailing zero digits, and neither does the result. This ensures there is always
+// another position available between any two positions.
+func posMidpoint(a, b string) string {
+	if b != "" {
+		// Skip the common prefix. a is padded with zeros to the length of b.
+		n := 0
+		for n < len(b) && posDigitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			if n > len(a) {
+				a = ""
+			} else {
+				a = a[n:]
+			}
+			return b[:n] + posMidpoint(a, b[n:])
+		}
+	}
+
+	// First digits are different.
+	da, db := 0, len(posDigits)
+	if a != "" {
+		da = strings.IndexByte(posDigits, a[0])
+	}
+	if b != "" {
+		db = strings.IndexByte(posDigits, b[0])
+	}
+	if db-da > 1 {
+		return string(posDigits[(da+db+1)/2])
+	}
+	// Digits are consecutive. If b is longer than one digit, its first digit
+	// alone sorts before it and after a.
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(posDigits[da]) + posMidpoint(rest, "")
+}
+
+func posDigitAt(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return posDigits[0]
+}
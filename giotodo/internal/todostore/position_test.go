@@ -0,0 +1,90 @@
+package todostore
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPosBetween(t *testing.T) {
+	tests := []struct {
+		a, b Pos
+		want Pos
+	}{
+		{"", "", "V"},
+		{"V", "", "l"},
+		{"", "V", "G"},
+		{"", "1", "0V"},
+		{"z", "", "zV"},
+		{"0V", "1", "0l"},
+		{"1", "1V", "1G"},
+		{"V", "V", "l"}, // no room, placed after a
+	}
+	for _, test := range tests {
+		if p := PosBetween(test.a, test.b); p != test.want {
+			t.Errorf("PosBetween(%q, %q) = %q, want %q", test.a, test.b, p, test.want)
+		}
+	}
+}
+
+func TestPosAfter(t *testing.T) {
+	tests := []struct {
+		a, want Pos
+	}{
+		{"", "V"},
+		{"V", "W"},
+		{"y", "z"},
+		{"z", "z1"},
+		{"z1", "z2"},
+		{"Az", "B1"},
+		{"Vzz", "W11"},
+		{"zz", "zz01"},
+		{"zzzz", "zzzz0001"},
+	}
+	for _, test := range tests {
+		if p := PosAfter(test.a); p != test.want {
+			t.Errorf("PosAfter(%q) = %q, want %q", test.a, p, test.want)
+		}
+	}
+
+	// Appending many items keeps positions short.
+	var p Pos
+	for i := 0; i < 100000; i++ {
+		next := PosAfter(p)
+		if next <= p || strings.HasSuffix(string(next), "0") {
+			t.Fatalf("PosAfter(%q) = %q", p, next)
+		}
+		p = next
+	}
+	if len(p) > 8 {
+		t.Fatalf("position %q too long", p)
+	}
+}
+
+func TestPosBetweenRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	list := []Pos{}
+	for i := 0; i < 2000; i++ {
+		// Insert at random index.
+		idx := rng.Intn(len(list) + 1)
+		var a, b Pos
+		if idx > 0 {
+			a = list[idx-1]
+		}
+		if idx < len(list) {
+			b = list[idx]
+		}
+		p := PosBetween(a, b)
+		if p <= a || (b != "" && p >= b) {
+			t.Fatalf("PosBetween(%q, %q) = %q, not between", a, b, p)
+		}
+		if strings.HasSuffix(string(p), "0") {
+			t.Fatalf("PosBetween(%q, %q) = %q has trailing zero", a, b, p)
+		}
+		list = append(list[:idx], append([]Pos{p}, list[idx:]...)...)
+	}
+	if !sort.SliceIsSorted(list, func(i, j int) bool { return list[i] < list[j] }) {
+		t.Fatal("list not sorted")
+	}
+}
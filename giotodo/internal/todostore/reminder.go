@@ -0,0 +1,269 @@
+package todostore
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Reminders are delivered by a scheduler goroutine, which is started by
+// StartReminders. The upcoming reminders are kept in a queue ordered by time, which
+// mainLoop updates from the events of applied records, so a change costs O(log n)
+// for n reminders. The scheduler waits until the next reminder is due, takes it from
+// the queue, delivers it to the Notifier, and then wakes all subscriptions of the
+// store.
+//
+// Only reminders which become due while the store is open are delivered, so items
+// which were overdue already when the app started don't cause a flood of
+// notifications.
+
+// reminderHour is the time of day at which items with a due date, but no due time,
+// are reminded of.
+const reminderHour = 9
+
+// Reminder is delivered to a Notifier when an item becomes due.
+type Reminder struct {
+	ID   ID
+	Item Item
+	At   time.Time // time of the reminder
+}
+
+// Notifier shows reminders to the user. Notify is called on the scheduler goroutine.
+type Notifier interface {
+	Notify(Reminder) error
+}
+
+// Notifiers delivers reminders to multiple notifiers.
+type Notifiers []Notifier
+
+func (ns Notifiers) Notify(r Reminder) error {
+	var errs []error
+	for _, n := range ns {
+		if err := n.Notify(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReminderTime returns when an item due at the given time should be reminded of.
+func ReminderTime(due time.Time) time.Time {
+	if h, m, s := due.Clock(); h == 0 && m == 0 && s == 0 {
+		return due.Add(reminderHour * time.Hour)
+	}
+	return due
+}
+
+// StartReminders starts delivering reminders of due items to n.
+func (s *Store) StartReminders(n Notifier) {
+	start := time.Now()
+	err := s.runInLoop(func() error {
+		if s.remindersStarted {
+			return errors.New("reminders already started")
+		}
+		s.remindersStarted = true
+		s.wg.Add(1)
+		go s.reminderLoop(n, start)
+		return nil
+	})
+	if err != nil {
+		s.log.Error("can't start reminders", "err", err)
+	}
+}
+
+// reminderLoop is the scheduler goroutine. Reminders due before start are skipped.
+func (s *Store) reminderLoop(n Notifier, start time.Time) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.reminders.changed:
+		case <-timer.C:
+		case <-s.quitCh:
+			return
+		}
+
+		// Deliver due reminders.
+		now := time.Now()
+		due, next := s.reminders.takeDue(now)
+		delivered := false
+		for _, r := range due {
+			if !r.At.After(start) {
+				continue
+			}
+			if err := n.Notify(r); err != nil {
+				s.log.Warn("can't deliver reminder", "item", r.ID, "err", err)
+			}
+			delivered = true
+		}
+		if delivered {
+			s.out.wakeAll()
+		}
+
+		// Wait for the next one.
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(next.Sub(now))
+		}
+	}
+}
+
+// reminderQueue holds the reminders of all shown items which are not done, ordered
+// by time. It is updated by mainLoop and read by the scheduler.
+type reminderQueue struct {
+	mu       sync.Mutex
+	heap     reminderHeap
+	byID     map[ID]*queuedReminder
+	notified map[ID]time.Time // time of the last reminder taken, by item
+	changed  chan struct{}    // wakes the scheduler
+}
+
+type queuedReminder struct {
+	Reminder
+	index int // in heap
+}
+
+func newReminderQueue() *reminderQueue {
+	return &reminderQueue{
+		byID:     make(map[ID]*queuedReminder),
+		notified: make(map[ID]time.Time),
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// handle updates the queue with an event of the state.
+func (q *reminderQueue) handle(ev Event) {
+	switch ev := ev.(type) {
+	case *ItemAdded:
+		q.update(ev.ID, ev.Item)
+	case *ItemChanged:
+		q.update(ev.ID, ev.Item)
+	case *ItemRemoved:
+		q.remove(func(r *queuedReminder) bool { return r.ID == ev.ID })
+	case *ListRemoved:
+		// The items of the list are hidden without ItemRemoved events.
+		q.remove(func(r *queuedReminder) bool { return r.Item.List == ev.ID })
+	}
+}
+
+// update sets the reminder of an item.
+func (q *reminderQueue) update(id ID, item Item) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	r := q.byID[id]
+	if item.Done || item.Due == nil {
+		if r != nil {
+			heap.Remove(&q.heap, r.index)
+			delete(q.byID, id)
+			q.wake()
+		}
+		return
+	}
+	at := ReminderTime(*item.Due)
+	switch {
+	case r != nil:
+		r.Item = item
+		if !r.At.Equal(at) {
+			r.At = at
+			heap.Fix(&q.heap, r.index)
+			q.wake()
+		}
+	case !q.notified[id].Equal(at):
+		r = &queuedReminder{Reminder: Reminder{ID: id, Item: item, At: at}}
+		heap.Push(&q.heap, r)
+		q.byID[id] = r
+		q.wake()
+	}
+}
+
+// remove removes the reminders matching fn.
+func (q *reminderQueue) remove(fn func(*queuedReminder) bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := 0; i < len(q.heap); {
+		if r := q.heap[i]; fn(r) {
+			heap.Remove(&q.heap, i)
+			delete(q.byID, r.ID)
+			q.wake()
+		} else {
+			i++
+		}
+	}
+}
+
+// reset removes all reminders. It is called when the state is read again, which
+// creates ItemAdded events for all items.
+func (q *reminderQueue) reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.heap = q.heap[:0]
+	clear(q.byID)
+	q.wake()
+}
+
+// takeDue removes the reminders due at now from the queue. It also returns the time
+// of the next reminder, or zero if there is none.
+func (q *reminderQueue) takeDue(now time.Time) (due []Reminder, next time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.heap) > 0 && !q.heap[0].At.After(now) {
+		r := heap.Pop(&q.heap).(*queuedReminder)
+		delete(q.byID, r.ID)
+		q.notified[r.ID] = r.At
+		due = append(due, r.Reminder)
+	}
+	if len(q.heap) > 0 {
+		next = q.heap[0].At
+	}
+	return due, next
+}
+
+func (q *reminderQueue) wake() {
+	select {
+	case q.changed <- struct{}{}:
+	default:
+	}
+}
+
+// reminderHeap implements heap.Interface.
+type reminderHeap []*queuedReminder
+
+func (h reminderHeap) Len() int { return len(h) }
+
+func (h reminderHeap) Less(i, j int) bool {
+	if !h[i].At.Equal(h[j].At) {
+		return h[i].At.Before(h[j].At)
+	}
+	return h[i].ID < h[j].ID
+}
+
+func (h reminderHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *reminderHeap) Push(x any) {
+	r := x.(*queuedReminder)
+	r.index = len(*h)
+	*h = append(*h, r)
+}
+
+func (h *reminderHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return r
+}
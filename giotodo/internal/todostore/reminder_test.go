@@ -0,0 +1,150 @@
+package todostore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records reminders.
+type fakeNotifier struct {
+	ch chan Reminder
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{ch: make(chan Reminder, 10)}
+}
+
+func (n *fakeNotifier) Notify(r Reminder) error {
+	n.ch <- r
+	return nil
+}
+
+// next waits for a reminder.
+func (n *fakeNotifier) next(t *testing.T) Reminder {
+	t.Helper()
+	select {
+	case r := <-n.ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reminder delivered")
+		return Reminder{}
+	}
+}
+
+// none checks that no reminder is delivered for a while.
+func (n *fakeNotifier) none(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case r := <-n.ch:
+		t.Fatalf("unexpected reminder for %q", r.Item.Text)
+	case <-time.After(d):
+	}
+}
+
+func TestReminders(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+	sub := s.Subscribe(nil)
+	defer sub.Close()
+
+	soon := func(d time.Duration) *time.Time {
+		due := time.Now().Add(d).Truncate(time.Millisecond)
+		if due.Nanosecond() == 0 {
+			due = due.Add(time.Millisecond) // avoid date-only due times
+		}
+		return &due
+	}
+	overdue := time.Now().Add(-time.Hour)
+	s.AddItem(Item{Text: "overdue", Due: &overdue})
+	s.AddItem(Item{Text: "no due date"})
+	id := s.AddItem(Item{Text: "soon", Due: soon(100 * time.Millisecond)})
+	doneID := s.AddItem(Item{Text: "done before due", Due: soon(200 * time.Millisecond)})
+	s.UpdateItem(doneID, Item{Done: true}, FieldDone)
+
+	n := newFakeNotifier()
+	s.StartReminders(n)
+	if r := n.next(t); r.ID != id {
+		t.Fatalf("wrong reminder for %q", r.Item.Text)
+	}
+	n.none(t, 300*time.Millisecond)
+
+	// Changing the due time schedules a new reminder.
+	s.UpdateItem(id, Item{Due: soon(200 * time.Millisecond)}, FieldDue)
+	snapshotOf(t, s)
+	select {
+	case <-sub.Ready():
+	default:
+	}
+	if r := n.next(t); r.ID != id {
+		t.Fatalf("wrong reminder for %q", r.Item.Text)
+	}
+	// Subscribers are woken after the reminder.
+	select {
+	case <-sub.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not woken")
+	}
+	n.none(t, 200*time.Millisecond)
+}
+
+func TestReminderTime(t *testing.T) {
+	date := time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local)
+	if rt := ReminderTime(date); !rt.Equal(date.Add(reminderHour * time.Hour)) {
+		t.Errorf("wrong reminder time %v for date", rt)
+	}
+	due := time.Date(2026, 10, 18, 14, 30, 0, 0, time.Local)
+	if rt := ReminderTime(due); !rt.Equal(due) {
+		t.Errorf("wrong reminder time %v for %v", rt, due)
+	}
+}
+
+func TestReminderQueue(t *testing.T) {
+	base := time.Date(2026, 10, 18, 10, 0, 0, 0, time.Local)
+	at := func(minutes int) *time.Time {
+		at := base.Add(time.Duration(minutes) * time.Minute)
+		return &at
+	}
+	check := func(due []Reminder, wantIDs ...ID) {
+		t.Helper()
+		ids := make([]ID, len(due))
+		for i, r := range due {
+			ids[i] = r.ID
+		}
+		if len(wantIDs) == 0 {
+			wantIDs = []ID{}
+		}
+		if !reflect.DeepEqual(ids, wantIDs) {
+			t.Fatalf("due reminders %v, want %v", ids, wantIDs)
+		}
+	}
+
+	q := newReminderQueue()
+	q.handle(&ItemAdded{ID: "a", Item: Item{Text: "a", Due: at(3)}})
+	q.handle(&ItemAdded{ID: "b", Item: Item{Text: "b", Due: at(1)}})
+	q.handle(&ItemAdded{ID: "c", Item: Item{Text: "c", Due: at(2), List: "l"}})
+	q.handle(&ItemAdded{ID: "d", Item: Item{Text: "d", Due: at(2), Done: true}})
+	q.handle(&ItemAdded{ID: "e", Item: Item{Text: "e"}})
+	q.handle(&ItemChanged{ID: "b", Item: Item{Text: "b changed", Due: at(4)}})
+	q.handle(&ListRemoved{ID: "l"})
+
+	due, next := q.takeDue(*at(3))
+	check(due, "a")
+	if !next.Equal(*at(4)) {
+		t.Fatalf("next reminder at %v", next)
+	}
+
+	// Delivered reminders aren't delivered again when the item changes.
+	q.handle(&ItemChanged{ID: "a", Item: Item{Text: "a changed", Due: at(3)}})
+	q.handle(&ItemAdded{ID: "f", Item: Item{Text: "f", Due: at(0)}})
+	q.handle(&ItemAdded{ID: "g", Item: Item{Text: "g", Due: at(5)}})
+	q.handle(&ItemRemoved{ID: "g"})
+	due, next = q.takeDue(*at(10))
+	check(due, "f", "b")
+	if due[1].Item.Text != "b changed" {
+		t.Fatalf("reminder has outdated item %+v", due[1].Item)
+	}
+	if !next.IsZero() {
+		t.Fatalf("next reminder at %v", next)
+	}
+}
@@ -0,0 +1,245 @@
+package todostore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Every change requested by the app is a request. Requests are numbered, and the
+// store sends a WriteResult event for each of them when it has been stored, or has
+// failed. The methods which change the store return immediately. Methods with the
+// Context suffix wait for the result instead.
+//
+// Requests wait in a queue until mainLoop takes them, several at a time, and writes
+// them to the data file together (see handleBatch). The queue holds up to maxQueued
+// requests. Methods without context never block, so the UI isn't held up by slow
+// storage. When the queue is full, they fail with ErrQueueFull, which is reported
+// by the WriteResult of the request. Methods with context apply backpressure
+// instead: they wait until the queue has space.
+
+const (
+	maxQueued = 4096 // queue length at which requests are rejected or wait
+	maxBatch  = 256  // number of requests written at once
+)
+
+// ErrQueueFull is the error of requests which were rejected because the store has
+// too many unwritten requests. They can be retried later.
+var ErrQueueFull = errors.New("too many unsaved changes")
+
+// RequestID identifies a change requested by the app.
+type RequestID uint64
+
+// WriteResult is sent when a request has been handled. When Err is non-nil, the
+// change was not stored. It can be retried using Store.Retry.
+type WriteResult struct {
+	Request RequestID
+	Target  ID // item or list changed by the request, empty for ListsReordered and Bulk
+	Err     error
+
+	ev Event
+}
+
+func (*WriteResult) evType() string { return "write-result" }
+
+// inputRequest is an event created by the app.
+type inputRequest struct {
+	id   RequestID
+	ev   Event
+	done chan error // receives the result, may be nil
+}
+
+// inputQueue holds requests until mainLoop takes them.
+type inputQueue struct {
+	mu    sync.Mutex
+	reqs  []*inputRequest
+	ready chan struct{} // receives a value when requests are added
+	space chan struct{} // closed when the queue is shorter than maxQueued, nil if unused
+}
+
+func newInputQueue() *inputQueue {
+	return &inputQueue{ready: make(chan struct{}, 1)}
+}
+
+// push adds a request. It never blocks. When the queue holds maxQueued requests,
+// the request is rejected and push returns false, unless force is set. Requests
+// with context are forced, since they have waited for space already.
+func (q *inputQueue) push(req *inputRequest, force bool) bool {
+	q.mu.Lock()
+	if !force && len(q.reqs) >= maxQueued {
+		q.mu.Unlock()
+		return false
+	}
+	q.reqs = append(q.reqs, req)
+	q.mu.Unlock()
+	q.signal()
+	return true
+}
+
+func (q *inputQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// waitSpace blocks while the queue holds maxQueued requests or more.
+func (q *inputQueue) waitSpace(ctx context.Context, quit <-chan struct{}) error {
+	q.mu.Lock()
+	if len(q.reqs) < maxQueued {
+		q.mu.Unlock()
+		return nil
+	}
+	if q.space == nil {
+		q.space = make(chan struct{})
+	}
+	space := q.space
+	q.mu.Unlock()
+
+	select {
+	case <-space:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-quit:
+		return errStoreClosed
+	}
+}
+
+// take removes up to n requests from the queue. It also returns the length of the
+// queue before taking them.
+func (q *inputQueue) take(n int) (reqs []*inputRequest, queued int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued = len(q.reqs)
+	n = min(n, queued)
+	// The taken requests stay in the array until append allocates a new one.
+	reqs, q.reqs = q.reqs[:n:n], q.reqs[n:]
+	rest := len(q.reqs)
+	if rest == 0 {
+		q.reqs = nil
+	}
+
+	if rest > 0 {
+		q.signal() // more to do
+	}
+	if q.space != nil && rest < maxQueued {
+		close(q.space)
+		q.space = nil
+	}
+	return reqs, queued
+}
+
+// requestSeq is the last request ID of all stores. IDs are unique per process so
+// that requests are not confused when the app opens another store.
+var requestSeq atomic.Uint64
+
+// AddItemContext adds an item and waits until it is stored. Note that the item may
+// still be stored when ctx is canceled.
+func (s *Store) AddItemContext(ctx context.Context, item Item) (ID, error) {
+	id := randomID()
+	return id, s.do(ctx, &ItemAdded{ID: id, Item: item})
+}
+
+// UpdateItemContext changes an item and waits until the change is stored. See
+// UpdateItem for the meaning of fields.
+func (s *Store) UpdateItemContext(ctx context.Context, id ID, item Item, fields ...Field) error {
+	return s.do(ctx, &ItemChanged{ID: id, Item: item, Fields: fields})
+}
+
+// RemoveItemContext deletes an item and waits until the removal is stored.
+func (s *Store) RemoveItemContext(ctx context.Context, id ID) error {
+	return s.do(ctx, &ItemRemoved{ID: id})
+}
+
+// AddListContext creates a new list and waits until it is stored.
+func (s *Store) AddListContext(ctx context.Context, name string) (ID, error) {
+	id := randomID()
+	return id, s.do(ctx, &ListAdded{ID: id, Name: name})
+}
+
+// Retry requests a failed change again.
+func (s *Store) Retry(res *WriteResult) RequestID {
+	return s.enqueueInputEvent(res.ev)
+}
+
+// enqueueInputEvent delivers an event from the app to mainLoop. When the queue is
+// full, the request fails right away.
+func (s *Store) enqueueInputEvent(ev Event) RequestID {
+	req := &inputRequest{id: RequestID(requestSeq.Add(1)), ev: ev}
+	if err := s.sendRequest(req, false); err == ErrQueueFull {
+		s.enqueueOutputEvent(&WriteResult{Request: req.id, Target: eventTarget(ev), Err: err, ev: ev})
+	}
+	return req.id
+}
+
+// do delivers an event to mainLoop and waits for the result.
+func (s *Store) do(ctx context.Context, ev Event) error {
+	if err := s.in.waitSpace(ctx, s.quitCh); err != nil {
+		return err
+	}
+	req := &inputRequest{id: RequestID(requestSeq.Add(1)), ev: ev, done: make(chan error, 1)}
+	if err := s.sendRequest(req, true); err != nil {
+		return err
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.loopDone:
+		// The request may have been handled while the store was closing.
+		select {
+		case err := <-req.done:
+			return err
+		default:
+			return errStoreClosed
+		}
+	}
+}
+
+// sendRequest adds a request to the queue. See inputQueue.push for the meaning of force.
+func (s *Store) sendRequest(req *inputRequest, force bool) error {
+	select {
+	case <-s.quitCh:
+		return errStoreClosed
+	default:
+	}
+	if !s.in.push(req, force) {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// handleQueued takes a batch of requests from the queue and handles it. It returns
+// false if the queue is empty.
+func (s *Store) handleQueued() (bool, error) {
+	reqs, queued := s.in.take(s.batchSize)
+	if len(reqs) == 0 {
+		return false, nil
+	}
+	s.metrics.QueueDepth(queued)
+	return true, s.handleBatch(reqs)
+}
+
+// eventTarget returns the ID of the item or list changed by an event.
+func eventTarget(ev Event) ID {
+	switch ev := ev.(type) {
+	case *ItemAdded:
+		return ev.ID
+	case *ItemRemoved:
+		return ev.ID
+	case *ItemChanged:
+		return ev.ID
+	case *ItemMoved:
+		return ev.ID
+	case *ListAdded:
+		return ev.ID
+	case *ListRemoved:
+		return ev.ID
+	case *ListChanged:
+		return ev.ID
+	}
+	return ""
+}
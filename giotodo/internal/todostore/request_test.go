@@ -0,0 +1,145 @@
+package todostore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// waitResult reads events from sub until the result of req arrives.
+func waitResult(t *testing.T, sub *Subscription, req RequestID) *WriteResult {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		for _, ev := range sub.Events() {
+			if res, ok := ev.(*WriteResult); ok && res.Request == req {
+				return res
+			}
+		}
+		select {
+		case <-sub.Ready():
+		case <-timeout:
+			t.Fatalf("no result for request %d", req)
+		}
+	}
+}
+
+// breakWrites makes writes to the data file fail until the returned function is
+// called.
+func breakWrites(t *testing.T, s *Store) (restore func()) {
+	t.Helper()
+	var orig, ro *os.File
+	err := s.runInLoop(func() error {
+		if err := s.initFile(); err != nil {
+			return err
+		}
+		var err error
+		ro, err = os.Open(s.dataFile.Name())
+		orig, s.dataFile = s.dataFile, ro
+		if err != nil {
+			s.dataFile = orig
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		s.runInLoop(func() error {
+			s.dataFile = orig
+			return ro.Close()
+		})
+	}
+}
+
+func TestRequestResults(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+	sub := s.Subscribe(nil)
+	defer sub.Close()
+
+	ctx := context.Background()
+	id, err := s.AddItemContext(ctx, Item{Text: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := s.UpdateItem(id, Item{Text: "b"}, FieldText)
+	res := waitResult(t, sub, req)
+	if res.Target != id || res.Err != nil {
+		t.Fatalf("wrong result %+v", res)
+	}
+	if err := s.RemoveItemContext(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if snap := snapshotOf(t, s); len(snap.Items) != 0 {
+		t.Fatalf("item not removed: %+v", snap.Items)
+	}
+}
+
+func TestRequestFailure(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+	sub := s.Subscribe(nil)
+	defer sub.Close()
+
+	ctx := context.Background()
+	id, err := s.AddItemContext(ctx, Item{Text: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore := breakWrites(t, s)
+	if err := s.UpdateItemContext(ctx, id, Item{Text: "b"}, FieldText); err == nil {
+		t.Fatal("UpdateItemContext succeeded with broken file")
+	}
+	req := s.RemoveItem(id)
+	res := waitResult(t, sub, req)
+	if res.Target != id || res.Err == nil {
+		t.Fatalf("wrong result %+v", res)
+	}
+	if snap := snapshotOf(t, s); len(snap.Items) != 1 || snap.Items[0].Item.Text != "a" {
+		t.Fatalf("failed changes applied: %+v", snap.Items)
+	}
+
+	// Retrying works once the file is writable again.
+	restore()
+	retried := waitResult(t, sub, s.Retry(res))
+	if retried.Err != nil || retried.Target != id {
+		t.Fatalf("wrong result of retry %+v", retried)
+	}
+	if snap := snapshotOf(t, s); len(snap.Items) != 0 {
+		t.Fatalf("item not removed by retry: %+v", snap.Items)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	// Block mainLoop, so the request isn't handled before the deadline.
+	unblock := make(chan struct{})
+	blocked := make(chan struct{})
+	go s.runInLoop(func() error {
+		close(blocked)
+		<-unblock
+		return nil
+	})
+	<-blocked
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.AddItemContext(ctx, Item{Text: "a"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wrong error %v", err)
+	}
+	close(unblock)
+
+	// The item is still stored.
+	if snap := snapshotOf(t, s); len(snap.Items) != 1 {
+		t.Fatalf("item not stored: %+v", snap.Items)
+	}
+
+	s.Close()
+	if _, err := s.AddItemContext(context.Background(), Item{Text: "b"}); err != errStoreClosed {
+		t.Fatalf("wrong error after Close: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package todostore
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// formatVersion is the version of the data file format. It is stored in the header
+// record at the beginning of new files. Files without header are version 1, which
+// had no schema version on events. Version 3 added encrypted records.
+const formatVersion = 3
+
+// eventVersions holds the current schema version of all persisted event types.
+//
+// When the JSON encoding of an event changes incompatibly, its version must be
+// increased, and a migration from the previous version must be added to migrations.
+// Events of a higher version than listed here were written by a newer build. They
+// can't be decoded and are kept as UnknownEvent instead.
+var eventVersions = map[string]int{
+	"add":         1,
+	"remove":      1,
+	"change":      2,
+	"move":        1,
+	"list-add":    1,
+	"list-remove": 1,
+	"list-change": 1,
+	"list-order":  1,
+	"bulk":        1,
+}
+
+// A migration converts the JSON encoding of an event to the next schema version.
+type migration func(json.RawMessage) (json.RawMessage, error)
+
+// migrations holds the upgrade path of each event type, indexed by type and the
+// version it converts from.
+var migrations = map[string]map[int]migration{
+	"change": {
+		// Version 2 added the Fields list. Version 1 changes replaced the whole item,
+		// which is what an empty list means. The encoding is the same, but the version
+		// was increased because earlier builds would overwrite all fields.
+		1: func(data json.RawMessage) (json.RawMessage, error) { return data, nil },
+	},
+}
+
+// schema is a set of event versions and the migrations to them. Records are decoded
+// using currentSchema, tests may use others.
+type schema struct {
+	versions   map[string]int
+	migrations map[string]map[int]migration
+}
+
+var currentSchema = &schema{versions: eventVersions, migrations: migrations}
+
+// migrate upgrades an event from the given version to the schema version.
+func (sc *schema) migrate(evtype string, version int, data json.RawMessage) (json.RawMessage, error) {
+	current := sc.versions[evtype]
+	for v := version; v < current; v++ {
+		m := sc.migrations[evtype][v]
+		if m == nil {
+			return nil, fmt.Errorf("no migration for %q event from version %d", evtype, v)
+		}
+		var err error
+		if data, err = m(data); err != nil {
+			return nil, fmt.Errorf("can't migrate %q event from version %d: %v", evtype, v, err)
+		}
+	}
+	return data, nil
+}
+
+// fileHeader is the first record of a data file.
+type fileHeader struct {
+	Version int `json:"version"`
+
+	// Seqs holds the highest sequence number of each device. This is set
+	// by Compact, which may remove the last records of a device.
+	Seqs map[ID]uint64 `json:"seqs,omitempty"`
+
+	// Crypt is set when the records following the header are encrypted.
+	Crypt *cryptParams `json:"crypt,omitempty"`
+}
+
+func (*fileHeader) evType() string { return "header" }
+
+// UnknownEvent is an event that can't be decoded by this version of the store, because
+// it was written by a newer version. Unknown events are skipped during replay, but they
+// are preserved in the data file.
+type UnknownEvent struct {
+	Type    string
+	Version int
+	Data    json.RawMessage
+}
+
+func (ev *UnknownEvent) evType() string { return ev.Type }
@@ -0,0 +1,170 @@
+package todostore
+
+import "sort"
+
+// Snapshot is the content of the store at one point in time.
+type Snapshot struct {
+	Lists []ListInfo // in display order
+	Items []ItemInfo // grouped by list in display order, then by position
+
+	// Subtasks follow their parent in Items. The Parent of each item is its
+	// effective parent as computed by ResolveParents.
+}
+
+// ListInfo describes a list in a snapshot.
+type ListInfo struct {
+	ID   ID
+	Name string // empty for DefaultList
+}
+
+// ItemInfo describes an item in a snapshot.
+type ItemInfo struct {
+	ID   ID
+	Item Item
+	Pos  Pos
+}
+
+// Snapshot returns the current content of the store. Changes requested before the
+// call are included.
+func (s *Store) Snapshot() (*Snapshot, error) {
+	var snap *Snapshot
+	err := s.runInLoop(func() error {
+		var err error
+		snap, err = s.snapshot()
+		return err
+	})
+	return snap, err
+}
+
+// snapshot creates a snapshot on mainLoop.
+func (s *Store) snapshot() (*Snapshot, error) {
+	if err := s.initFile(); err != nil {
+		return nil, err
+	}
+	if err := s.handlePendingInput(); err != nil {
+		return nil, err
+	}
+	// Include changes made by other processes.
+	if _, err := s.readNew(); err != nil {
+		return nil, err
+	}
+	return s.state.snapshot(), nil
+}
+
+// handlePendingInput processes all events sent by the app so far.
+func (s *Store) handlePendingInput() error {
+	for {
+		more, err := s.handleQueued()
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+func (st *state) snapshot() *Snapshot {
+	snap := new(Snapshot)
+
+	// Lists are ordered like the app does it: DefaultList always exists, lists in the
+	// order come first, and the others are placed at the end in order of creation.
+	listIndex := make(map[ID]int)
+	addList := func(id ID, name string) {
+		if _, ok := listIndex[id]; !ok {
+			listIndex[id] = len(snap.Lists)
+			snap.Lists = append(snap.Lists, ListInfo{ID: id, Name: name})
+		}
+	}
+	for _, id := range st.order {
+		if id == DefaultList {
+			addList(id, "")
+		} else if ls := st.lists[id]; ls != nil && ls.alive() {
+			addList(id, ls.name)
+		}
+	}
+	addList(DefaultList, "")
+	var rest []ID
+	for id, ls := range st.lists {
+		if _, ok := listIndex[id]; !ok && id != DefaultList && ls.alive() {
+			rest = append(rest, id)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return st.lists[rest[i]].added.less(st.lists[rest[j]].added)
+	})
+	for _, id := range rest {
+		addList(id, st.lists[id].name)
+	}
+
+	// Items of removed lists are gone.
+	var added []stamp
+	for id, is := range st.items {
+		if _, ok := listIndex[is.item.List]; ok && is.alive() {
+			snap.Items = append(snap.Items, ItemInfo{ID: id, Item: is.item, Pos: is.pos})
+			added = append(added, is.added)
+		}
+	}
+	sort.Sort(&itemOrder{snap.Items, added, listIndex})
+
+	// Parents are resolved within each list, so that subtasks of removed items, or
+	// of items in another list, become top-level items.
+	parents := make(map[ID]map[ID]ID)
+	for _, info := range snap.Items {
+		if parents[info.Item.List] == nil {
+			parents[info.Item.List] = make(map[ID]ID)
+		}
+		parents[info.Item.List][info.ID] = info.Item.Parent
+	}
+	resolved := make(map[ID]map[ID]ID, len(parents))
+	for list, p := range parents {
+		resolved[list] = ResolveParents(p)
+	}
+	children := make(map[ID][]ItemInfo)
+	for i := range snap.Items {
+		info := &snap.Items[i]
+		info.Item.Parent = resolved[info.Item.List][info.ID]
+		if info.Item.Parent != "" {
+			children[info.Item.Parent] = append(children[info.Item.Parent], *info)
+		}
+	}
+
+	// Place subtasks behind their parent.
+	ordered := make([]ItemInfo, 0, len(snap.Items))
+	var add func(info ItemInfo)
+	add = func(info ItemInfo) {
+		ordered = append(ordered, info)
+		for _, child := range children[info.ID] {
+			add(child)
+		}
+	}
+	for _, info := range snap.Items {
+		if info.Item.Parent == "" {
+			add(info)
+		}
+	}
+	snap.Items = ordered
+	return snap
+}
+
+// itemOrder sorts items by list, position and creation.
+type itemOrder struct {
+	items     []ItemInfo
+	added     []stamp
+	listIndex map[ID]int
+}
+
+func (o *itemOrder) Len() int { return len(o.items) }
+
+func (o *itemOrder) Swap(i, j int) {
+	o.items[i], o.items[j] = o.items[j], o.items[i]
+	o.added[i], o.added[j] = o.added[j], o.added[i]
+}
+
+func (o *itemOrder) Less(i, j int) bool {
+	a, b := &o.items[i], &o.items[j]
+	if la, lb := o.listIndex[a.Item.List], o.listIndex[b.Item.List]; la != lb {
+		return la < lb
+	}
+	if a.Pos != b.Pos {
+		return a.Pos < b.Pos
+	}
+	return o.added[i].less(o.added[j])
+}
@@ -0,0 +1,342 @@
+package todostore
+
+import "sort"
+
+// state is the current content of the store, computed by applying all records.
+//
+// Records arrive in different order on different devices, so they can't simply be
+// applied one after the other. Instead, the state is a CRDT: every value is a
+// last-writer-wins register, holding the value written by the record with the
+// highest stamp. Since stamps are totally ordered, the result doesn't depend on the
+// order in which records are applied, and all devices end up with the same state
+// after seeing the same records.
+//
+//   - Each field of an item is a separate register, so concurrent changes of different
+//     fields are merged. ItemAdded writes all fields, ItemChanged only the fields it
+//     lists. The item position is another register. List names and the list order
+//     are registers as well.
+//   - An item exists when its latest ItemAdded stamp is higher than its latest
+//     ItemRemoved stamp. Removed items remain in the state as tombstones. Changes
+//     never bring back a removed item, so when an item is edited on one device and
+//     removed on another, the removal wins. Only a new ItemAdded, i.e. undoing the
+//     removal, restores it. Tombstones are never dropped because records created
+//     before the removal can still arrive from other devices.
+//   - Items are shown while they and their list exist. Removing a list hides its items
+//     without removing them, so restoring the list brings them back. Events are only
+//     emitted for shown items: ItemAdded when an item appears, ItemRemoved when it
+//     disappears, except when its list is removed, because ListRemoved implies it.
+//   - Applying a record twice has no effect, since a register is only written by
+//     records with a higher stamp.
+//   - A BulkChange record applies all its changes with its own stamp, as if they were
+//     separate records with the same stamp.
+type state struct {
+	items      map[ID]*itemState
+	lists      map[ID]*listState
+	order      []ID
+	orderStamp stamp
+	seen       map[ID]uint64 // highest sequence number by device
+	clock      hlc           // highest timestamp seen
+	ends       map[ID]Pos    // highest position of items in each list, see endPos
+}
+
+type itemState struct {
+	item     Item
+	pos      Pos
+	added    stamp
+	removed  stamp
+	stamps   map[Field]stamp // stamp of each field
+	posStamp stamp
+}
+
+type listState struct {
+	name      string
+	added     stamp
+	removed   stamp
+	nameStamp stamp
+}
+
+func newState() *state {
+	return &state{
+		items: make(map[ID]*itemState),
+		lists: make(map[ID]*listState),
+		seen:  make(map[ID]uint64),
+		ends:  make(map[ID]Pos),
+	}
+}
+
+func (st *itemState) alive() bool {
+	return st.removed.less(st.added)
+}
+
+func (st *listState) alive() bool {
+	return st.removed.less(st.added)
+}
+
+// isDuplicate reports whether the record was already applied. This relies on the
+// records of each device being received in sequence order, which the sync server
+// guarantees.
+func (st *state) isDuplicate(rec *record) bool {
+	return rec.Seq != 0 && rec.Seq <= st.seen[rec.Device]
+}
+
+// applyHeader applies the sequence numbers stored in a file header.
+func (st *state) applyHeader(h *fileHeader) {
+	for dev, seq := range h.Seqs {
+		if seq > st.seen[dev] {
+			st.seen[dev] = seq
+		}
+	}
+}
+
+// apply adds a record to the state. It returns the events that describe the
+// resulting change of the state. No events are returned when the record loses
+// against records applied earlier. Applying a record again has no effect.
+func (st *state) apply(rec *record) []Event {
+	if rec.Seq > st.seen[rec.Device] {
+		st.seen[rec.Device] = rec.Seq
+	}
+	if st.clock.less(rec.hlc) {
+		st.clock = rec.hlc
+	}
+
+	switch ev := rec.ev.(type) {
+	case *ItemAdded, *ItemRemoved, *ItemChanged, *ItemMoved:
+		return st.applyItem(rec)
+	case *ListAdded, *ListRemoved, *ListChanged:
+		return st.applyList(rec)
+	case *ListsReordered:
+		if st.orderStamp.less(rec.stamp) {
+			st.order, st.orderStamp = ev.Order, rec.stamp
+			return []Event{ev}
+		}
+	case *BulkChange:
+		return st.applyBulk(rec, ev)
+	}
+	return nil
+}
+
+func (st *state) applyItem(rec *record) []Event {
+	var id ID
+	switch ev := rec.ev.(type) {
+	case *ItemAdded:
+		id = ev.ID
+	case *ItemRemoved:
+		id = ev.ID
+	case *ItemChanged:
+		id = ev.ID
+	case *ItemMoved:
+		id = ev.ID
+	}
+	s := st.items[id]
+	if s == nil {
+		s = &itemState{stamps: make(map[Field]stamp, len(itemFields))}
+		st.items[id] = s
+	}
+	wasShown, oldItem, oldPos := st.shown(s), s.item, s.pos
+
+	switch ev := rec.ev.(type) {
+	case *ItemAdded:
+		if s.added.less(rec.stamp) {
+			s.added = rec.stamp
+		}
+		s.setFields(&ev.Item, itemFields, rec.stamp)
+		if ev.Pos != "" && s.posStamp.less(rec.stamp) {
+			s.pos, s.posStamp = ev.Pos, rec.stamp
+		}
+	case *ItemRemoved:
+		if s.removed.less(rec.stamp) {
+			s.removed = rec.stamp
+		}
+	case *ItemChanged:
+		fields := ev.Fields
+		if len(fields) == 0 {
+			fields = legacyFields
+		}
+		s.setFields(&ev.Item, fields, rec.stamp)
+	case *ItemMoved:
+		if s.posStamp.less(rec.stamp) {
+			s.pos, s.posStamp = ev.Pos, rec.stamp
+		}
+	}
+	if s.pos > st.ends[s.item.List] {
+		st.ends[s.item.List] = s.pos
+	}
+	return s.diff(id, wasShown, st.shown(s), oldItem, oldPos)
+}
+
+// shown reports whether an item is visible, i.e. whether it exists and its list exists.
+func (st *state) shown(s *itemState) bool {
+	return s.alive() && st.listAlive(s.item.List)
+}
+
+// listAlive reports whether a list exists.
+func (st *state) listAlive(id ID) bool {
+	if id == DefaultList {
+		return true
+	}
+	ls := st.lists[id]
+	return ls != nil && ls.alive()
+}
+
+// setFields writes the given fields of item when they are newer than the current values.
+func (s *itemState) setFields(item *Item, fields []Field, st stamp) {
+	for _, f := range fields {
+		if s.stamps[f].less(st) {
+			setField(&s.item, item, f)
+			s.stamps[f] = st
+		}
+	}
+}
+
+// diff creates the events which describe a change of the item.
+func (s *itemState) diff(id ID, wasShown, shown bool, oldItem Item, oldPos Pos) []Event {
+	switch {
+	case shown && !wasShown:
+		return []Event{&ItemAdded{ID: id, Item: s.item, Pos: s.pos}}
+	case !shown && wasShown:
+		return []Event{&ItemRemoved{ID: id}}
+	case shown:
+		var evs []Event
+		if !itemEqual(&oldItem, &s.item) {
+			evs = append(evs, &ItemChanged{ID: id, Item: s.item})
+		}
+		if s.pos != oldPos {
+			evs = append(evs, &ItemMoved{ID: id, Pos: s.pos})
+		}
+		return evs
+	}
+	return nil
+}
+
+func (st *state) applyList(rec *record) []Event {
+	var id ID
+	switch ev := rec.ev.(type) {
+	case *ListAdded:
+		id = ev.ID
+	case *ListRemoved:
+		id = ev.ID
+	case *ListChanged:
+		id = ev.ID
+	}
+	s := st.lists[id]
+	if s == nil {
+		s = new(listState)
+		st.lists[id] = s
+	}
+	wasAlive, oldName := s.alive(), s.name
+
+	switch ev := rec.ev.(type) {
+	case *ListAdded:
+		if s.added.less(rec.stamp) {
+			s.added = rec.stamp
+		}
+		if s.nameStamp.less(rec.stamp) {
+			s.name, s.nameStamp = ev.Name, rec.stamp
+		}
+	case *ListRemoved:
+		if s.removed.less(rec.stamp) {
+			s.removed = rec.stamp
+		}
+	case *ListChanged:
+		if s.nameStamp.less(rec.stamp) {
+			s.name, s.nameStamp = ev.Name, rec.stamp
+		}
+	}
+
+	switch alive := s.alive(); {
+	case alive && !wasAlive:
+		evs := []Event{&ListAdded{ID: id, Name: s.name}}
+		if st.order != nil {
+			evs = append(evs, &ListsReordered{Order: st.order})
+		}
+		return append(evs, st.listItems(id)...)
+	case !alive && wasAlive:
+		return []Event{&ListRemoved{ID: id}}
+	case alive && s.name != oldName:
+		return []Event{&ListChanged{ID: id, Name: s.name}}
+	}
+	return nil
+}
+
+// listItems creates ItemAdded events for the items of a list, ordered by position.
+// They are sent when a removed list is restored.
+func (st *state) listItems(list ID) []Event {
+	var evs []*ItemAdded
+	for id, s := range st.items {
+		if s.alive() && s.item.List == list {
+			evs = append(evs, &ItemAdded{ID: id, Item: s.item, Pos: s.pos})
+		}
+	}
+	sort.Slice(evs, func(i, j int) bool {
+		if evs[i].Pos != evs[j].Pos {
+			return evs[i].Pos < evs[j].Pos
+		}
+		return evs[i].ID < evs[j].ID
+	})
+	result := make([]Event, len(evs))
+	for i, ev := range evs {
+		result[i] = ev
+	}
+	return result
+}
+
+// endPos returns a position after all items of the given list. To avoid scanning all
+// items, it is computed from the highest position any item of the list ever had,
+// which may belong to an item that was removed or moved since.
+func (st *state) endPos(list ID) Pos {
+	return PosAfter(st.ends[list])
+}
+
+// changedFields returns the fields of an item which differ from the given values.
+// All fields are returned when the item doesn't exist.
+func (st *state) changedFields(id ID, item *Item) []Field {
+	s := st.items[id]
+	if s == nil || !s.alive() {
+		return itemFields
+	}
+	return ChangedFields(&s.item, item)
+}
+
+// affects reports whether the record is needed to compute the state, i.e. whether
+// it holds the current value of a register. For removed items and lists, only the
+// record of the latest removal is needed. Unknown records are always kept.
+func (st *state) affects(rec *record) bool {
+	switch ev := rec.ev.(type) {
+	case *ItemAdded:
+		s := st.items[ev.ID]
+		return s.alive() && (s.added == rec.stamp || s.posStamp == rec.stamp || s.hasField(rec.stamp))
+	case *ItemChanged:
+		s := st.items[ev.ID]
+		return s.alive() && s.hasField(rec.stamp)
+	case *ItemMoved:
+		s := st.items[ev.ID]
+		return s.alive() && s.posStamp == rec.stamp
+	case *ItemRemoved:
+		s := st.items[ev.ID]
+		return !s.alive() && s.removed == rec.stamp
+	case *ListAdded:
+		s := st.lists[ev.ID]
+		return s.alive() && (s.added == rec.stamp || s.nameStamp == rec.stamp)
+	case *ListChanged:
+		s := st.lists[ev.ID]
+		return s.alive() && s.nameStamp == rec.stamp
+	case *ListRemoved:
+		s := st.lists[ev.ID]
+		return !s.alive() && s.removed == rec.stamp
+	case *ListsReordered:
+		return st.orderStamp == rec.stamp
+	case *BulkChange:
+		return st.affectsBulk(rec, ev)
+	}
+	return true
+}
+
+// hasField reports whether any field of the item was set by the record with the given stamp.
+func (s *itemState) hasField(st stamp) bool {
+	for _, fs := range s.stamps {
+		if fs == st {
+			return true
+		}
+	}
+	return false
+}
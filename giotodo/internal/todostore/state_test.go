@@ -0,0 +1,360 @@
+package todostore
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// This checks that concurrent changes of different fields are merged.
+func TestStateMergeFields(t *testing.T) {
+	add := &record{stamp{"a", 1, hlc{Time: 100}}, &ItemAdded{ID: "i", Item: Item{Text: "milk"}, Pos: "V"}}
+	done := &record{stamp{"a", 2, hlc{Time: 200}}, &ItemChanged{ID: "i", Item: Item{Done: true}, Fields: []Field{FieldDone}}}
+	text := &record{stamp{"b", 1, hlc{Time: 150}}, &ItemChanged{ID: "i", Item: Item{Text: "oat milk"}, Fields: []Field{FieldText}}}
+
+	for _, order := range [][]*record{{add, done, text}, {add, text, done}, {text, done, add}} {
+		st := newState()
+		for _, rec := range order {
+			st.apply(rec)
+		}
+		want := Item{Text: "oat milk", Done: true}
+		if got := st.items["i"].item; !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong item %+v, want %+v", got, want)
+		}
+	}
+}
+
+// This checks that a removal wins against a later edit, but not against a later add.
+func TestStateTombstone(t *testing.T) {
+	st := newState()
+	st.apply(&record{stamp{"a", 1, hlc{Time: 100}}, &ItemAdded{ID: "i", Item: Item{Text: "x"}}})
+	st.apply(&record{stamp{"a", 2, hlc{Time: 200}}, &ItemRemoved{ID: "i"}})
+	evs := st.apply(&record{stamp{"b", 1, hlc{Time: 300}}, &ItemChanged{ID: "i", Item: Item{Text: "y"}}})
+	if len(evs) != 0 || st.items["i"].alive() {
+		t.Fatalf("edit after remove restored the item, events: %v", evs)
+	}
+	evs = st.apply(&record{stamp{"a", 3, hlc{Time: 250}}, &ItemAdded{ID: "i", Item: Item{Text: "z"}}})
+	want := []Event{&ItemAdded{ID: "i", Item: Item{Text: "y"}}}
+	if !reflect.DeepEqual(evs, want) {
+		t.Fatalf("wrong events after re-add:\n got %v\nwant %v", evs, want)
+	}
+}
+
+func TestHLC(t *testing.T) {
+	var c hlc
+	c = c.next(100)
+	if c != (hlc{Time: 100}) {
+		t.Fatalf("wrong clock %v", c)
+	}
+	// When the wall clock goes backwards, the counter is incremented.
+	c = c.next(90)
+	if c != (hlc{Time: 100, Logical: 1}) {
+		t.Fatalf("wrong clock %v", c)
+	}
+	c = c.next(100)
+	if c != (hlc{Time: 100, Logical: 2}) {
+		t.Fatalf("wrong clock %v", c)
+	}
+	c = c.next(101)
+	if c != (hlc{Time: 101}) {
+		t.Fatalf("wrong clock %v", c)
+	}
+}
+
+// This checks that all orders of applying the same records create the same state.
+func TestStateConvergence(t *testing.T) {
+	check := func(h *history) bool {
+		want := viewOf(h.records)
+		for i := 0; i < 10; i++ {
+			recs := h.shuffled()
+			if got := viewOf(recs); !reflect.DeepEqual(got, want) {
+				t.Logf("different state for shuffled records:\n got %+v\nwant %+v", got, want)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// This checks that merging two logs in either order creates the same state, and
+// that the events returned by apply describe the state.
+func TestStateMergeLogs(t *testing.T) {
+	check := func(a, b *history) bool {
+		// Both histories share the device names, so rename the devices of b.
+		b.renameDevices("x")
+		ab := append(append([]*record{}, a.records...), b.records...)
+		ba := append(append([]*record{}, b.records...), a.records...)
+		if !reflect.DeepEqual(viewOf(ab), viewOf(ba)) {
+			return false
+		}
+		st := newState()
+		v := newView()
+		for _, rec := range ab {
+			v.apply(st.apply(rec))
+		}
+		return reflect.DeepEqual(v, st.view())
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// This checks that devices which sync with each other agree on the state.
+func TestStateSync(t *testing.T) {
+	check := func(h *history) bool {
+		want := viewOf(h.records)
+		for _, dev := range h.devices {
+			for _, rec := range h.records {
+				dev.state.apply(rec)
+			}
+			if !reflect.DeepEqual(dev.state.view(), want) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// history is a random set of records created by several devices,
+// which occasionally sync with each other.
+type history struct {
+	devices []*simDevice
+	records []*record
+	rand    *rand.Rand
+}
+
+type simDevice struct {
+	id    ID
+	seq   uint64
+	skew  int64 // clock offset
+	state *state
+	log   []*record
+}
+
+var (
+	simItems = []ID{"i1", "i2", "i3", "i4"}
+	simLists = []ID{"l1", "l2"}
+)
+
+// Generate implements quick.Generator.
+func (*history) Generate(r *rand.Rand, size int) reflect.Value {
+	h := &history{rand: r}
+	for i := 0; i < 3; i++ {
+		dev := &simDevice{id: ID(fmt.Sprint("d", i)), skew: r.Int63n(50) - 25, state: newState()}
+		h.devices = append(h.devices, dev)
+	}
+	var now int64 = 1000
+	for i := 0; i < size*3; i++ {
+		now += r.Int63n(3) // often the same time on different devices
+		dev := h.devices[r.Intn(len(h.devices))]
+		if r.Intn(6) == 0 {
+			// Sync with another device.
+			other := h.devices[r.Intn(len(h.devices))]
+			for _, rec := range other.log {
+				if !dev.state.isDuplicate(rec) {
+					dev.state.apply(rec)
+					dev.log = append(dev.log, rec)
+				}
+			}
+			continue
+		}
+		dev.seq++
+		rec := &record{
+			stamp: stamp{Device: dev.id, Seq: dev.seq, hlc: dev.state.clock.next(now + dev.skew)},
+			ev:    randomEvent(r),
+		}
+		dev.state.apply(rec)
+		dev.log = append(dev.log, rec)
+		h.records = append(h.records, rec)
+	}
+	return reflect.ValueOf(h)
+}
+
+func randomEvent(r *rand.Rand) Event {
+	item := simItems[r.Intn(len(simItems))]
+	switch r.Intn(8) {
+	case 0, 1:
+		return &ItemAdded{ID: item, Item: randomItem(r), Pos: randomPos(r)}
+	case 2:
+		return &ItemRemoved{ID: item}
+	case 3, 4:
+		ev := &ItemChanged{ID: item, Item: randomItem(r)}
+		for _, f := range itemFields {
+			if r.Intn(3) == 0 {
+				ev.Fields = append(ev.Fields, f)
+			}
+		}
+		return ev
+	case 5:
+		return &ItemMoved{ID: item, Pos: randomPos(r)}
+	case 6:
+		list := simLists[r.Intn(len(simLists))]
+		switch r.Intn(3) {
+		case 0:
+			return &ListAdded{ID: list, Name: fmt.Sprint("list", r.Intn(10))}
+		case 1:
+			return &ListRemoved{ID: list}
+		default:
+			return &ListChanged{ID: list, Name: fmt.Sprint("list", r.Intn(10))}
+		}
+	default:
+		order := []ID{DefaultList}
+		for _, i := range r.Perm(len(simLists)) {
+			order = append(order, simLists[i])
+		}
+		return &ListsReordered{Order: order}
+	}
+}
+
+func randomItem(r *rand.Rand) Item {
+	it := Item{
+		Text:     fmt.Sprint("text", r.Intn(10)),
+		Done:     r.Intn(2) == 0,
+		Priority: Priority(r.Intn(4)),
+	}
+	if r.Intn(2) == 0 {
+		it.List = simLists[r.Intn(len(simLists))]
+	}
+	if r.Intn(3) == 0 {
+		due := time.Date(2026, 1, 1+r.Intn(30), 0, 0, 0, 0, time.UTC)
+		it.Due = &due
+	}
+	if r.Intn(3) == 0 {
+		it.Tags = []string{fmt.Sprint("tag", r.Intn(3))}
+	}
+	if r.Intn(3) == 0 {
+		it.Notes = fmt.Sprint("notes", r.Intn(3))
+	}
+	if r.Intn(2) == 0 {
+		it.Parent = simItems[r.Intn(len(simItems))]
+	}
+	return it
+}
+
+func randomPos(r *rand.Rand) Pos {
+	return Pos(fmt.Sprint(r.Intn(100)))
+}
+
+// shuffled returns the records in random order, with some duplicates.
+func (h *history) shuffled() []*record {
+	recs := make([]*record, 0, len(h.records)*2)
+	for _, i := range h.rand.Perm(len(h.records)) {
+		recs = append(recs, h.records[i])
+		if h.rand.Intn(4) == 0 {
+			j := h.rand.Intn(len(h.records))
+			recs = append(recs, h.records[j])
+		}
+	}
+	return recs
+}
+
+func (h *history) renameDevices(prefix string) {
+	for _, rec := range h.records {
+		rec.Device = ID(prefix) + rec.Device
+	}
+}
+
+// stateView is the visible content of a state.
+type stateView struct {
+	Items map[ID]viewItem
+	Lists map[ID]string
+	Order []ID
+}
+
+type viewItem struct {
+	Item Item
+	Pos  Pos
+}
+
+func newView() *stateView {
+	return &stateView{Items: make(map[ID]viewItem), Lists: make(map[ID]string)}
+}
+
+func viewOf(recs []*record) *stateView {
+	st := newState()
+	for _, rec := range recs {
+		st.apply(rec)
+	}
+	return st.view()
+}
+
+func (st *state) view() *stateView {
+	s := newView()
+	for id, is := range st.items {
+		if st.shown(is) {
+			s.Items[id] = viewItem{is.item, is.pos}
+		}
+	}
+	for id, ls := range st.lists {
+		if ls.alive() {
+			s.Lists[id] = ls.name
+		}
+	}
+	s.Order = st.order
+	return s
+}
+
+// apply updates the view with events. It follows todoLists.handleStoreEvent in the
+// app: events about unknown items and lists are ignored, items changed to an unknown
+// list are dropped, and removing a list drops its items. So the view only matches
+// the state when the events are enough for the app to show the right items.
+func (s *stateView) apply(evs []Event) {
+	known := func(list ID) bool {
+		_, ok := s.Lists[list]
+		return ok || list == DefaultList
+	}
+	for _, ev := range evs {
+		switch ev := ev.(type) {
+		case *ItemAdded:
+			if known(ev.Item.List) {
+				s.Items[ev.ID] = viewItem{ev.Item, ev.Pos}
+			}
+		case *ItemRemoved:
+			delete(s.Items, ev.ID)
+		case *ItemChanged:
+			it, ok := s.Items[ev.ID]
+			switch {
+			case !ok:
+			case !known(ev.Item.List):
+				delete(s.Items, ev.ID)
+			default:
+				it.Item = ev.Item
+				s.Items[ev.ID] = it
+			}
+		case *ItemMoved:
+			if it, ok := s.Items[ev.ID]; ok {
+				it.Pos = ev.Pos
+				s.Items[ev.ID] = it
+			}
+		case *ListAdded:
+			if !known(ev.ID) {
+				s.Lists[ev.ID] = ev.Name
+			}
+		case *ListChanged:
+			if _, ok := s.Lists[ev.ID]; ok {
+				s.Lists[ev.ID] = ev.Name
+			}
+		case *ListRemoved:
+			if ev.ID == DefaultList {
+				break
+			}
+			delete(s.Lists, ev.ID)
+			for id, it := range s.Items {
+				if it.Item.List == ev.ID {
+					delete(s.Items, id)
+				}
+			}
+		case *ListsReordered:
+			s.Order = ev.Order
+		}
+	}
+}
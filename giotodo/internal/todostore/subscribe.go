@@ -0,0 +1,168 @@
+package todostore
+
+import "sync"
+
+// Output events are kept in a buffer shared by all subscriptions. Each subscription
+// has a cursor, which is the sequence number of the next event it reads. Events are
+// removed from the buffer when all subscriptions have read them. Without any
+// subscriptions, events are dropped.
+//
+// After adding events, every subscription is woken: its channel receives a value
+// and its callback is called. The channel has a buffer of one element, and sending
+// never blocks. A subscriber which calls Events after receiving from the channel
+// therefore always sees all events added before the wakeup, and a wakeup that
+// happens while the subscriber is busy is not lost.
+
+type eventBuffer struct {
+	mu     sync.Mutex
+	events []Event
+	base   uint64 // sequence number of events[0]
+	subs   map[*Subscription]struct{}
+}
+
+// Subscription delivers the output events of a store. Subscriptions are created by
+// Store.Subscribe and Store.SubscribeSnapshot.
+type Subscription struct {
+	buf    *eventBuffer
+	cursor uint64 // guarded by buf.mu
+	ready  chan struct{}
+	wake   func()
+}
+
+// Subscribe creates a subscription which receives all events emitted after the
+// call. The wake callback is optional. When non-nil, it is called after new events
+// become available. It must not block.
+func (s *Store) Subscribe(wake func()) *Subscription {
+	return s.out.subscribe(wake)
+}
+
+// SubscribeSnapshot creates a subscription together with a snapshot of the store.
+// The subscription receives all events emitted after the snapshot was taken, so
+// applying its events to the snapshot always gives the current content. See
+// Subscribe for the meaning of wake.
+func (s *Store) SubscribeSnapshot(wake func()) (*Subscription, *Snapshot, error) {
+	var (
+		sub  *Subscription
+		snap *Snapshot
+	)
+	err := s.runInLoop(func() error {
+		var err error
+		// Only mainLoop emits events about the state, so none can be emitted
+		// between taking the snapshot and creating the subscription.
+		if snap, err = s.snapshot(); err != nil {
+			return err
+		}
+		sub = s.out.subscribe(wake)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sub, snap, nil
+}
+
+func (b *eventBuffer) subscribe(wake func()) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &Subscription{
+		buf:    b,
+		cursor: b.base + uint64(len(b.events)),
+		ready:  make(chan struct{}, 1),
+		wake:   wake,
+	}
+	if b.subs == nil {
+		b.subs = make(map[*Subscription]struct{})
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// add appends an event and wakes all subscriptions. It returns false when the event
+// was dropped because there are no subscriptions.
+func (b *eventBuffer) add(ev Event) bool {
+	b.mu.Lock()
+	if len(b.subs) == 0 {
+		b.base++
+		b.mu.Unlock()
+		return false
+	}
+	b.events = append(b.events, ev)
+	b.mu.Unlock()
+	b.wakeAll()
+	return true
+}
+
+// wakeAll wakes all subscriptions, even when there are no new events.
+func (b *eventBuffer) wakeAll() {
+	b.mu.Lock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for sub := range b.subs {
+		subs = append(subs, sub)
+	}
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		select {
+		case sub.ready <- struct{}{}:
+		default:
+		}
+		if sub.wake != nil {
+			sub.wake()
+		}
+	}
+}
+
+// trim removes events which all subscriptions have read. b.mu must be held.
+func (b *eventBuffer) trim() {
+	oldest := b.base + uint64(len(b.events))
+	for sub := range b.subs {
+		if sub.cursor < oldest {
+			oldest = sub.cursor
+		}
+	}
+	n := int(oldest - b.base)
+	if n == 0 {
+		return
+	}
+	// Move the remaining events to the front, so the array can be reused.
+	rest := copy(b.events, b.events[n:])
+	for i := rest; i < len(b.events); i++ {
+		b.events[i] = nil
+	}
+	b.events = b.events[:rest]
+	b.base = oldest
+}
+
+// Events returns the events which were emitted since the last call.
+func (sub *Subscription) Events() []Event {
+	b := sub.buf
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; !ok {
+		return nil // closed
+	}
+	start := int(sub.cursor - b.base)
+	if start == len(b.events) {
+		return nil
+	}
+	evs := make([]Event, len(b.events)-start)
+	copy(evs, b.events[start:])
+	sub.cursor = b.base + uint64(len(b.events))
+	b.trim()
+	return evs
+}
+
+// Ready returns a channel which receives a value when new events are available.
+func (sub *Subscription) Ready() <-chan struct{} {
+	return sub.ready
+}
+
+// Close ends the subscription. Events which it didn't read are released.
+func (sub *Subscription) Close() {
+	b := sub.buf
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, sub)
+	b.trim()
+}
@@ -0,0 +1,198 @@
+package todostore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// collect reads events from sub until n items were added. It waits only on the
+// Ready channel, so a missed wakeup makes it time out.
+func collect(sub *Subscription, n int) ([]ID, error) {
+	var ids []ID
+	timeout := time.After(10 * time.Second)
+	for len(ids) < n {
+		select {
+		case <-sub.Ready():
+			for _, ev := range sub.Events() {
+				if ev, ok := ev.(*ItemAdded); ok {
+					ids = append(ids, ev.ID)
+				}
+			}
+		case <-timeout:
+			return ids, fmt.Errorf("timeout after %d of %d items", len(ids), n)
+		}
+	}
+	return ids, nil
+}
+
+// buffered returns the number of events in the buffer of s.
+func buffered(s *Store) int {
+	s.out.mu.Lock()
+	defer s.out.mu.Unlock()
+	return len(s.out.events)
+}
+
+// This checks that every subscription receives all events, in order, no matter
+// how fast it reads them.
+func TestSubscribeMultiple(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+	snapshotOf(t, s)
+
+	const n = 200
+	var (
+		subs    = make([]*Subscription, 3)
+		results = make([]chan []ID, len(subs))
+		errs    = make(chan error, len(subs))
+	)
+	for i := range subs {
+		subs[i] = s.Subscribe(nil)
+		defer subs[i].Close()
+		results[i] = make(chan []ID, 1)
+	}
+	for i := range subs {
+		sub, result := subs[i], results[i]
+		go func() {
+			ids, err := collect(sub, n)
+			errs <- err
+			result <- ids
+		}()
+	}
+
+	want := make([]ID, n)
+	for i := range want {
+		want[i] = s.AddItem(Item{Text: fmt.Sprint(i)})
+	}
+	for i, result := range results {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		got := <-result
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("subscription %d: wrong events\ngot:  %v\nwant: %v", i, got, want)
+		}
+	}
+}
+
+// This checks that no item is lost or duplicated between the snapshot and the
+// events which follow it.
+func TestSubscribeSnapshot(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+
+	const n = 500
+	ids := make(chan ID, n)
+	go func() {
+		for i := 0; i < n; i++ {
+			ids <- s.AddItem(Item{Text: fmt.Sprint(i)})
+		}
+		close(ids)
+	}()
+
+	time.Sleep(time.Millisecond)
+	sub, snap, err := s.SubscribeSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	seen := make(map[ID]int)
+	for _, info := range snap.Items {
+		seen[info.ID]++
+	}
+	added, err := collect(sub, n-len(snap.Items))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range added {
+		seen[id]++
+	}
+	for id := range ids {
+		if seen[id] != 1 {
+			t.Errorf("item %s seen %d times", id, seen[id])
+		}
+	}
+	if len(seen) != n {
+		t.Errorf("got %d items, want %d", len(seen), n)
+	}
+}
+
+func TestSubscribeWake(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+
+	woken := make(chan struct{}, 1)
+	sub := s.Subscribe(func() {
+		select {
+		case woken <- struct{}{}:
+		default:
+		}
+	})
+	defer sub.Close()
+
+	for i := 0; i < 10; i++ {
+		s.AddItem(Item{Text: fmt.Sprint(i)})
+		select {
+		case <-woken:
+		case <-time.After(5 * time.Second):
+			t.Fatal("wake callback not called")
+		}
+		// Events may arrive before the wakeup of an earlier event, so some calls
+		// find nothing new. The new item must have been delivered after a wakeup
+		// though.
+		for len(sub.Events()) == 0 {
+			select {
+			case <-woken:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no events after wakeup")
+			}
+		}
+	}
+}
+
+// This checks that events are released once all subscriptions have read them.
+func TestSubscribeTrim(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+	snapshotOf(t, s)
+
+	// Without subscriptions, nothing is buffered.
+	s.AddItem(Item{Text: "a"})
+	snapshotOf(t, s)
+	if buffered(s) != 0 {
+		t.Fatalf("%d events buffered without subscriptions", buffered(s))
+	}
+
+	sub1 := s.Subscribe(nil)
+	sub2 := s.Subscribe(nil)
+	s.AddItem(Item{Text: "b"})
+	s.AddItem(Item{Text: "c"})
+	snapshotOf(t, s)
+	// Every change emits the item event and its WriteResult.
+	if evs := sub1.Events(); len(evs) != 4 {
+		t.Fatalf("sub1 got %d events, want 4", len(evs))
+	}
+	if buffered(s) != 4 {
+		t.Fatalf("%d events buffered, want 4 for sub2", buffered(s))
+	}
+
+	s.AddItem(Item{Text: "d"})
+	snapshotOf(t, s)
+	if evs := sub2.Events(); len(evs) != 6 {
+		t.Fatalf("sub2 got %d events, want 6", len(evs))
+	}
+	if buffered(s) != 2 {
+		t.Fatalf("%d events buffered, want 2 for sub1", buffered(s))
+	}
+
+	// Closing releases the events of sub1.
+	sub1.Close()
+	if buffered(s) != 0 {
+		t.Fatalf("%d events buffered after close", buffered(s))
+	}
+	if evs := sub1.Events(); evs != nil {
+		t.Fatalf("closed subscription returned events: %v", evs)
+	}
+	sub2.Close()
+}
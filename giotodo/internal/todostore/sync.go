@@ -0,0 +1,307 @@
+package todostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// The sync protocol exchanges records with a server over HTTP. Records are encoded
+// as in the data file. Every device numbers its records, and the server stores them
+// in the order they arrive.
+//
+//	GET /events?after=N    returns records stored after position N
+//	POST /events           stores records of the sending device
+//
+// Both requests respond with the highest sequence number stored for each device,
+// which tells the client which of its records still need to be sent. Since records
+// are resolved using their stamps (see state), the order in which devices receive
+// them does not matter.
+//
+// Encrypted stores don't sync. Their records are encrypted with a key derived from
+// the passphrase and a random salt, so other devices can't read them even when they
+// use the same passphrase, and the server needs the stamps of records. Sending the
+// records decrypted would reveal them to the server, so sync fails with
+// ErrSyncEncrypted instead.
+
+// syncBatchSize is the maximum number of records in a single push.
+const syncBatchSize = 500
+
+// ErrSyncEncrypted is the error of syncs of an encrypted store.
+var ErrSyncEncrypted = errors.New("encrypted stores can't be synced")
+
+// PullResponse is the response to GET /events.
+type PullResponse struct {
+	Events  []json.RawMessage `json:"events"`
+	Next    uint64            `json:"next"`
+	More    bool              `json:"more"`
+	Devices map[ID]uint64     `json:"devices"`
+}
+
+// PushRequest is the body of POST /events.
+type PushRequest struct {
+	Events []json.RawMessage `json:"events"`
+}
+
+// PushResponse is the response to POST /events.
+type PushResponse struct {
+	Devices map[ID]uint64 `json:"devices"`
+}
+
+// StartSync starts synchronizing the store with the server at url. Syncing happens in
+// the background, whenever the store is changed and at the given interval. The result
+// of each sync is reported as a *SyncStatus event.
+func (s *Store) StartSync(url string, interval time.Duration) {
+	s.wg.Add(1)
+	go s.syncLoop(url, interval)
+}
+
+// SyncNow triggers a sync with the server.
+func (s *Store) SyncNow() {
+	s.triggerSync()
+}
+
+func (s *Store) triggerSync() {
+	select {
+	case s.syncNow <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Store) syncLoop(url string, interval time.Duration) {
+	defer s.wg.Done()
+
+	var (
+		client = &syncClient{url: url, http: &http.Client{Timeout: 30 * time.Second}}
+		timer  = time.NewTimer(0)
+		cursor uint64
+	)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-s.syncNow:
+		case <-s.quitCh:
+			return
+		}
+
+		status := s.sync(client, &cursor)
+		if status.Err == errStoreClosed {
+			return
+		}
+		s.enqueueOutputEvent(status)
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(interval)
+	}
+}
+
+// sync performs one round of pulling and pushing records.
+func (s *Store) sync(client *syncClient, cursor *uint64) *SyncStatus {
+	status := &SyncStatus{}
+	status.Err = s.runInLoop(s.checkSync)
+	if status.Err == nil {
+		status.Err = s.pull(client, cursor, status)
+	}
+	if status.Err == nil {
+		status.Err = s.push(client, status)
+	}
+	status.Time = time.Now()
+	return status
+}
+
+func (s *Store) pull(client *syncClient, cursor *uint64, status *SyncStatus) error {
+	for {
+		ctx, cancel := s.quitContext()
+		resp, err := client.pull(ctx, *cursor)
+		cancel()
+		if err != nil {
+			return err
+		}
+		recs := make([]*record, 0, len(resp.Events))
+		for _, data := range resp.Events {
+			rec, err := readRecord(json.NewDecoder(bytes.NewReader(data)))
+			if err != nil {
+				return fmt.Errorf("invalid event from server: %v", err)
+			}
+			if _, ok := rec.ev.(*fileHeader); ok || rec.Device == "" || rec.Seq == 0 {
+				return fmt.Errorf("invalid event from server: missing stamp")
+			}
+			recs = append(recs, rec)
+		}
+		err = s.runInLoop(func() error {
+			s.updateAcked(resp.Devices)
+			return s.handleRemoteRecords(recs)
+		})
+		if err != nil {
+			return err
+		}
+		status.Pulled += len(recs)
+		*cursor = resp.Next
+		if !resp.More {
+			return nil
+		}
+	}
+}
+
+func (s *Store) push(client *syncClient, status *SyncStatus) error {
+	for {
+		var batch []json.RawMessage
+		err := s.runInLoop(func() (err error) {
+			batch, err = s.pendingRecords(syncBatchSize)
+			return err
+		})
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		ctx, cancel := s.quitContext()
+		resp, err := client.push(ctx, batch)
+		cancel()
+		if err != nil {
+			return err
+		}
+		var progress bool
+		err = s.runInLoop(func() error {
+			progress = s.updateAcked(resp.Devices)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !progress {
+			return fmt.Errorf("server did not accept events")
+		}
+		status.Pushed += len(batch)
+	}
+}
+
+// quitContext returns a context that is canceled when the store is closed.
+func (s *Store) quitContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-s.quitCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// updateAcked records the sequence number of this device known to the server.
+// It reports whether the number has increased. This runs on mainLoop.
+func (s *Store) updateAcked(devices map[ID]uint64) bool {
+	if seq := devices[s.device]; seq > s.acked {
+		s.acked = seq
+		return true
+	}
+	return false
+}
+
+// checkSync returns an error if the store can't be synced. This runs on mainLoop.
+func (s *Store) checkSync() error {
+	if s.key != nil {
+		return ErrSyncEncrypted
+	}
+	return nil
+}
+
+// pendingRecords returns local records which are not yet stored on the server.
+// This runs on mainLoop.
+func (s *Store) pendingRecords(limit int) ([]json.RawMessage, error) {
+	if err := s.initFile(); err != nil {
+		return nil, err
+	}
+	// Check again, the store may have been encrypted during the sync.
+	if err := s.checkSync(); err != nil {
+		return nil, err
+	}
+	if s.seq <= s.acked {
+		return nil, nil
+	}
+
+	// Read the data file from the beginning to find them.
+	f, err := os.Open(filepath.Join(s.dataDir, "events.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		batch []json.RawMessage
+		buf   bytes.Buffer
+		enc   = json.NewEncoder(&buf)
+	)
+	err = scanRecords(json.NewDecoder(f), s.key, s.device, new(uint64), func(rec *record) bool {
+		if rec.Device != s.device || rec.Seq <= s.acked {
+			return true
+		}
+		if _, ok := rec.ev.(*fileHeader); ok {
+			return true
+		}
+		buf.Reset()
+		if err := writeRecord(enc, rec); err != nil {
+			return false
+		}
+		batch = append(batch, json.RawMessage(bytes.TrimSpace(bytes.Clone(buf.Bytes()))))
+		return len(batch) < limit
+	})
+	return batch, err
+}
+
+// syncClient performs sync protocol requests.
+type syncClient struct {
+	url  string
+	http *http.Client
+}
+
+func (c *syncClient) pull(ctx context.Context, after uint64) (*PullResponse, error) {
+	u := c.url + "/events?after=" + url.QueryEscape(strconv.FormatUint(after, 10))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp PullResponse
+	return &resp, c.do(req, &resp)
+}
+
+func (c *syncClient) push(ctx context.Context, events []json.RawMessage) (*PushResponse, error) {
+	body, err := json.Marshal(&PushRequest{Events: events})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url+"/events", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+	var resp PushResponse
+	return &resp, c.do(req, &resp)
+}
+
+func (c *syncClient) do(req *http.Request, result any) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sync server: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -1,20 +1,28 @@
 package todostore
 
 import (
-	"container/list"
+	"bytes"
 	crand "crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
 type ID string
 
+// NewID creates a random ID. Apps use it with RestoreItem to know the ID of an item
+// before it is stored.
+func NewID() ID {
+	return randomID()
+}
+
 func randomID() ID {
 	s := make([]byte, 16)
 	if _, err := crand.Read(s); err != nil {
@@ -23,35 +31,178 @@ func randomID() ID {
 	return ID(hex.EncodeToString(s))
 }
 
+// DefaultList is the ID of the list that exists without being created.
+// Items which don't specify a list belong to it.
+const DefaultList ID = ""
+
 // Item is a todo item.
 type Item struct {
 	Text string
 	Done bool
+	List ID `json:",omitempty"`
+
+	// Parent is the item of which this item is a subtask. It is empty for top-level
+	// items. See ResolveParents for how invalid parents are handled.
+	Parent ID `json:",omitempty"`
+
+	// Optional attributes.
+	Due      *time.Time `json:",omitempty"`
+	Priority Priority   `json:",omitempty"`
+	Tags     []string   `json:",omitempty"`
+	Notes    string     `json:",omitempty"`
+
+	// Repeat is the recurrence rule of the item, in the RRULE syntax of package
+	// recur. When a recurring item with due date is completed, the store adds its
+	// next occurrence.
+	Repeat string `json:",omitempty"`
+}
+
+// Overdue reports whether the item is not done and past its due time.
+// When the due time is midnight, the item is due at the end of that day.
+func (it *Item) Overdue(now time.Time) bool {
+	due, ok := it.OverdueAt()
+	return ok && !now.Before(due)
+}
+
+// OverdueAt returns the time at which the item becomes overdue. It returns false
+// when the item is done or has no due time.
+func (it *Item) OverdueAt() (time.Time, bool) {
+	if it.Due == nil || it.Done {
+		return time.Time{}, false
+	}
+	due := *it.Due
+	if h, m, s := due.Clock(); h == 0 && m == 0 && s == 0 {
+		due = due.AddDate(0, 0, 1)
+	}
+	return due, true
+}
+
+// HasTag reports whether the item has the given tag.
+func (it *Item) HasTag(tag string) bool {
+	for _, t := range it.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
 }
 
+// Priority is the importance of an item.
+type Priority int
+
+const (
+	PriorityNone Priority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+)
+
+// reloadInterval is how often the data file is checked for changes by other processes
+// when it can't be watched.
+const reloadInterval = 2 * time.Second
+
 type Store struct {
 	dataDir  string
 	dataFile *os.File
-	reader   *json.Decoder
-	writer   *json.Encoder
+	fileInfo os.FileInfo // for detecting replacement of the data file
+	lock     *fileLock
+	watcher  *fileWatcher // nil when the data file is polled
+	offset   int64        // end of the last record read from dataFile
+	partial  bool         // dataFile ends with an incomplete record
+	wbuf     bytes.Buffer
+
+	// These fields are accessed by mainLoop only.
+	key    *Key   // nil when the data file is not encrypted
+	device ID     // ID of this device
+	seq    uint64 // last sequence number assigned by this device
+	state  *state
+	acked  uint64 // highest local sequence number stored on sync server
+
+	// Reminder scheduling, see reminderLoop.
+	reminders        *reminderQueue
+	remindersStarted bool // accessed by mainLoop only
 
-	evLock     sync.Mutex
-	eventQueue list.List
-	wake       func()
+	out eventBuffer // output events, see Subscribe
+
+	log     *slog.Logger
+	metrics Metrics
+
+	// Write batching, see handleBatch.
+	in        *inputQueue
+	batch     *writeBatch // non-nil while a batch is assembled
+	batchSize int
+
+	// Flushing, see Durability.
+	durability    Durability
+	flushInterval time.Duration
+	flushTimer    *time.Timer
+	flushPending  bool // flushTimer is running
+	dirty         bool // data file has changes which weren't flushed
 
-	eventsIn chan Event
 	flushCh  chan struct{}
+	callCh   chan func()
+	syncNow  chan struct{}
 	quitCh   chan struct{}
+	loopDone chan struct{} // closed when mainLoop exits
 	wg       sync.WaitGroup
 }
 
-func NewStore(datadir string, wake func()) *Store {
+// Options configure a store.
+type Options struct {
+	Key     *Key         // for encrypted stores, obtained using Unlock
+	Logger  *slog.Logger // defaults to slog.Default
+	Metrics Metrics      // optional
+
+	// Durability selects when data is flushed to disk. FlushInterval applies to
+	// FlushPeriodic and FlushIdle, and defaults to DefaultFlushInterval.
+	Durability    Durability
+	FlushInterval time.Duration
+}
+
+// NewStore opens the store in datadir. Use Subscribe or SubscribeSnapshot to
+// receive its events.
+func NewStore(datadir string) *Store {
+	return Open(datadir, Options{})
+}
+
+// NewEncryptedStore opens an encrypted store. The key is obtained using Unlock.
+// When key is nil, this is the same as NewStore. Use SetPassphrase to encrypt a store
+// created by NewStore.
+func NewEncryptedStore(datadir string, key *Key) *Store {
+	return Open(datadir, Options{Key: key})
+}
+
+// Open opens the store in datadir with the given options.
+func Open(datadir string, opts Options) *Store {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = nopMetrics{}
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
 	s := &Store{
-		dataDir:  datadir,
-		eventsIn: make(chan Event, 256),
-		flushCh:  make(chan struct{}, 1),
-		quitCh:   make(chan struct{}),
+		dataDir:   datadir,
+		key:       opts.Key,
+		log:       opts.Logger.With("datadir", datadir),
+		metrics:   opts.Metrics,
+		in:        newInputQueue(),
+		batchSize: maxBatch,
+		flushCh:   make(chan struct{}, 1),
+		callCh:    make(chan func()),
+		syncNow:   make(chan struct{}, 1),
+		quitCh:    make(chan struct{}),
+		loopDone:  make(chan struct{}),
+		state:     newState(),
+		reminders: newReminderQueue(),
+
+		durability:    opts.Durability,
+		flushInterval: opts.FlushInterval,
+		flushTimer:    time.NewTimer(opts.FlushInterval),
 	}
+	s.flushTimer.Stop()
 	s.wg.Add(1)
 	go s.mainLoop()
 	return s
@@ -63,35 +214,68 @@ func (s *Store) Close() {
 	s.wg.Wait()
 }
 
-// Events returns the event channel.
-// The app reads this channel and applies the events to the UI.
-func (s *Store) Events() []Event {
-	s.evLock.Lock()
-	defer s.evLock.Unlock()
-
-	if s.eventQueue.Len() == 0 {
-		return nil
-	}
-	ev := make([]Event, s.eventQueue.Len())
-	for i := range ev {
-		ev[i] = s.eventQueue.Remove(s.eventQueue.Front()).(Event)
-	}
-	return ev
+// AddItem tells the store to add a new item.
+// It returns the ID of the new item.
+func (s *Store) AddItem(item Item) ID {
+	id := randomID()
+	s.enqueueInputEvent(&ItemAdded{ID: id, Item: item})
+	return id
 }
 
-// AddItem tells the store to add a new item.
-func (s *Store) AddItem(item Item) {
-	s.enqueueInputEvent(&ItemAdded{ID: randomID(), Item: item})
+// RestoreItem tells the store to add an item with a known ID and position.
+// This is used to undo the removal of an item. When pos is empty, the item is placed
+// at the end of its list.
+func (s *Store) RestoreItem(id ID, item Item, pos Pos) RequestID {
+	return s.enqueueInputEvent(&ItemAdded{ID: id, Item: item, Pos: pos})
 }
 
 // RemoveItem tells the store to delete an item.
-func (s *Store) RemoveItem(id ID) {
-	s.enqueueInputEvent(&ItemRemoved{ID: id})
+func (s *Store) RemoveItem(id ID) RequestID {
+	return s.enqueueInputEvent(&ItemRemoved{ID: id})
 }
 
-// UpdateItem tells the store to change an item.
-func (s *Store) UpdateItem(id ID, item Item) {
-	s.enqueueInputEvent(&ItemChanged{ID: id, Item: item})
+// UpdateItem tells the store to change an item. When fields are given, only those
+// fields are set. Otherwise all fields which differ from the stored item are set.
+//
+// Changes are merged per field, so concurrent changes of different fields on
+// different devices are all kept.
+func (s *Store) UpdateItem(id ID, item Item, fields ...Field) RequestID {
+	return s.enqueueInputEvent(&ItemChanged{ID: id, Item: item, Fields: fields})
+}
+
+// MoveItem tells the store to change the position of an item.
+func (s *Store) MoveItem(id ID, pos Pos) RequestID {
+	return s.enqueueInputEvent(&ItemMoved{ID: id, Pos: pos})
+}
+
+// AddList tells the store to create a new list.
+// It returns the ID of the new list.
+func (s *Store) AddList(name string) ID {
+	id := randomID()
+	s.enqueueInputEvent(&ListAdded{ID: id, Name: name})
+	return id
+}
+
+// RestoreList tells the store to create a list with a known ID.
+// This is used to undo the removal of a list, and brings back its items.
+func (s *Store) RestoreList(id ID, name string) RequestID {
+	return s.enqueueInputEvent(&ListAdded{ID: id, Name: name})
+}
+
+// RenameList tells the store to change the name of a list.
+func (s *Store) RenameList(id ID, name string) RequestID {
+	return s.enqueueInputEvent(&ListChanged{ID: id, Name: name})
+}
+
+// RemoveList tells the store to delete a list. Its items are hidden until the list
+// is restored by RestoreList.
+func (s *Store) RemoveList(id ID) RequestID {
+	return s.enqueueInputEvent(&ListRemoved{ID: id})
+}
+
+// ReorderLists tells the store to change the display order of lists.
+func (s *Store) ReorderLists(order []ID) RequestID {
+	return s.enqueueInputEvent(&ListsReordered{Order: order})
 }
 
 // Persist tells the store to flush data to disk.
@@ -102,16 +286,22 @@ func (s *Store) Persist() {
 	}
 }
 
-// enqueueInputEvent delivers an event from the app to mainLoop.
-func (s *Store) enqueueInputEvent(ev Event) {
+var errStoreClosed = errors.New("store closed")
+
+// runInLoop executes fn on mainLoop.
+func (s *Store) runInLoop(fn func() error) error {
+	errc := make(chan error, 1)
 	select {
-	case s.eventsIn <- ev:
+	case s.callCh <- func() { errc <- fn() }:
+		return <-errc
 	case <-s.quitCh:
+		return errStoreClosed
 	}
 }
 
 func (s *Store) mainLoop() {
 	defer s.wg.Done()
+	defer close(s.loopDone)
 
 	// Initial replay.
 	err := s.initFile()
@@ -119,29 +309,60 @@ func (s *Store) mainLoop() {
 		s.enqueueOutputEvent(&IOError{Err: err})
 	}
 
+	reloadTicker := time.NewTicker(reloadInterval)
+	defer reloadTicker.Stop()
+
 	// Handle events.
 	for {
 		select {
-		case ev := <-s.eventsIn:
-			if err := s.writeEvent(ev); err != nil {
-				s.enqueueOutputEvent(&IOError{Err: err})
-			} else {
-				s.enqueueOutputEvent(ev)
+		case <-s.in.ready:
+			s.handleQueued()
+
+		case fn := <-s.callCh:
+			fn()
+
+		case _, ok := <-s.watcher.changes():
+			if !ok {
+				s.log.Warn("file watcher failed, polling data file")
+				s.watcher.close()
+				s.watcher = nil
+			}
+			s.reload()
+
+		case <-reloadTicker.C:
+			if s.watcher == nil {
+				s.reload()
 			}
 
 		case <-s.flushCh:
 			if s.dataFile != nil {
-				err := s.dataFile.Sync()
-				log.Printf("data file flushed (err: %v)", err)
-				if err != nil {
+				if err := s.flushFile(); err != nil {
 					s.enqueueOutputEvent(&IOError{Err: err})
 				}
 			}
 
+		case <-s.flushTimer.C:
+			s.flushDue()
+
 		case <-s.quitCh:
+			// Store events that were sent before Close.
+			if err := s.handlePendingInput(); err != nil {
+				s.log.Error("can't store pending events", "err", err)
+			}
+			s.flushTimer.Stop()
 			if s.dataFile != nil {
-				err := s.dataFile.Close()
-				log.Printf("data file closed (err: %v)", err)
+				if s.dirty {
+					s.flushFile()
+				}
+				if err := s.dataFile.Close(); err != nil {
+					s.log.Error("can't close data file", "err", err)
+				} else {
+					s.log.Info("data file closed")
+				}
+				s.lock.close()
+			}
+			if s.watcher != nil {
+				s.watcher.close()
 			}
 			return
 		}
@@ -149,19 +370,162 @@ func (s *Store) mainLoop() {
 }
 
 func (s *Store) enqueueOutputEvent(ev Event) {
-	s.evLock.Lock()
-	s.eventQueue.PushBack(ev)
-	s.evLock.Unlock()
-	if s.wake != nil {
-		s.wake()
+	if !s.out.add(ev) {
+		s.metrics.EventDropped()
 	}
 }
 
-func (s *Store) writeEvent(ev Event) error {
+// storeInputEvent stamps and stores an event created by the app.
+// This must be called with the lock held.
+func (s *Store) storeInputEvent(ev Event) error {
+	if b, ok := ev.(*BulkChange); ok {
+		return s.storeBulk(b)
+	}
+	var next *ItemAdded
+	switch ev := ev.(type) {
+	case *ItemAdded:
+		// New items are placed at the end of their list.
+		if ev.Pos == "" {
+			ev.Pos = s.state.endPos(ev.Item.List)
+		}
+	case *ItemChanged:
+		// Only changed fields are stored, so they don't overwrite concurrent
+		// changes of other fields.
+		if len(ev.Fields) == 0 {
+			if ev.Fields = s.state.changedFields(ev.ID, &ev.Item); len(ev.Fields) == 0 {
+				return nil
+			}
+		}
+		next = s.state.nextOccurrence(ev)
+	}
+
+	if err := s.writeNewRecord(ev); err != nil {
+		return err
+	}
+	if next != nil {
+		if err := s.writeNewRecord(next); err != nil {
+			return err
+		}
+	}
+	s.triggerSync()
+	return nil
+}
+
+// writeNewRecord stamps and writes an event created on this device.
+func (s *Store) writeNewRecord(ev Event) error {
+	s.seq++
+	rec := &record{ev: ev, stamp: stamp{Device: s.device, Seq: s.seq, hlc: s.now()}}
+	if err := s.writeRecord(rec); err != nil {
+		s.seq--
+		return err
+	}
+	return nil
+}
+
+// handleRemoteRecords stores records received from another device.
+func (s *Store) handleRemoteRecords(recs []*record) error {
 	if err := s.initFile(); err != nil {
 		return err
 	}
-	return writeEvent(s.writer, ev)
+	return s.withLock(func() error {
+		for _, rec := range recs {
+			if rec.Device == s.device || s.state.isDuplicate(rec) {
+				continue
+			}
+			if err := s.writeRecord(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// withLock runs fn while holding the lock of the data directory. Other processes may
+// have written to the data file, so their records are read first.
+func (s *Store) withLock(fn func() error) error {
+	if err := s.lock.lock(); err != nil {
+		return err
+	}
+	defer s.lock.unlock()
+	if _, err := s.readNew(); err != nil {
+		return err
+	}
+	if s.partial {
+		if err := s.removePartial(); err != nil {
+			return err
+		}
+	}
+	return fn()
+}
+
+// removePartial truncates an incomplete record at the end of the data file. Other
+// processes only write while holding the lock, so when the lock is held, such a
+// record was left by a process which crashed while writing. It must be removed before
+// appending, because the records written after it could not be read.
+func (s *Store) removePartial() error {
+	info, err := s.dataFile.Stat()
+	if err != nil {
+		return err
+	}
+	s.log.Warn("removing incomplete record", "offset", s.offset, "size", info.Size()-s.offset)
+	if err := s.dataFile.Truncate(s.offset); err != nil {
+		return err
+	}
+	s.partial = false
+	return nil
+}
+
+// writeRecord appends a record to the data file and applies it. While a batch is
+// assembled, the record is only added to the write buffer.
+// This must be called with the lock held.
+func (s *Store) writeRecord(rec *record) error {
+	stored := rec
+	if _, isHeader := rec.ev.(*fileHeader); s.key != nil && !isHeader {
+		var err error
+		if stored, err = s.key.sealRecord(rec); err != nil {
+			return err
+		}
+	}
+	if s.batch == nil {
+		s.wbuf.Reset()
+	}
+	start := s.wbuf.Len()
+	if err := writeRecord(json.NewEncoder(&s.wbuf), stored); err != nil {
+		s.wbuf.Truncate(start)
+		return err
+	}
+	if s.batch != nil {
+		s.batch.sizes = append(s.batch.sizes, s.wbuf.Len()-start)
+		s.apply(rec)
+		return nil
+	}
+	n, err := s.dataFile.Write(s.wbuf.Bytes())
+	s.offset += int64(n)
+	if err != nil {
+		return err
+	}
+	s.metrics.RecordWritten(n)
+	s.apply(rec)
+	return s.wrote()
+}
+
+// apply updates the state with a record and sends the resulting events to the app.
+func (s *Store) apply(rec *record) {
+	for _, ev := range s.state.apply(rec) {
+		if s.batch != nil {
+			s.batch.hold(ev)
+		} else {
+			s.enqueueOutputEvent(ev)
+		}
+		s.reminders.handle(ev)
+	}
+}
+
+// now returns the timestamp for a new local record. It is later than any record
+// seen so far. This ensures that local changes win against all earlier changes,
+// even when the clocks of devices are not in sync.
+func (s *Store) now() hlc {
+	return s.state.clock.next(time.Now().UnixMilli())
 }
 
 func (s *Store) initFile() error {
@@ -172,34 +536,186 @@ func (s *Store) initFile() error {
 	if err := os.MkdirAll(s.dataDir, 0700); err != nil {
 		return err
 	}
+	var err error
+	if s.device, err = loadDeviceID(s.dataDir); err != nil {
+		return err
+	}
+	if s.lock, err = openLock(filepath.Join(s.dataDir, "lock")); err != nil {
+		return err
+	}
+	if err := s.openDataFile(); err != nil {
+		s.lock.close()
+		return err
+	}
+	if s.watcher == nil {
+		w, err := newFileWatcher(s.dataFile.Name())
+		if err != nil {
+			s.log.Warn("can't watch data file, polling instead", "err", err)
+		}
+		s.watcher = w
+	}
+
+	// New files start with a header.
+	err = s.withLock(func() error {
+		if s.offset > 0 {
+			return nil
+		}
+		info, err := s.dataFile.Stat()
+		if err != nil || info.Size() > 0 {
+			return err
+		}
+		return s.writeRecord(&record{ev: s.key.header(nil)})
+	})
+	if err != nil {
+		// Start over on the next attempt. This also ensures nothing is written
+		// to a file that can't be read, e.g. because it is encrypted.
+		s.dataFile.Close()
+		s.lock.close()
+		s.dataFile, s.offset, s.seq, s.state = nil, 0, 0, newState()
+		s.reminders.reset()
+	}
+	return err
+}
+
+// openDataFile opens the data file. Its content is read by readNew.
+func (s *Store) openDataFile() error {
 	filename := filepath.Join(s.dataDir, "events.json")
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
 	if err != nil {
 		return err
 	}
-	log.Printf("data file opened: %s", filename)
-	s.dataFile = f
-	s.reader = json.NewDecoder(f)
-	s.writer = json.NewEncoder(f)
-	s.replay()
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.log.Info("data file opened", "file", filename)
+	if s.dataFile != nil {
+		s.dataFile.Close()
+	}
+	s.dataFile, s.fileInfo, s.offset = f, info, 0
 	return nil
 }
 
-// replay loads events from the data file and sends them.
-func (s *Store) replay() {
-	begin := time.Now()
-	count := 0
+// loadDeviceID reads the ID of this device, creating it if necessary.
+func loadDeviceID(dir string) (ID, error) {
+	filename := filepath.Join(dir, "device")
+	content, err := os.ReadFile(filename)
+	if err == nil && len(content) > 0 {
+		return ID(strings.TrimSpace(string(content))), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	id := randomID()
+	return id, os.WriteFile(filename, []byte(id+"\n"), 0644)
+}
+
+// reload reads records written to the data file by other processes.
+func (s *Store) reload() {
+	if s.dataFile == nil {
+		return
+	}
+	if n, err := s.readNew(); err != nil {
+		s.enqueueOutputEvent(&IOError{Err: err})
+	} else if n > 0 {
+		s.log.Info("reloaded data file", "records", n)
+	}
+}
+
+// readNew reads records appended to the data file since it was last read, and applies
+// them. When the file was replaced by Compact in another process, it is read again from
+// the start. Since applying a record twice has no effect, this only applies the changes.
+// It returns the number of records read.
+func (s *Store) readNew() (int, error) {
+	s.partial = false
+	if info, err := os.Stat(s.dataFile.Name()); err == nil && !os.SameFile(info, s.fileInfo) {
+		if err := s.openDataFile(); err != nil {
+			return 0, err
+		}
+	}
+	info, err := s.dataFile.Stat()
+	if err != nil || info.Size() <= s.offset {
+		return 0, err
+	}
+
+	var (
+		begin         = time.Now()
+		initial       = s.offset == 0
+		count, unread = 0, 0
+		r             = io.NewSectionReader(s.dataFile, s.offset, info.Size()-s.offset)
+		dec           = json.NewDecoder(r)
+		start         = s.offset
+	)
+	err = scanRecords(dec, s.key, s.device, &s.seq, func(rec *record) bool {
+		s.offset = start + dec.InputOffset()
+		count++
+		switch ev := rec.ev.(type) {
+		case *fileHeader:
+			if ev.Version > formatVersion {
+				s.log.Warn("data file has newer format version, some events may be skipped", "version", ev.Version)
+			}
+			s.state.applyHeader(ev)
+		case *UnknownEvent:
+			s.state.apply(rec) // for sequence number tracking
+			unread++
+		default:
+			s.apply(rec)
+		}
+		return true
+	})
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		// The last record is incomplete. It will be read when it is complete.
+		s.partial = true
+	case isCryptError(err):
+		return count, err
+	case err != nil && (initial || count > 0):
+		s.log.Warn("decode error", "err", err)
+	}
+	if initial {
+		d := time.Since(begin)
+		s.metrics.Replay(count, unread, d)
+		s.log.Info("replay done", "records", count, "skipped", unread, "duration", d)
+	}
+	return count, nil
+}
+
+// scanRecords reads records from dec and calls fn for each one, until fn returns false.
+// Encrypted records are decrypted with key. The highest sequence number of the given
+// device is tracked in seq.
+func scanRecords(dec *json.Decoder, key *Key, device ID, seq *uint64, fn func(*record) bool) error {
 	for {
-		ev, err := readEvent(s.reader)
+		rec, err := readRecord(dec)
 		if err == io.EOF {
-			break
+			return nil
+		} else if err != nil {
+			return err
 		}
-		if err != nil {
-			log.Printf("decode error: %v", err)
-			break
+		if rec, err = key.openRecord(rec); err != nil {
+			return err
+		}
+		switch ev := rec.ev.(type) {
+		case *fileHeader:
+			if err := key.checkHeader(ev); err != nil {
+				return err
+			}
+			if ev.Seqs[device] > *seq {
+				*seq = ev.Seqs[device]
+			}
+		default:
+			// Events written before sync support have no stamp. They are assigned
+			// to this device.
+			if rec.Device == "" {
+				rec.Device = device
+				rec.Seq = *seq + 1
+			}
+		}
+		if rec.Device == device && rec.Seq > *seq {
+			*seq = rec.Seq
+		}
+		if !fn(rec) {
+			return nil
 		}
-		count++
-		s.enqueueOutputEvent(ev)
 	}
-	log.Printf("replay done: %d items (%v)", count, time.Since(begin))
 }
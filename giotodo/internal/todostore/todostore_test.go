@@ -0,0 +1,241 @@
+package todostore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func snapshotOf(t *testing.T, s *Store) *Snapshot {
+	t.Helper()
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
+
+// This checks that two stores using the same directory see each other's changes,
+// like the app and the command-line interface do.
+func TestStoreConcurrentAccess(t *testing.T) {
+	dir := t.TempDir()
+	s1 := NewStore(dir)
+	defer s1.Close()
+	s2 := NewStore(dir)
+	defer s2.Close()
+
+	id1 := s1.AddItem(Item{Text: "one"})
+	snapshotOf(t, s1)
+	id2 := s2.AddItem(Item{Text: "two"})
+	snapshotOf(t, s2)
+	s1.UpdateItem(id2, Item{Done: true}, FieldDone)
+
+	for i, s := range []*Store{s1, s2} {
+		snap := snapshotOf(t, s)
+		if len(snap.Items) != 2 {
+			t.Fatalf("store %d: wrong number of items %d", i+1, len(snap.Items))
+		}
+		if snap.Items[0].ID != id1 || snap.Items[1].ID != id2 {
+			t.Fatalf("store %d: wrong items %+v", i+1, snap.Items)
+		}
+		if !snap.Items[1].Item.Done {
+			t.Fatalf("store %d: change of other store not applied", i+1)
+		}
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir)
+
+	keep := s.AddItem(Item{Text: "keep"})
+	for i := 0; i < 5; i++ {
+		s.UpdateItem(keep, Item{Text: "edited"}, FieldText)
+	}
+	s.UpdateItem(keep, Item{Done: true}, FieldDone)
+	gone := s.AddItem(Item{Text: "gone"})
+	s.RemoveItem(gone)
+	list := s.AddList("list")
+	s.RenameList(list, "renamed")
+	want := snapshotOf(t, s)
+
+	result, err := s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Remaining: add and two changes of 'keep', removal of 'gone', list add and rename.
+	if result.Before != 11 || result.After != 6 {
+		t.Fatalf("wrong result %+v", result)
+	}
+	if snap := snapshotOf(t, s); !reflect.DeepEqual(snap, want) {
+		t.Fatalf("wrong snapshot after compaction:\n got %+v\nwant %+v", snap, want)
+	}
+
+	// New records must continue the sequence numbers of the device.
+	seq := s.seq
+	s.AddItem(Item{Text: "new"})
+	snapshotOf(t, s)
+	s.Close()
+
+	s2 := NewStore(dir)
+	defer s2.Close()
+	snap := snapshotOf(t, s2)
+	if len(snap.Items) != 2 || len(snap.Lists) != 2 {
+		t.Fatalf("wrong snapshot after reopening: %+v", snap)
+	}
+	if s2.seq != seq+1 {
+		t.Fatalf("wrong sequence number %d, want %d", s2.seq, seq+1)
+	}
+}
+
+// This checks that an incomplete record at the end of the file, i.e. one that is
+// being written by another process, is read once it is complete.
+func TestStorePartialRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir)
+	defer s.Close()
+	snapshotOf(t, s)
+
+	f, err := os.OpenFile(filepath.Join(dir, "events.json"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	line := `{"type":"add","v":1,"dev":"other","seq":1,"t":1000,"event":{"ID":"a1","Item":{"Text":"x","Done":false}}}` + "\n"
+	for i, part := range []string{line[:40], line[40:]} {
+		if _, err := f.WriteString(part); err != nil {
+			t.Fatal(err)
+		}
+		snap := snapshotOf(t, s)
+		if complete := i == 1; complete != (len(snap.Items) == 1) {
+			t.Fatalf("wrong items after writing part %d: %+v", i, snap.Items)
+		}
+	}
+}
+
+// This checks that changes made by another process are delivered as events
+// without any action of the app.
+func TestStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	s1 := NewStore(dir)
+	defer s1.Close()
+	sub, _, err := s1.SubscribeSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	s2 := NewStore(dir)
+	defer s2.Close()
+
+	id := s2.AddItem(Item{Text: "from s2"})
+	snapshotOf(t, s2)
+
+	timeout := time.After(2 * reloadInterval)
+	for {
+		select {
+		case <-sub.Ready():
+			for _, ev := range sub.Events() {
+				if ev, ok := ev.(*ItemAdded); ok && ev.ID == id {
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatal("item added by other store not received")
+		}
+	}
+}
+
+// This checks that restoring a removed list brings back its items, including changes
+// made while the list was removed.
+func TestStoreRestoreList(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+	snapshotOf(t, s)
+	sub := s.Subscribe(nil)
+	defer sub.Close()
+
+	list := s.AddList("shop")
+	id := s.AddItem(Item{Text: "milk", List: list})
+	s.RemoveList(list)
+	s.UpdateItem(id, Item{Text: "oat milk"}, FieldText)
+	s.RestoreList(list, "shop")
+	snap := snapshotOf(t, s)
+
+	var events []string
+	for _, ev := range sub.Events() {
+		switch ev := ev.(type) {
+		case *ItemAdded:
+			events = append(events, "add "+ev.Item.Text)
+		case *ItemChanged:
+			events = append(events, "change "+ev.Item.Text)
+		case *ItemRemoved:
+			events = append(events, "remove")
+		case *ListAdded:
+			events = append(events, "list-add")
+		case *ListRemoved:
+			events = append(events, "list-remove")
+		}
+	}
+	want := []string{"list-add", "add milk", "list-remove", "list-add", "add oat milk"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("wrong events\ngot:  %q\nwant: %q", events, want)
+	}
+	if len(snap.Items) != 1 || snap.Items[0].Item.Text != "oat milk" {
+		t.Errorf("wrong snapshot items %+v", snap.Items)
+	}
+}
+
+// largeListSize is the number of items in benchmarks of large lists.
+const largeListSize = 100000
+
+// BenchmarkReplay measures loading a data file with many items.
+func BenchmarkReplay(b *testing.B) {
+	dir := b.TempDir()
+	s := Open(dir, Options{Logger: discardLogger})
+	for i := 0; i < largeListSize; i++ {
+		waitSpace(b, s)
+		s.AddItem(Item{Text: fmt.Sprint("item ", i), Done: i%3 == 0})
+	}
+	if _, err := s.Snapshot(); err != nil {
+		b.Fatal(err)
+	}
+	s.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := Open(dir, Options{Logger: discardLogger})
+		snap, err := s.Snapshot()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(snap.Items) != largeListSize {
+			b.Fatalf("got %d items", len(snap.Items))
+		}
+		s.Close()
+	}
+}
+
+// BenchmarkAddToLargeList measures adding items at the end of a large list.
+func BenchmarkAddToLargeList(b *testing.B) {
+	s := Open(b.TempDir(), Options{Logger: discardLogger})
+	defer s.Close()
+	for i := 0; i < largeListSize; i++ {
+		waitSpace(b, s)
+		s.AddItem(Item{Text: fmt.Sprint("item ", i)})
+	}
+	if _, err := s.Snapshot(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		waitSpace(b, s)
+		s.AddItem(Item{Text: fmt.Sprint("new ", i)})
+	}
+	if _, err := s.Snapshot(); err != nil {
+		b.Fatal(err)
+	}
+}
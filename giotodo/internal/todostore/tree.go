@@ -0,0 +1,68 @@
+package todostore
+
+// Items form a tree through their Parent field. Since devices edit the tree
+// concurrently, the stored parents don't always describe a valid tree: the parent may
+// have been removed or moved to another list, and moving items on two devices can
+// create a cycle. ResolveParents turns the stored parents into a tree. It only depends
+// on the current state, not on the order in which records were applied, so all
+// devices display the same tree.
+
+// ResolveParents computes the effective parent of items, given the Parent field of
+// each item in a list. Items whose parent is not in the map are top-level items.
+// When parents form a cycle, the item with the lowest ID in the cycle becomes a
+// top-level item. The result contains all items, with an empty parent for top-level
+// items.
+func ResolveParents(parents map[ID]ID) map[ID]ID {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var (
+		result = make(map[ID]ID, len(parents))
+		status = make(map[ID]int, len(parents))
+		path   []ID
+	)
+	for start := range parents {
+		// Follow the parents until reaching a top-level item, an item that was
+		// already resolved, or an item of the current path.
+		path = path[:0]
+		id, cycle := start, false
+		for {
+			if status[id] != unvisited {
+				cycle = status[id] == visiting
+				break
+			}
+			status[id] = visiting
+			path = append(path, id)
+			p := parents[id]
+			if _, ok := parents[p]; !ok {
+				break
+			}
+			id = p
+		}
+		// Resolve the path.
+		var cycleRoot ID
+		if cycle {
+			// The path ends in a cycle, starting at id.
+			inCycle := false
+			for _, pid := range path {
+				if pid == id {
+					inCycle = true
+				}
+				if inCycle && (cycleRoot == "" || pid < cycleRoot) {
+					cycleRoot = pid
+				}
+			}
+		}
+		for _, pid := range path {
+			if _, ok := parents[parents[pid]]; ok && pid != cycleRoot {
+				result[pid] = parents[pid]
+			} else {
+				result[pid] = ""
+			}
+			status[pid] = visited
+		}
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+package todostore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveParents(t *testing.T) {
+	tests := []struct {
+		name    string
+		parents map[ID]ID
+		want    map[ID]ID
+	}{
+		{
+			name:    "tree",
+			parents: map[ID]ID{"a": "", "b": "a", "c": "b", "d": "a"},
+			want:    map[ID]ID{"a": "", "b": "a", "c": "b", "d": "a"},
+		},
+		{
+			name:    "orphan",
+			parents: map[ID]ID{"a": "x", "b": "a"},
+			want:    map[ID]ID{"a": "", "b": "a"},
+		},
+		{
+			name:    "self",
+			parents: map[ID]ID{"a": "a"},
+			want:    map[ID]ID{"a": ""},
+		},
+		{
+			name:    "cycle",
+			parents: map[ID]ID{"c": "b", "b": "a", "a": "c", "d": "b"},
+			want:    map[ID]ID{"a": "", "b": "a", "c": "b", "d": "b"},
+		},
+	}
+	for _, test := range tests {
+		// Map iteration order is random, so try a few times.
+		for i := 0; i < 10; i++ {
+			if got := ResolveParents(test.parents); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("%s: got %v, want %v", test.name, got, test.want)
+			}
+		}
+	}
+}
+
+// This checks that subtasks of removed items, and of items in another list,
+// become top-level items.
+func TestStoreOrphans(t *testing.T) {
+	s := NewStore(t.TempDir())
+	defer s.Close()
+	parent := s.AddItem(Item{Text: "parent"})
+	child := s.AddItem(Item{Text: "child", Parent: parent})
+	s.AddItem(Item{Text: "grandchild", Parent: child})
+	list := s.AddList("other")
+	s.AddItem(Item{Text: "other list", List: list, Parent: child})
+	s.RemoveItem(parent)
+
+	snap := snapshotOf(t, s)
+	parents := make(map[string]ID)
+	for _, info := range snap.Items {
+		parents[info.Item.Text] = info.Item.Parent
+	}
+	want := map[string]ID{"child": "", "grandchild": child, "other list": ""}
+	if !reflect.DeepEqual(parents, want) {
+		t.Fatalf("wrong parents %v, want %v", parents, want)
+	}
+}
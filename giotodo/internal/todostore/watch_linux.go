@@ -0,0 +1,80 @@
+package todostore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileWatcher reports changes of a file using inotify. The directory is watched
+// instead of the file itself because Compact replaces the file.
+type fileWatcher struct {
+	f       *os.File
+	changed chan struct{}
+}
+
+func newFileWatcher(filename string) (*fileWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	dir, name := filepath.Split(filename)
+	mask := uint32(unix.IN_MODIFY | unix.IN_CREATE | unix.IN_MOVED_TO)
+	if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	// The file is non-blocking, so reads go through the runtime poller
+	// and Close interrupts them.
+	w := &fileWatcher{
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		changed: make(chan struct{}, 1),
+	}
+	go w.loop(name)
+	return w, nil
+}
+
+func (w *fileWatcher) loop(name string) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				close(w.changed)
+			}
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+			if string(bytes.TrimRight(nameBytes, "\x00")) == name {
+				w.notify()
+			}
+			off += unix.SizeofInotifyEvent + int(ev.Len)
+		}
+	}
+}
+
+// changes returns a channel which receives a value when the watched file changes.
+// It is closed when watching fails.
+func (w *fileWatcher) changes() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.changed
+}
+
+func (w *fileWatcher) notify() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (w *fileWatcher) close() error {
+	return w.f.Close()
+}
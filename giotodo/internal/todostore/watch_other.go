@@ -0,0 +1,17 @@
+//go:build !linux
+
+package todostore
+
+import "errors"
+
+// fileWatcher is not implemented on this platform. The data file is polled instead.
+type fileWatcher struct{}
+
+func newFileWatcher(filename string) (*fileWatcher, error) {
+	return nil, errors.New("file watching not supported")
+}
+
+// changes returns nil, so the store never receives a change notification.
+func (w *fileWatcher) changes() <-chan struct{} { return nil }
+
+func (w *fileWatcher) close() error { return nil }
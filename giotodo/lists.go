@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/widget"
+
+	. "github.com/fjl/gio-demos/internal/cd"
+)
+
+// layoutLists draws the list manager, which replaces the items while it is shown.
+func (ui *todoUI) layoutLists(gtx C) D {
+	// Process new list submissions.
+	for {
+		e, ok := ui.listInput.Update(gtx)
+		if !ok {
+			break
+		}
+		switch e := e.(type) {
+		case widget.SubmitEvent:
+			name := strings.TrimSpace(e.Text)
+			if name != "" {
+				ui.listInput.SetText("")
+				ui.lists.addList(name)
+			}
+		}
+	}
+
+	// Process list actions.
+	lists := ui.lists.lists
+	for _, l := range lists {
+		if l.btn.click.Clicked(gtx) && l != ui.listBeingEdited {
+			ui.selectList(l)
+		}
+		if l.btn.up.Clicked(gtx) {
+			ui.lists.move(l, -1)
+		}
+		if l.btn.down.Clicked(gtx) {
+			ui.lists.move(l, +1)
+		}
+		if l.btn.rename.Clicked(gtx) {
+			ui.startListEdit(gtx, l)
+		}
+		if l.btn.remove.Clicked(gtx) {
+			ui.lists.remove(l)
+		}
+	}
+	if ui.listBeingEdited != nil {
+		for {
+			e, ok := ui.listEditor.Update(gtx)
+			if !ok {
+				break
+			}
+			switch e.(type) {
+			case widget.SubmitEvent:
+				ui.endListEdit()
+			}
+		}
+	}
+
+	// Draw the lists, followed by the input for new lists.
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Flexed(1.0, func(gtx C) D {
+			return ui.listsList.Layout(gtx, len(lists), func(gtx C, i int) D {
+				l := lists[i]
+				var e *widget.Editor
+				if l == ui.listBeingEdited {
+					e = &ui.listEditor
+				}
+				w := ui.theme.ListRow(l, l == ui.todos, e)
+				return w.Layout(gtx)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			ed := ui.theme.Editor(&ui.listInput, "New list")
+			return ui.theme.Pad.Main.Layout(gtx, ed.Layout)
+		}),
+	)
+}
+
+// selectList makes l the current list and closes the list manager.
+func (ui *todoUI) selectList(l *todoModel) {
+	ui.endItemEdit()
+	ui.endListEdit()
+	ui.listID = l.id
+	ui.todos = l
+	ui.showLists = false
+	ui.selected.clear()
+}
+
+func (ui *todoUI) startListEdit(gtx C, l *todoModel) {
+	if ui.listBeingEdited == l {
+		return
+	}
+	ui.endListEdit()
+
+	ui.listBeingEdited = l
+	ui.listEditor = widget.Editor{Submit: true, SingleLine: true, InputHint: key.HintText}
+	ui.listEditor.SetText(l.name)
+	length := ui.listEditor.Len()
+	ui.listEditor.SetCaret(length, length)
+	gtx.Execute(key.FocusCmd{Tag: &ui.listEditor})
+}
+
+func (ui *todoUI) endListEdit() {
+	if ui.listBeingEdited == nil {
+		return
+	}
+	name := strings.TrimSpace(ui.listEditor.Text())
+	if name != "" && name != ui.listBeingEdited.name {
+		ui.lists.rename(ui.listBeingEdited, name)
+	}
+	ui.listBeingEdited = nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// addList adds a list and returns it.
+func (d *driver) addList(name string) *todoModel {
+	d.t.Helper()
+	d.model.addList(name)
+	d.sync()
+	id, ok := d.model.lookup(name)
+	if !ok {
+		d.t.Fatalf("list %q not added", name)
+	}
+	return d.model.get(id)
+}
+
+func itemTexts(items []*item) []string {
+	texts := make([]string, len(items))
+	for i, it := range items {
+		texts[i] = it.text
+	}
+	return texts
+}
+
+func listNames(lists []*todoModel) []string {
+	names := make([]string, len(lists))
+	for i, l := range lists {
+		names[i] = l.name
+	}
+	return names
+}
+
+func checkItems(t *testing.T, l *todoModel, filter itemFilter, query string, want ...string) {
+	t.Helper()
+	got := itemTexts(l.filteredItems(filter, "", query))
+	if len(want) == 0 {
+		want = []string{}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("list %q, filter %v, query %q: items %q, want %q", l.name, filter, query, got, want)
+	}
+}
+
+func TestListSwitch(t *testing.T) {
+	d := newDriver(t)
+	def := d.ui.todos
+	d.add("buy milk")
+	work := d.addList("Work")
+	d.ui.selectList(work)
+	d.frame()
+	if d.ui.todos != work {
+		t.Fatal("list not selected")
+	}
+	d.add("write report")
+	checkItems(t, work, filterAll, "", "write report")
+	checkItems(t, def, filterAll, "", "buy milk")
+
+	// Cached search results of a list are updated when it changes while another
+	// list is shown.
+	checkItems(t, work, filterAll, "report", "write report")
+	d.ui.selectList(def)
+	d.frame()
+	work.add(todostore.Item{Text: "read report"})
+	d.sync()
+	checkItems(t, work, filterAll, "report", "write report", "read report")
+	checkItems(t, def, filterAll, "report")
+	work.setDone(work.filteredItems(filterAll, "", "")[0], true)
+	d.sync()
+	checkItems(t, work, filterActive, "report", "read report")
+	checkItems(t, work, filterCompleted, "", "write report")
+}
+
+func TestListReorder(t *testing.T) {
+	d := newDriver(t)
+	d.addList("Work")
+	home := d.addList("Home")
+	if names := listNames(d.model.lists); !reflect.DeepEqual(names, []string{"Todo", "Work", "Home"}) {
+		t.Fatalf("lists %q", names)
+	}
+
+	d.model.move(home, -1)
+	d.sync()
+	if names := listNames(d.model.lists); !reflect.DeepEqual(names, []string{"Todo", "Home", "Work"}) {
+		t.Fatalf("lists %q after move", names)
+	}
+	d.model.move(home, -1)
+	d.model.move(home, -1) // no effect at the start
+	d.sync()
+	if names := listNames(d.model.lists); !reflect.DeepEqual(names, []string{"Home", "Todo", "Work"}) {
+		t.Fatalf("lists %q after moving to start", names)
+	}
+
+	d.model.history.undo()
+	d.sync()
+	if names := listNames(d.model.lists); !reflect.DeepEqual(names, []string{"Todo", "Home", "Work"}) {
+		t.Fatalf("lists %q after undo", names)
+	}
+	checkItems(t, home, filterAll, "")
+}
+
+func TestListRemove(t *testing.T) {
+	d := newDriver(t)
+	d.add("buy milk")
+	work := d.addList("Work")
+	d.ui.selectList(work)
+	d.frame()
+	report := d.add("write report")
+	work.add(todostore.Item{Text: "outline", Parent: report.id})
+	d.sync()
+
+	// The removed list is replaced by the default list. Its items are gone from the
+	// model and the store.
+	d.model.remove(work)
+	d.sync()
+	if d.ui.todos != d.model.get(todostore.DefaultList) {
+		t.Fatalf("list %q shown after removal", d.ui.todos.name)
+	}
+	if _, ok := d.model.lookup("Work"); ok {
+		t.Fatal("list not removed")
+	}
+	if d.model.items[report.id] != nil {
+		t.Fatal("item of removed list still in model")
+	}
+	if _, ok := d.storedText(report.id); ok {
+		t.Fatal("item of removed list still stored")
+	}
+	checkItems(t, d.ui.todos, filterAll, "report")
+
+	// Undo restores the list with its items, at its previous position.
+	d.model.history.undo()
+	d.sync()
+	id, ok := d.model.lookup("Work")
+	if !ok {
+		t.Fatal("list not restored")
+	}
+	restored := d.model.get(id)
+	if names := listNames(d.model.lists); !reflect.DeepEqual(names, []string{"Todo", "Work"}) {
+		t.Fatalf("lists %q after undo", names)
+	}
+	checkItems(t, restored, filterAll, "", "write report", "outline")
+	checkItems(t, restored, filterAll, "outline", "outline")
+	checkItems(t, d.model.get(todostore.DefaultList), filterAll, "", "buy milk")
+}
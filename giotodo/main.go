@@ -3,62 +3,158 @@ package main
 import (
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gioui.org/app"
+	"gioui.org/gesture"
+	"gioui.org/io/event"
 	"gioui.org/io/key"
+	"gioui.org/io/pointer"
 	"gioui.org/layout"
 	"gioui.org/op"
 	"gioui.org/op/paint"
 	"gioui.org/text"
 	"gioui.org/unit"
 	"gioui.org/widget"
+	"github.com/fjl/gio-demos/giotodo/internal/itemtext"
+	"github.com/fjl/gio-demos/giotodo/internal/notify"
+	"github.com/fjl/gio-demos/giotodo/internal/search"
 	"github.com/fjl/gio-demos/giotodo/internal/todostore"
 
 	. "github.com/fjl/gio-demos/internal/cd"
 )
 
 type todoUI struct {
-	todos  *todoModel
+	lists  *todoLists
+	todos  *todoModel // the current list
+	listID todostore.ID
 	filter itemFilter
+	tag    string // tag filter, empty when not filtering by tag
 
 	// UI elements.
 	theme     *todoTheme
 	mainInput widget.Editor
 	list      layout.List
+	shown     []*item // items drawn in the last frame
 	all       widget.Clickable
 	active    widget.Clickable
 	completed widget.Clickable
+	overdue   widget.Clickable
+	priority  widget.Clickable
+	clearTag  widget.Clickable
 	clear     widget.Clickable
+	toggleAll widget.Clickable
+
+	// Item focus and dragging.
+	focusItem  *item
+	dragItem   *item
+	dragStart  float32
+	dragOffset float32
+	dropGap    int
+
+	// Selection and bulk actions.
+	selected     selection
+	bulkDone     widget.Clickable
+	bulkReopen   widget.Clickable
+	bulkRemove   widget.Clickable
+	bulkMove     widget.Clickable
+	showBulkMove bool // shows the lists to move the selected items to
+	bulkTag      widget.Editor
+	bulkClear    widget.Clickable
 
 	// Item editing.
-	itemBeingEdited    *item
-	itemEditor         widget.Editor
-	editFocusRequested bool
-	initialFocus       bool
+	itemBeingEdited *item
+	itemEditor      widget.Editor
+	editFocused     bool // itemEditor has received focus
+	initialFocus    bool
+
+	// Search.
+	searchInput          widget.Editor
+	showSearch           bool
+	searchFocusRequested bool
+	closeSearchBtn       widget.Clickable
+	query                string // folded search text
+
+	// Undo snackbar.
+	undoBtn    widget.Clickable
+	snack      *undoAction
+	snackSeq   int
+	snackUntil time.Time
+
+	// Retry of failed changes which aren't shown next to an item.
+	retryBtn widget.Clickable
+
+	// List management.
+	listSwitch      widget.Clickable
+	showLists       bool
+	listsList       layout.List
+	listInput       widget.Editor
+	listBeingEdited *todoModel
+	listEditor      widget.Editor
+
+	// Menu and file import/export.
+	menuBtn     widget.Clickable
+	showMenu    bool
+	menu        menuButtons
+	transfer    *fileTransfer
+	passChange  *passphraseChange
+	notice      string
+	noticeErr   bool
+	noticeUntil time.Time
+
+	// Reminders of due items.
+	banner reminderBanner
+
+	// Debug overlay, toggled with Shortcut+Shift+D.
+	stats     *todostore.Stats
+	showDebug bool
 }
 
-func newTodoUI(theme *todoTheme, model *todoModel) *todoUI {
+// snackbarTimeout is how long the undo snackbar is shown.
+const snackbarTimeout = 6 * time.Second
+
+// syncInterval is how often the store syncs when there are no local changes.
+const syncInterval = 30 * time.Second
+
+func newTodoUI(theme *todoTheme, model *todoLists) *todoUI {
 	ui := &todoUI{
-		todos:     model,
+		lists:     model,
+		listID:    todostore.DefaultList,
 		filter:    filterAll,
 		theme:     theme,
 		mainInput: widget.Editor{Submit: true, SingleLine: true, InputHint: key.HintText},
 		list:      layout.List{Axis: layout.Vertical},
+		listInput: widget.Editor{Submit: true, SingleLine: true, InputHint: key.HintText},
+		listsList: layout.List{Axis: layout.Vertical},
+		menu:      newMenuButtons(),
+
+		searchInput: widget.Editor{SingleLine: true, InputHint: key.HintText},
+		bulkTag:     widget.Editor{Submit: true, SingleLine: true, InputHint: key.HintText},
 	}
 	return ui
 }
 
 // Layout draws the app.
 func (ui *todoUI) Layout(gtx C) D {
+	// Resolve the current list. It falls back to the default list
+	// when the selected one was removed.
+	ui.todos = ui.lists.get(ui.listID)
+	ui.listID = ui.todos.id
+
 	// Set focus to the input line initially.
 	if !ui.initialFocus {
 		gtx.Execute(key.FocusCmd{Tag: &ui.mainInput})
 		ui.initialFocus = true
 	}
 
+	// Process global keys. This must happen before the editors are updated
+	// because they would consume the undo shortcut otherwise.
+	ui.processKeys(gtx)
+
 	// Process submissions.
 	for {
 		e, ok := ui.mainInput.Update(gtx)
@@ -73,10 +169,15 @@ func (ui *todoUI) Layout(gtx C) D {
 			}
 		}
 	}
-	// Process clear.
+	ui.processSearch(gtx)
+
+	// Process clear and toggle-all.
 	if ui.clear.Clicked(gtx) {
 		ui.todos.clearDone()
 	}
+	if ui.toggleAll.Clicked(gtx) {
+		ui.todos.toggleAll()
+	}
 	// Process filter selection.
 	switch {
 	case ui.all.Clicked(gtx):
@@ -85,94 +186,426 @@ func (ui *todoUI) Layout(gtx C) D {
 		ui.filter = filterActive
 	case ui.completed.Clicked(gtx):
 		ui.filter = filterCompleted
+	case ui.overdue.Clicked(gtx):
+		ui.filter = filterOverdue
+	case ui.priority.Clicked(gtx):
+		ui.filter = filterPriority
+	}
+	if ui.clearTag.Clicked(gtx) {
+		ui.tag = ""
+	}
+	// Process retry of failed changes.
+	if ui.retryBtn.Clicked(gtx) {
+		ui.lists.retry(ui.lists.failedElsewhere())
 	}
+	// Process undo snackbar.
+	if ui.undoBtn.Clicked(gtx) {
+		ui.lists.history.undo()
+		ui.snack = nil
+	}
+	ui.updateSnackbar(gtx)
+
+	// Process list switcher.
+	if ui.listSwitch.Clicked(gtx) {
+		ui.showLists = !ui.showLists
+		ui.showMenu = false
+		if !ui.showLists {
+			ui.endListEdit()
+		}
+	}
+	// Process menu.
+	if ui.menuBtn.Clicked(gtx) {
+		ui.showMenu = !ui.showMenu
+		ui.showLists = false
+		ui.endListEdit()
+	}
+	ui.processBulk(gtx)
+	ui.processTransfers(gtx)
+	ui.processPassphraseChange(gtx)
+	ui.updateNotice(gtx)
+	ui.processReminders(gtx)
 
 	// Draw.
+	if ui.showDebug {
+		return layout.Stack{Alignment: layout.NE}.Layout(gtx,
+			layout.Expanded(ui.layoutMain),
+			layout.Stacked(ui.layoutDebug),
+		)
+	}
+	return ui.layoutMain(gtx)
+}
+
+// layoutMain draws the app without overlays.
+func (ui *todoUI) layoutMain(gtx C) D {
 	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 		layout.Rigid(func(gtx C) D {
 			return ui.theme.Pad.Main.Layout(gtx, ui.layoutInput)
 		}),
+		layout.Rigid(func(gtx C) D {
+			return showIf(len(ui.banner.reminders) > 0, gtx, func(gtx C) D {
+				return ui.theme.Pad.Main.Layout(gtx, ui.layoutBanner)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			return showIf(ui.showSearch, gtx, func(gtx C) D {
+				return ui.theme.Pad.Main.Layout(gtx, ui.layoutSearch)
+			})
+		}),
 		layout.Flexed(1.0, func(gtx C) D {
+			if ui.showMenu {
+				return ui.layoutMenu(gtx)
+			}
+			if ui.showLists {
+				return ui.layoutLists(gtx)
+			}
 			return ui.layoutItems(gtx)
 		}),
+		layout.Rigid(func(gtx C) D {
+			return showIf(ui.selected.len() > 0, gtx, func(gtx C) D {
+				return ui.theme.Pad.Main.Layout(gtx, ui.layoutBulkBar)
+			})
+		}),
 		layout.Rigid(func(gtx C) D {
 			return ui.theme.Pad.Main.Layout(gtx, ui.layoutStatusBar)
 		}),
 	)
 }
 
-// layoutInput draws the main input line.
+// layoutInput draws the main input line, list switcher and menu button.
 func (ui *todoUI) layoutInput(gtx C) D {
-	ed := ui.theme.Editor(&ui.mainInput, "What needs to be done?")
-	return ed.Layout(gtx)
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			sw := ui.theme.StatusButton(&ui.listSwitch, ui.todos.name+" ▼", ui.showLists)
+			return layout.Inset{Right: ui.theme.Pad.Main.Right}.Layout(gtx, sw.Layout)
+		}),
+		layout.Rigid(func(gtx C) D {
+			n := ui.todos.len()
+			toggle := ui.theme.ToggleAll(&ui.toggleAll, n > 0 && ui.todos.doneCount() == n)
+			return showIf(n > 0, gtx, func(gtx C) D {
+				return layout.Inset{Right: ui.theme.Pad.Main.Right}.Layout(gtx, toggle.Layout)
+			})
+		}),
+		layout.Flexed(1.0, func(gtx C) D {
+			ed := ui.theme.Editor(&ui.mainInput, "What needs to be done?")
+			return ed.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx C) D {
+			menu := ui.theme.StatusButton(&ui.menuBtn, "Menu", ui.showMenu)
+			return layout.Inset{Left: ui.theme.Pad.Main.Left}.Layout(gtx, menu.Layout)
+		}),
+	)
 }
 
 // layoutItems draws the current items.
 func (ui *todoUI) layoutItems(gtx C) D {
-	items := ui.todos.filteredItems(ui.filter)
+	items := ui.todos.filteredItems(ui.filter, ui.tag, ui.query)
+	ui.processItemKeys(gtx, items)
 
-	// Process other item actions.
-	for _, item := range items {
-		if doubleClicked(&item.click, gtx) {
+	// Process other item actions. Only the items drawn in the last frame can have
+	// input events, so the others are skipped. This keeps the cost of a frame
+	// independent of the number of items.
+	for _, item := range ui.shown {
+		if searchItem(items, item) < 0 {
+			continue // no longer shown
+		}
+		// Shift-click selects a range of items, Ctrl-click adds or removes an item.
+		// Clicks also give keyboard focus to the list.
+		n, mods := clickCount(&item.click, gtx)
+		if n > 0 {
+			gtx.Execute(key.FocusCmd{Tag: &ui.list})
+		}
+		switch {
+		case n == 0:
+		case mods.Contain(key.ModShift):
+			ui.selected.extend(items, item)
+		case mods.Contain(key.ModShortcut):
+			ui.selected.toggle(item)
+		case n >= 2:
 			ui.startItemEdit(gtx, item)
+		default:
+			ui.focusItem = item
+			ui.selected.clear()
 		}
 		if item.done.Update(gtx) {
-			ui.todos.itemUpdated(item)
+			ui.todos.setDone(item, item.done.Value)
+		}
+		if item.expand.Clicked(gtx) {
+			ui.todos.toggleCollapsed(item)
 		}
 		if item.remove.Clicked(gtx) {
 			ui.todos.remove(item)
 		}
+		if item.retry.Clicked(gtx) {
+			ui.lists.retry(ui.lists.failedFor(item.id))
+		}
+		for i := range item.tagBtn {
+			if item.tagBtn[i].Clicked(gtx) {
+				ui.tag = item.tags[i]
+			}
+		}
 	}
 
 	if ui.itemBeingEdited != nil {
-		// Item editing should end (and the item be updated) when itemEditor loses focus.
-		// Requesting focus uses events, and a simple check for Focused(itemEditor) returning
-		// false doesn't work: it would also trigger when editing just started but focus
-		// hasn't been granted yet. To make it work, we call endItemEdit only when focus is
-		// not being requested.
-		foc := gtx.Focused(&ui.itemEditor)
-		switch {
-		case foc && ui.editFocusRequested:
-			ui.editFocusRequested = false
-		case !foc && !ui.editFocusRequested:
-			ui.endItemEdit()
-		}
-		// Submit events also end the edit operation.
-		for {
-			e, ok := ui.itemEditor.Update(gtx)
-			if !ok {
-				break
-			}
-			switch e.(type) {
-			case widget.SubmitEvent:
-				ui.endItemEdit()
-			}
+		ui.processItemEdit(gtx)
+	}
+
+	// Process dragging.
+	for _, item := range ui.shown {
+		if i := searchItem(items, item); i >= 0 {
+			ui.updateDrag(gtx, items, i)
 		}
 	}
 
 	// Draw the list.
+	ui.shown = ui.shown[:0]
 	return ui.list.Layout(gtx, len(items), func(gtx C, i int) D {
 		item := items[i]
+		ui.shown = append(ui.shown, item)
 		var e *widget.Editor
 		if item == ui.itemBeingEdited {
 			e = &ui.itemEditor
 		}
 		w := ui.theme.Item(item, e)
-		return w.Layout(gtx)
+		if e == nil && ui.query != "" {
+			w.label.Highlight = search.Find(item.text, ui.query)
+		}
+		w.Focused = item == ui.focusItem
+		w.Selected = ui.selected.has(item)
+		if failed := ui.lists.failedFor(item.id); len(failed) > 0 {
+			w.WriteError = failed[len(failed)-1].res.Err
+		}
+		if ui.dragItem != nil {
+			w.Dragged = item == ui.dragItem
+			w.DropBefore = ui.dropGap == i
+			w.DropAfter = ui.dropGap == len(items) && i == len(items)-1
+		}
+		dim := w.Layout(gtx)
+		item.height = dim.Size.Y
+		return dim
 	})
 }
 
-func doubleClicked(c *widget.Clickable, gtx C) (clicked bool) {
+// clickCount returns the highest click count of all clicks that happened, and the
+// modifiers of the last click.
+func clickCount(c *widget.Clickable, gtx C) (n int, mods key.Modifiers) {
 	for {
 		cl, ok := c.Update(gtx)
 		if !ok {
 			break
 		}
-		if cl.NumClicks >= 2 {
-			clicked = true
+		if cl.NumClicks > n {
+			n = cl.NumClicks
+		}
+		mods = cl.Modifiers
+	}
+	return n, mods
+}
+
+// updateDrag processes drag events of items[i].
+func (ui *todoUI) updateDrag(gtx C, items []*item, i int) {
+	it := items[i]
+	for {
+		e, ok := it.drag.Update(gtx.Metric, gtx.Source, gesture.Vertical)
+		if !ok {
+			break
+		}
+		switch e.Kind {
+		case pointer.Press:
+			ui.dragStart = e.Position.Y
+		case pointer.Drag:
+			// Dragging starts when the pointer was grabbed, i.e. when it has
+			// moved far enough to not be a click.
+			if e.Priority == pointer.Grabbed {
+				ui.dragItem = it
+				ui.dragOffset = e.Position.Y - ui.dragStart
+				ui.dropGap = dropGap(items, i, ui.dragOffset)
+			}
+		case pointer.Release:
+			if ui.dragItem == it && ui.dropGap != i && ui.dropGap != i+1 {
+				ui.todos.moveToGap(it, items, ui.dropGap)
+			}
+			ui.dragItem = nil
+		case pointer.Cancel:
+			ui.dragItem = nil
+		}
+	}
+}
+
+// dropGap computes the drop position when items[i] is dragged by offset pixels.
+// The result is the index of the item in front of which the dragged item should be
+// placed, or len(items) to place it at the end.
+func dropGap(items []*item, i int, offset float32) int {
+	h := float32(items[i].height)
+	height := func(it *item) float32 {
+		if it.height == 0 {
+			return h // not laid out recently, estimate
+		}
+		return float32(it.height)
+	}
+
+	// Track the center of the dragged item relative to its original top edge.
+	center := offset + h/2
+	if offset > 0 {
+		y, gap := h, i+1
+		for ; gap < len(items); gap++ {
+			hk := height(items[gap])
+			if center < y+hk/2 {
+				break
+			}
+			y += hk
+		}
+		return gap
+	}
+	y, gap := float32(0), i
+	for ; gap > 0; gap-- {
+		hk := height(items[gap-1])
+		if center > y-hk/2 {
+			break
+		}
+		y -= hk
+	}
+	return gap
+}
+
+// processKeys handles global key events.
+func (ui *todoUI) processKeys(gtx C) {
+	event.Op(gtx.Ops, ui)
+	filters := []event.Filter{
+		key.Filter{Name: key.NameUpArrow, Required: key.ModAlt},
+		key.Filter{Name: key.NameDownArrow, Required: key.ModAlt},
+	}
+	// Undo is left to the editors while they contain text.
+	editing := ui.itemBeingEdited != nil || ui.listBeingEdited != nil
+	// The same goes for selecting all items.
+	if !editing && ui.mainInput.Len() == 0 && ui.listInput.Len() == 0 {
+		filters = append(filters,
+			key.Filter{Name: "Z", Required: key.ModShortcut, Optional: key.ModShift},
+			key.Filter{Name: "A", Required: key.ModShortcut},
+		)
+	}
+	filters = append(filters,
+		key.Filter{Name: "F", Required: key.ModShortcut},
+		key.Filter{Name: "D", Required: key.ModShortcut | key.ModShift},
+	)
+	if ui.showSearch || ui.selected.len() > 0 {
+		filters = append(filters, key.Filter{Name: key.NameEscape})
+	}
+	for {
+		e, ok := gtx.Event(filters...)
+		if !ok {
+			break
+		}
+		if e, ok := e.(key.Event); ok && e.State == key.Press {
+			ui.handleKey(e)
+		}
+	}
+}
+
+// handleKey handles a global key event.
+func (ui *todoUI) handleKey(e key.Event) {
+	switch e.Name {
+	case "F":
+		ui.showSearch = true
+		ui.searchFocusRequested = true
+		return
+	case key.NameEscape:
+		// The selection is cleared first.
+		if ui.selected.len() > 0 {
+			ui.selected.clear()
+		} else {
+			ui.closeSearch()
+		}
+		return
+	case "A":
+		ui.selected.selectAll(ui.todos.filteredItems(ui.filter, ui.tag, ui.query))
+		return
+	case "D":
+		ui.showDebug = !ui.showDebug && ui.stats != nil
+		return
+	}
+	if e.Name == "Z" {
+		if e.Modifiers.Contain(key.ModShift) {
+			ui.lists.history.redo()
+		} else {
+			ui.lists.history.undo()
+		}
+		ui.snack = nil
+		return
+	}
+
+	it := ui.focusItem
+	if it == nil || it.list != ui.todos || ui.todos.items[it.id] == nil {
+		return
+	}
+	items := ui.todos.filteredItems(ui.filter, ui.tag, ui.query)
+	switch e.Name {
+	case key.NameUpArrow:
+		ui.todos.moveBy(it, items, -1)
+	case key.NameDownArrow:
+		ui.todos.moveBy(it, items, +1)
+	}
+}
+
+// processSearch updates the search query.
+func (ui *todoUI) processSearch(gtx C) {
+	if ui.closeSearchBtn.Clicked(gtx) {
+		ui.closeSearch()
+	}
+	if ui.searchFocusRequested {
+		gtx.Execute(key.FocusCmd{Tag: &ui.searchInput})
+		ui.searchInput.SetCaret(ui.searchInput.Len(), 0)
+		ui.searchFocusRequested = false
+	}
+	for {
+		e, ok := ui.searchInput.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := e.(widget.ChangeEvent); ok {
+			ui.query = search.Fold(strings.TrimSpace(ui.searchInput.Text()))
+		}
+	}
+}
+
+// closeSearch hides the search box and shows all items again.
+func (ui *todoUI) closeSearch() {
+	ui.showSearch = false
+	ui.searchInput.SetText("")
+	ui.query = ""
+	ui.initialFocus = false // focus the main input
+}
+
+// layoutSearch draws the search box.
+func (ui *todoUI) layoutSearch(gtx C) D {
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		layout.Flexed(1.0, func(gtx C) D {
+			ed := ui.theme.Editor(&ui.searchInput, "Search")
+			return ed.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx C) D {
+			btn := ui.theme.StatusButton(&ui.closeSearchBtn, "×", false)
+			return btn.Layout(gtx)
+		}),
+	)
+}
+
+// updateSnackbar shows the undo snackbar after destructive actions.
+func (ui *todoUI) updateSnackbar(gtx C) {
+	h := &ui.lists.history
+	if h.seq != ui.snackSeq {
+		ui.snackSeq = h.seq
+		if a := h.last(); a != nil && a.destructive {
+			ui.snack = a
+			ui.snackUntil = gtx.Now.Add(snackbarTimeout)
+		}
+	}
+	if ui.snack != nil {
+		if gtx.Now.After(ui.snackUntil) || h.last() != ui.snack {
+			ui.snack = nil
+		} else {
+			gtx.Execute(op.InvalidateCmd{At: ui.snackUntil})
 		}
 	}
-	return clicked
 }
 
 // layoutStatusBar draws the status bar at the bottom.
@@ -186,10 +619,26 @@ func (ui *todoUI) layoutStatusBar(gtx C) D {
 	}
 	return flex.Layout(gtx,
 		layout.Rigid(func(gtx C) D {
+			if ui.snack != nil {
+				return ui.layoutSnackbar(gtx)
+			}
+			if failed := ui.lists.failedElsewhere(); len(failed) > 0 {
+				return ui.layoutWriteErrors(gtx, failed)
+			}
 			label := ui.theme.StatusLabel("")
-			if ui.todos.lastError != nil {
-				label.Text = ui.todos.lastError.Error()
+			if ui.lists.lastError != nil {
+				label.Text = ui.lists.lastError.Error()
 				label.Color = ui.theme.Color.Error
+			} else if ui.notice != "" {
+				label.Text = ui.notice
+				if ui.noticeErr {
+					label.Color = ui.theme.Color.Error
+				}
+			} else if ui.lists.syncError != nil {
+				label.Text = "Sync failed: " + ui.lists.syncError.Error()
+				label.Color = ui.theme.Color.Error
+			} else if ui.query != "" {
+				label.Text = fmt.Sprintf("%d found.", len(ui.todos.filteredItems(ui.filter, ui.tag, ui.query)))
 			} else {
 				if ui.filter == filterCompleted {
 					label.Text = fmt.Sprintf("%d done.", doneCount)
@@ -204,11 +653,22 @@ func (ui *todoUI) layoutStatusBar(gtx C) D {
 			all := ui.theme.StatusButton(&ui.all, "All", ui.filter == filterAll)
 			active := ui.theme.StatusButton(&ui.active, "Active", ui.filter == filterActive)
 			completed := ui.theme.StatusButton(&ui.completed, "Done", ui.filter == filterCompleted)
+			overdue := ui.theme.StatusButton(&ui.overdue, "Overdue", ui.filter == filterOverdue)
+			priority := ui.theme.StatusButton(&ui.priority, "!", ui.filter == filterPriority)
+			tag := ui.theme.StatusButton(&ui.clearTag, "#"+ui.tag+" ×", true)
 			flex := layout.Flex{Alignment: layout.Baseline, Spacing: layout.SpaceSides}
 			return flex.Layout(gtx,
 				layout.Rigid(all.Layout),
 				layout.Rigid(active.Layout),
 				layout.Rigid(completed.Layout),
+				layout.Rigid(overdue.Layout),
+				layout.Rigid(priority.Layout),
+				layout.Rigid(func(gtx C) D {
+					if ui.tag == "" {
+						return D{}
+					}
+					return tag.Layout(gtx)
+				}),
 			)
 		}),
 		layout.Rigid(func(gtx C) D {
@@ -219,46 +679,158 @@ func (ui *todoUI) layoutStatusBar(gtx C) D {
 	)
 }
 
+// layoutWriteErrors draws failed changes which aren't shown next to an item.
+func (ui *todoUI) layoutWriteErrors(gtx C, failed []*failedWrite) D {
+	err := failed[len(failed)-1].res.Err
+	text := "Not saved: " + err.Error()
+	if len(failed) > 1 {
+		text = fmt.Sprintf("%d changes not saved: %v", len(failed), err)
+	}
+	label := ui.theme.StatusLabel(text)
+	label.Color = ui.theme.Color.Error
+	retry := ui.theme.StatusButton(&ui.retryBtn, "Retry", true)
+	return layout.Flex{Alignment: layout.Baseline}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return ui.theme.Pad.Button.Layout(gtx, label.Layout)
+		}),
+		layout.Rigid(retry.Layout),
+	)
+}
+
+// layoutSnackbar draws the undo notice in the status bar.
+func (ui *todoUI) layoutSnackbar(gtx C) D {
+	label := ui.theme.StatusLabel(ui.snack.desc + ".")
+	undo := ui.theme.StatusButton(&ui.undoBtn, "Undo", true)
+	return layout.Flex{Alignment: layout.Baseline}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return ui.theme.Pad.Button.Layout(gtx, label.Layout)
+		}),
+		layout.Rigid(undo.Layout),
+	)
+}
+
 // submit is called when a todo item is submitted.
 func (ui *todoUI) submit(line string) {
+	data := itemtext.Parse(line, time.Now())
+	if data.Text == "" {
+		return
+	}
 	ui.mainInput.SetText("")
-	ui.todos.add(line)
+	ui.todos.add(data)
+	ui.showLists = false
 }
 
+// Items are edited in place. Editing ends when the text is submitted or the editor
+// loses focus, and the edited text is then stored. An item whose text is removed is
+// deleted. Escape cancels editing without changing the item.
+
+// startItemEdit shows the editor in place of the item text. An ongoing edit of
+// another item is ended first.
 func (ui *todoUI) startItemEdit(gtx C, item *item) {
 	if ui.itemBeingEdited == item {
 		// Already editing this item.
 		return
-	} else if ui.itemBeingEdited != nil {
-		// Cancel previous edit first.
-		ui.endItemEdit()
 	}
-
-	fmt.Println("start editing item:", item.text)
+	ui.endItemEdit()
 
 	// Configure the editor.
 	ui.itemBeingEdited = item
 	ui.itemEditor = widget.Editor{Submit: true, SingleLine: true, InputHint: key.HintText}
-	ui.itemEditor.SetText(item.text)
+	ui.itemEditor.SetText(itemtext.Format(item.data()))
 	length := ui.itemEditor.Len()
 	ui.itemEditor.SetCaret(length, length)
 	gtx.Execute(key.FocusCmd{Tag: &ui.itemEditor})
-	ui.editFocusRequested = true
+	ui.editFocused = false
+}
+
+// processItemEdit handles the events of the item editor.
+func (ui *todoUI) processItemEdit(gtx C) {
+	it := ui.itemBeingEdited
+	if it.list.items[it.id] != it {
+		// The item was removed, e.g. on another device.
+		ui.cancelItemEdit()
+		return
+	}
+	// Editing ends when itemEditor loses focus. Focus is granted in the frame after
+	// editing starts, so the editor isn't focused yet when the edit has just begun.
+	if gtx.Focused(&ui.itemEditor) {
+		ui.editFocused = true
+	} else if ui.editFocused {
+		ui.endItemEdit()
+		return
+	}
+
+	// Tab and Shift+Tab change the nesting of the item. Escape cancels editing.
+	filters := []event.Filter{
+		key.Filter{Focus: &ui.itemEditor, Name: key.NameTab, Optional: key.ModShift},
+		key.Filter{Focus: &ui.itemEditor, Name: key.NameEscape},
+	}
+	for ui.itemBeingEdited != nil {
+		e, ok := gtx.Event(filters...)
+		if !ok {
+			break
+		}
+		ke, ok := e.(key.Event)
+		if !ok || ke.State != key.Press {
+			continue
+		}
+		switch {
+		case ke.Name == key.NameEscape:
+			ui.cancelItemEdit()
+			gtx.Execute(key.FocusCmd{Tag: &ui.list})
+		case ke.Modifiers.Contain(key.ModShift):
+			ui.todos.outdent(it)
+		default:
+			ui.todos.indent(it)
+		}
+	}
+	// Submit events also end the edit operation.
+	for ui.itemBeingEdited != nil {
+		e, ok := ui.itemEditor.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := e.(widget.SubmitEvent); ok {
+			ui.endItemEdit()
+			gtx.Execute(key.FocusCmd{Tag: &ui.list})
+		}
+	}
 }
 
+// endItemEdit stores the edited text. When the text is empty, the item is removed.
 func (ui *todoUI) endItemEdit() {
-	if ui.itemBeingEdited == nil {
+	it := ui.itemBeingEdited
+	if it == nil {
+		return
+	}
+	ui.itemBeingEdited, ui.editFocused = nil, false
+	text := strings.TrimSpace(ui.itemEditor.Text())
+	data := itemtext.Parse(text, time.Now())
+	if data.Text == "" {
+		it.list.remove(it)
 		return
 	}
-	text := ui.itemEditor.Text()
-	fmt.Println("end editing item:", text)
-	ui.itemBeingEdited.text = text
-	ui.todos.itemUpdated(ui.itemBeingEdited)
-	ui.itemBeingEdited = nil
-	ui.editFocusRequested = true
+	// The completion state of items with subtasks is derived, so the stored one
+	// is kept.
+	data.Done = it.stored.Done
+	data.List = it.list.id
+	data.Parent = it.parent
+	it.list.updateItem(it, data)
+}
+
+// cancelItemEdit ends editing without changing the item.
+func (ui *todoUI) cancelItemEdit() {
+	ui.itemBeingEdited, ui.editFocused = nil, false
 }
 
 func main() {
+	if len(os.Args) > 1 && commands[os.Args[1]] != nil {
+		dir, err := storeDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(runCommand(dir, os.Args[1:]))
+	}
 	go func() {
 		var (
 			theme    = newTodoTheme()
@@ -278,28 +850,121 @@ func main() {
 	app.Main()
 }
 
+// storeLogger returns the logger of the store. GIOTODO_LOG selects the format and
+// level: "json" writes JSON, "debug" includes debug messages, and "json,debug" both.
+func storeLogger() *slog.Logger {
+	var (
+		opts slog.HandlerOptions
+		json bool
+	)
+	for _, v := range strings.Split(os.Getenv("GIOTODO_LOG"), ",") {
+		switch v {
+		case "json":
+			json = true
+		case "debug":
+			opts.Level = slog.LevelDebug
+		}
+	}
+	switch {
+	case json:
+		return slog.New(slog.NewJSONHandler(os.Stderr, &opts))
+	case opts.Level != nil:
+		return slog.New(slog.NewTextHandler(os.Stderr, &opts))
+	default:
+		return slog.Default()
+	}
+}
+
+// storeDurability returns the flush policy of the store. Data is flushed every
+// second by default, so a crash of the device loses at most a second of work.
+// GIOTODO_DURABILITY selects another policy.
+func storeDurability() todostore.Durability {
+	v := os.Getenv("GIOTODO_DURABILITY")
+	if v == "" {
+		return todostore.FlushPeriodic
+	}
+	d, err := todostore.ParseDurability(v)
+	if err != nil {
+		log.Printf("invalid GIOTODO_DURABILITY: %v", err)
+		return todostore.FlushPeriodic
+	}
+	return d
+}
+
+// storeDir returns the directory of the store.
+func storeDir() (string, error) {
+	datadir, err := app.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(datadir, "giotodo"), nil
+}
+
 // loop is the main loop of the app.
 func loop(w *app.Window, theme *todoTheme) error {
-	datadir, err := app.DataDir()
+	storedir, err := storeDir()
 	if err != nil {
 		return err
 	}
 
+	// Encrypted stores must be unlocked before any events are read.
+	key, destroy := unlock(w, theme, storedir)
+	if destroy != nil {
+		return destroy.Err
+	}
 	var (
-		storedir = filepath.Join(datadir, "giotodo")
-		store    = todostore.NewStore(storedir, w.Invalidate)
-		model    = newTodoModel(store)
-		ui       = newTodoUI(theme, model)
-		ops      op.Ops
+		stats = new(todostore.Stats)
+		opts  = todostore.Options{
+			Key:        key,
+			Logger:     storeLogger(),
+			Metrics:    stats,
+			Durability: storeDurability(),
+		}
+		store = todostore.Open(storedir, opts)
+		model = newTodoLists(store)
+		ui    = newTodoUI(theme, model)
+		ops   op.Ops
 	)
 	defer store.Close()
+	ui.stats = stats
+	ui.showDebug = os.Getenv("GIOTODO_DEBUG") != ""
+	ui.transfer = newFileTransfer(w, store)
+	ui.passChange = newPassphraseChange(w, store)
+
+	// Reminders are shown in the window, and as desktop notifications if possible.
+	ui.banner.notifier = new(bannerNotifier)
+	notifiers := todostore.Notifiers{ui.banner.notifier}
+	if dbus, err := notify.NewDBus("GioTodo"); err != nil {
+		log.Printf("desktop notifications disabled: %v", err)
+	} else {
+		defer dbus.Close()
+		notifiers = append(notifiers, dbus)
+	}
+	store.StartReminders(notifiers)
+
+	// Sync is enabled by setting the server URL in the environment.
+	if url := os.Getenv("GIOTODO_SYNC"); url != "" {
+		store.StartSync(url, syncInterval)
+	}
+
+	sub, snap, err := store.SubscribeSnapshot(w.Invalidate)
+	if err != nil {
+		// The store retries loading the file later, and its content then arrives
+		// as events.
+		model.lastError = err
+		sub = store.Subscribe(w.Invalidate)
+	} else {
+		model.applySnapshot(snap)
+	}
+	defer sub.Close()
 
 	for {
-		for _, e := range store.Events() {
+		for _, e := range sub.Events() {
 			model.handleStoreEvent(e)
 			w.Invalidate()
 		}
 		e := w.NextEvent()
+		ui.transfer.expl.ListenEvents(e)
 		switch e := e.(type) {
 		case app.StageEvent:
 			if e.Stage == app.StagePaused {
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gioui.org/app"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/widget"
+	"gioui.org/x/explorer"
+	"github.com/fjl/gio-demos/giotodo/internal/todoio"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+
+	. "github.com/fjl/gio-demos/internal/cd"
+)
+
+// formatNames are the names of import/export formats shown in the menu.
+var formatNames = map[todoio.Format]string{
+	todoio.TodoMVC:  "TodoMVC JSON",
+	todoio.CSV:      "CSV",
+	todoio.Markdown: "Markdown",
+	todoio.TodoTxt:  "todo.txt",
+}
+
+// menuButtons holds the clickables of the menu.
+type menuButtons struct {
+	importBtn map[todoio.Format]*widget.Clickable
+	exportBtn map[todoio.Format]*widget.Clickable
+	passInput widget.Editor
+	passBtn   widget.Clickable
+}
+
+func newMenuButtons() menuButtons {
+	m := menuButtons{
+		importBtn: make(map[todoio.Format]*widget.Clickable),
+		exportBtn: make(map[todoio.Format]*widget.Clickable),
+		passInput: widget.Editor{Submit: true, SingleLine: true, Mask: '•', InputHint: key.HintPassword},
+	}
+	for _, f := range todoio.Formats {
+		m.importBtn[f] = new(widget.Clickable)
+		m.exportBtn[f] = new(widget.Clickable)
+	}
+	return m
+}
+
+// layoutMenu draws the menu, which replaces the items while it is shown.
+func (ui *todoUI) layoutMenu(gtx C) D {
+	for _, f := range todoio.Formats {
+		if ui.menu.importBtn[f].Clicked(gtx) {
+			ui.transfer.startImport(f)
+			ui.showMenu = false
+		}
+		if ui.menu.exportBtn[f].Clicked(gtx) {
+			ui.transfer.startExport(f)
+			ui.showMenu = false
+		}
+	}
+
+	submitted := ui.menu.passBtn.Clicked(gtx)
+	for {
+		e, ok := ui.menu.passInput.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := e.(widget.SubmitEvent); ok {
+			submitted = true
+		}
+	}
+	if submitted && !ui.passChange.busy {
+		ui.passChange.start(ui.menu.passInput.Text())
+		ui.menu.passInput.SetText("")
+	}
+
+	rows := make([]layout.FlexChild, len(todoio.Formats), len(todoio.Formats)+1)
+	for i, f := range todoio.Formats {
+		var (
+			label = ui.theme.ItemLabel(formatNames[f])
+			imp   = ui.theme.Clickable(ui.menu.importBtn[f], "Import…")
+			exp   = ui.theme.Clickable(ui.menu.exportBtn[f], "Export…")
+		)
+		rows[i] = layout.Rigid(func(gtx C) D {
+			return ui.theme.Pad.MainItem.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(1, func(gtx C) D {
+						dim := ui.theme.Pad.Item.Layout(gtx, label.Layout)
+						dim.Size.X = gtx.Constraints.Max.X
+						return dim
+					}),
+					layout.Rigid(imp.Layout),
+					layout.Rigid(exp.Layout),
+				)
+			})
+		})
+	}
+	rows = append(rows, layout.Rigid(func(gtx C) D {
+		var (
+			ed  = ui.theme.Editor(&ui.menu.passInput, "New passphrase, empty to decrypt")
+			btn = ui.theme.Clickable(&ui.menu.passBtn, "Encrypt")
+		)
+		if ui.passChange.busy {
+			btn.Label.Text = "Encrypting…"
+		}
+		return ui.theme.Pad.MainItem.Layout(gtx, func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, func(gtx C) D {
+					return ui.theme.Pad.Item.Layout(gtx, ed.Layout)
+				}),
+				layout.Rigid(btn.Layout),
+			)
+		})
+	}))
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, rows...)
+}
+
+// processTransfers handles results of finished imports and exports.
+func (ui *todoUI) processTransfers(gtx C) {
+	for {
+		select {
+		case res := <-ui.transfer.results:
+			switch {
+			case errors.Is(res.err, explorer.ErrUserDecline):
+			case res.err != nil:
+				ui.setNotice(gtx, res.err.Error(), true)
+			case res.imported != nil:
+				n := ui.lists.importLists(res.imported, ui.todos.id)
+				ui.setNotice(gtx, itemsDesc("Imported", n)+".", false)
+			default:
+				ui.setNotice(gtx, itemsDesc("Exported", res.exported)+".", false)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// processPassphraseChange handles the result of changing the passphrase.
+func (ui *todoUI) processPassphraseChange(gtx C) {
+	select {
+	case err := <-ui.passChange.results:
+		ui.passChange.busy = false
+		if err != nil {
+			ui.setNotice(gtx, "Can't change passphrase: "+err.Error(), true)
+		} else {
+			ui.setNotice(gtx, "Passphrase changed.", false)
+		}
+	default:
+	}
+}
+
+// setNotice shows a message in the status bar for a while.
+func (ui *todoUI) setNotice(gtx C, msg string, isErr bool) {
+	ui.notice = msg
+	ui.noticeErr = isErr
+	ui.noticeUntil = gtx.Now.Add(snackbarTimeout)
+}
+
+// updateNotice hides the notice when it has expired.
+func (ui *todoUI) updateNotice(gtx C) {
+	if ui.notice == "" {
+		return
+	}
+	if gtx.Now.After(ui.noticeUntil) {
+		ui.notice = ""
+	} else {
+		gtx.Execute(op.InvalidateCmd{At: ui.noticeUntil})
+	}
+}
+
+// fileTransfer runs imports and exports. File dialogs block, so they run on
+// separate goroutines. Results are delivered to the UI through a channel.
+type fileTransfer struct {
+	expl       *explorer.Explorer
+	store      *todostore.Store
+	invalidate func()
+	results    chan transferResult
+}
+
+type transferResult struct {
+	imported []todoio.List
+	exported int
+	err      error
+}
+
+func newFileTransfer(w *app.Window, store *todostore.Store) *fileTransfer {
+	return &fileTransfer{
+		expl:       explorer.NewExplorer(w),
+		store:      store,
+		invalidate: w.Invalidate,
+		results:    make(chan transferResult, 1),
+	}
+}
+
+func (ft *fileTransfer) deliver(res transferResult) {
+	ft.results <- res
+	ft.invalidate()
+}
+
+// startImport asks the user for a file and reads it.
+// The items are added to the store by the UI.
+func (ft *fileTransfer) startImport(f todoio.Format) {
+	go func() {
+		r, err := ft.expl.ChooseFile(f.Ext())
+		if err != nil {
+			ft.deliver(transferResult{err: err})
+			return
+		}
+		defer r.Close()
+		lists, err := todoio.Import(r, f)
+		if err != nil {
+			err = fmt.Errorf("import failed: %v", err)
+		}
+		ft.deliver(transferResult{imported: lists, err: err})
+	}()
+}
+
+// startExport asks the user for a file and writes all lists to it.
+func (ft *fileTransfer) startExport(f todoio.Format) {
+	go func() {
+		snap, err := ft.store.Snapshot()
+		if err != nil {
+			ft.deliver(transferResult{err: err})
+			return
+		}
+		name := "giotodo-" + time.Now().Format("2006-01-02") + f.Ext()
+		w, err := ft.expl.CreateFile(name)
+		if err != nil {
+			ft.deliver(transferResult{err: err})
+			return
+		}
+		err = todoio.Export(w, f, todoio.FromSnapshot(snap, defaultListName))
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			err = fmt.Errorf("export failed: %v", err)
+		}
+		ft.deliver(transferResult{exported: len(snap.Items), err: err})
+	}()
+}
+
+// passphraseChange re-encrypts the store with a new passphrase. This is slow, so it
+// runs on a separate goroutine.
+type passphraseChange struct {
+	store      *todostore.Store
+	invalidate func()
+	busy       bool
+	results    chan error
+}
+
+func newPassphraseChange(w *app.Window, store *todostore.Store) *passphraseChange {
+	return &passphraseChange{store: store, invalidate: w.Invalidate, results: make(chan error, 1)}
+}
+
+func (pc *passphraseChange) start(passphrase string) {
+	pc.busy = true
+	go func() {
+		pc.results <- pc.store.SetPassphrase(passphrase)
+		pc.invalidate()
+	}()
+}
@@ -4,8 +4,14 @@ import (
 	"container/list"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"time"
 
+	"gioui.org/gesture"
 	"gioui.org/widget"
+	"github.com/fjl/gio-demos/giotodo/internal/search"
+	"github.com/fjl/gio-demos/giotodo/internal/todoio"
 	"github.com/fjl/gio-demos/giotodo/internal/todostore"
 )
 
@@ -14,58 +20,240 @@ const (
 	filterAll
 	filterActive
 	filterCompleted
+	filterOverdue
+	filterPriority
 )
 
 type itemFilter int
 
+// indexedFilters are the filters which have an index in todoModel. Their result only
+// depends on the content of items, unlike the overdue filter, which depends on time.
+var indexedFilters = []itemFilter{filterAll, filterActive, filterCompleted, filterPriority}
+
 type item struct {
 	id   todostore.ID
+	list *todoModel
 	elem *list.Element
+	pos  todostore.Pos
 	text string
 
+	// parent is the stored parent. The effective parent is parentItem.
+	parent todostore.ID
+
+	// Optional attributes.
+	due      *time.Time
+	priority todostore.Priority
+	tags     []string
+	notes    string
+	repeat   string
+
 	// UI state.
+	stored todostore.Item // last state received from store
 	done   widget.Bool
 	remove widget.Clickable
 	click  widget.Clickable
+	drag   gesture.Drag
+	height int // measured in last frame, used for dragging
+	tagBtn []widget.Clickable
+	retry  widget.Clickable // retries failed changes
+
+	// Subtasks. These are computed by todoModel.updateTree.
+	parentItem *item
+	children   []*item
+	depth      int
+	collapsed  bool
+	expand     widget.Clickable
+
+	// State derived by todoModel. isDone is the completion state of the item, which
+	// done.Value also shows, except when the checkbox was just clicked.
+	isDone       bool
+	doneChildren int  // number of children which are done
+	order        int  // position in the depth-first order of the tree
+	hidden       bool // an ancestor is collapsed
 }
 
-type todoModel struct {
+// data returns the stored representation of the item.
+func (it *item) data() todostore.Item {
+	return todostore.Item{
+		Text:     it.text,
+		Done:     it.isDone,
+		List:     it.list.id,
+		Parent:   it.parent,
+		Due:      it.due,
+		Priority: it.priority,
+		Tags:     it.tags,
+		Notes:    it.notes,
+		Repeat:   it.repeat,
+	}
+}
+
+// setData applies the attributes of a stored item.
+func (it *item) setData(data todostore.Item) {
+	it.stored = data
+	it.text = data.Text
+	it.parent = data.Parent
+	it.due = data.Due
+	it.priority = data.Priority
+	it.tags = data.Tags
+	it.notes = data.Notes
+	it.repeat = data.Repeat
+	if len(it.tagBtn) != len(it.tags) {
+		it.tagBtn = make([]widget.Clickable, len(it.tags))
+	}
+}
+
+// overdue reports whether the item is past its due date.
+func (it *item) overdue(now time.Time) bool {
+	data := todostore.Item{Done: it.isDone, Due: it.due}
+	return data.Overdue(now)
+}
+
+// overdueAt returns the time at which the item becomes overdue, see
+// todostore.Item.OverdueAt.
+func (it *item) overdueAt() (time.Time, bool) {
+	data := todostore.Item{Done: it.isDone, Due: it.due}
+	return data.OverdueAt()
+}
+
+// hasAttributes reports whether any optional attributes are set.
+func (it *item) hasAttributes() bool {
+	return it.due != nil || it.priority > todostore.PriorityNone || len(it.tags) > 0 || it.notes != "" || it.repeat != ""
+}
+
+// searchText returns the text of an item used for search.
+func searchText(data *todostore.Item) string {
+	var b strings.Builder
+	b.WriteString(data.Text)
+	for _, tag := range data.Tags {
+		b.WriteString("\n#")
+		b.WriteString(tag)
+	}
+	if data.Notes != "" {
+		b.WriteString("\n")
+		b.WriteString(data.Notes)
+	}
+	return b.String()
+}
+
+// todoLists holds all todo lists.
+type todoLists struct {
 	store     *todostore.Store
+	lists     []*todoModel
+	byID      map[todostore.ID]*todoModel
 	items     map[todostore.ID]*item
-	all       *list.List
+	searchIx  *search.Index[todostore.ID] // search index of all items
+	history   undoHistory
 	lastError error
+	syncError error          // error of the last sync, if any
+	failed    []*failedWrite // changes which couldn't be stored
+	writer    *optimisticStore
+}
+
+// todoModel is a single todo list.
+type todoModel struct {
+	id       todostore.ID
+	name     string
+	store    *optimisticStore
+	history  *undoHistory
+	items    map[todostore.ID]*item
+	all      *list.List
+	searchIx *search.Index[todostore.ID]
+
+	// The tree of items is computed from the stored parents when needed.
+	roots     []*item
+	treeValid bool
+	missing   map[todostore.ID]bool // stored parents which aren't in the list
+	nextOrder int                   // order of the next item added at the end
+
+	// The number of done items, and the indexes of the shown items matching each of
+	// the indexedFilters, in tree order. They are computed with the tree and kept up
+	// to date when items change, so that drawing a frame doesn't need to look at all
+	// items. The index slices are replaced, not modified, when they change.
+	ndone int
+	index map[itemFilter][]*item
 
-	// This is the cache for filteredItems.
-	cachedList       []*item
-	cachedListFilter itemFilter
+	// version is incremented on every change of the items.
+	version uint64
+
+	// This is the cache for filteredItems with tag or query, or with the overdue
+	// filter. The overdue result also expires at cachedListUntil, when the next
+	// item becomes overdue.
+	cachedList        []*item
+	cachedListFilter  itemFilter
+	cachedListTag     string
+	cachedListQuery   string
+	cachedListVersion uint64
+	cachedListUntil   time.Time
+
+	// UI state.
+	btn listButtons
 }
 
-func newTodoModel(store *todostore.Store) *todoModel {
+// listButtons holds the clickables of a row in the list manager, and the button
+// which moves selected items to the list.
+type listButtons struct {
+	click  widget.Clickable
+	up     widget.Clickable
+	down   widget.Clickable
+	rename widget.Clickable
+	remove widget.Clickable
+	move   widget.Clickable
+}
+
+// defaultListName is the name of todostore.DefaultList.
+const defaultListName = "Todo"
+
+func newTodoLists(store *todostore.Store) *todoLists {
+	m := &todoLists{
+		store:    store,
+		byID:     make(map[todostore.ID]*todoModel),
+		items:    make(map[todostore.ID]*item),
+		searchIx: search.NewIndex[todostore.ID](),
+	}
+	m.writer = newOptimisticStore(store, m)
+	m.insertList(todostore.DefaultList, defaultListName)
+	return m
+}
+
+func newTodoModel(store *optimisticStore, history *undoHistory, id todostore.ID, name string) *todoModel {
 	return &todoModel{
-		store: store,
-		all:   list.New(),
-		items: make(map[todostore.ID]*item),
+		id:      id,
+		name:    name,
+		store:   store,
+		history: history,
+		all:     list.New(),
+		items:   make(map[todostore.ID]*item),
 	}
 }
 
-func (m *todoModel) handleStoreEvent(e todostore.Event) {
+func (m *todoLists) handleStoreEvent(e todostore.Event) {
+	if e, ok := e.(*todostore.ItemRemoved); ok {
+		m.dropFailed(e.ID)
+	}
+	// Events about items with pending changes update their confirmed state.
+	if m.writer.handleItemEvent(e) {
+		return
+	}
+
 	switch e := e.(type) {
 	case *todostore.ItemAdded:
-		it := &item{id: e.ID, text: e.Item.Text}
-		it.done.Value = e.Item.Done
-		it.elem = m.all.PushBack(it)
-		m.items[e.ID] = it
-		if m.cachedListFilter.match(it) {
-			m.cachedList = append(m.cachedList, it)
+		m.addItem(&item{id: e.ID, pos: e.Pos}, e.Item)
+
+	case *todostore.ItemMoved:
+		it := m.items[e.ID]
+		if it == nil {
+			log.Println("ignoring ItemMoved event for deleted item " + e.ID)
+			return
 		}
+		m.setPos(it, e.Pos)
 
 	case *todostore.ItemRemoved:
 		it := m.items[e.ID]
-		m.all.Remove(it.elem)
-		delete(m.items, e.ID)
-		if m.cachedListFilter.match(it) {
-			m.cachedListFilter = filterInvalid
+		if it == nil {
+			log.Println("ignoring ItemRemoved event for deleted item " + e.ID)
+			return
 		}
+		m.deleteItem(it)
 
 	case *todostore.ItemChanged:
 		it := m.items[e.ID]
@@ -73,88 +261,1055 @@ func (m *todoModel) handleStoreEvent(e todostore.Event) {
 			log.Println("ignoring ItemChanged event for deleted item " + e.ID)
 			return
 		}
-		it.done.Value = e.Item.Done
-		it.text = e.Item.Text
-		m.cachedListFilter = filterInvalid
+		m.changeItem(it, e.Item)
+
+	case *todostore.ListAdded:
+		if m.byID[e.ID] != nil {
+			log.Println("ignoring ListAdded event for existing list " + e.ID)
+			return
+		}
+		m.insertList(e.ID, e.Name)
+
+	case *todostore.ListChanged:
+		if l := m.byID[e.ID]; l != nil {
+			l.name = e.Name
+		}
+
+	case *todostore.ListRemoved:
+		m.removeList(e.ID)
+
+	case *todostore.ListsReordered:
+		m.reorder(e.Order)
 
 	case *todostore.IOError:
 		m.lastError = e.Err
+
+	case *todostore.WriteResult:
+		// Failed changes are rolled back.
+		ev := m.writer.handleResult(e)
+		if e.Err != nil {
+			m.failed = append(m.failed, &failedWrite{res: e, ev: ev})
+		} else {
+			// Writing works again, so earlier errors are outdated.
+			m.lastError = nil
+		}
+
+	case *todostore.SyncStatus:
+		m.syncError = e.Err
+	}
+}
+
+// failedFor returns the failed changes of an item or list.
+func (m *todoLists) failedFor(id todostore.ID) []*failedWrite {
+	var fs []*failedWrite
+	for _, f := range m.failed {
+		if f.res.Target == id {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// failedElsewhere returns the failed changes which aren't shown next to an item.
+func (m *todoLists) failedElsewhere() []*failedWrite {
+	var fs []*failedWrite
+	for _, f := range m.failed {
+		if m.items[f.res.Target] == nil {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// retry requests failed changes again. When they fail again, they are reported by
+// another WriteResult.
+func (m *todoLists) retry(fs []*failedWrite) {
+	retried := make(map[*failedWrite]bool, len(fs))
+	for _, f := range fs {
+		req := m.store.Retry(f.res)
+		if f.ev != nil {
+			m.writer.send(req, f.ev)
+		}
+		retried[f] = true
+	}
+	m.removeFailed(func(f *failedWrite) bool { return retried[f] })
+}
+
+// dropFailed forgets the failed changes of a removed item.
+func (m *todoLists) dropFailed(id todostore.ID) {
+	m.removeFailed(func(f *failedWrite) bool { return f.res.Target == id })
+}
+
+func (m *todoLists) removeFailed(match func(*failedWrite) bool) {
+	rest := m.failed[:0]
+	for _, f := range m.failed {
+		if !match(f) {
+			rest = append(rest, f)
+		}
+	}
+	m.failed = rest
+}
+
+// applySnapshot loads the initial content of the store.
+func (m *todoLists) applySnapshot(snap *todostore.Snapshot) {
+	order := make([]todostore.ID, len(snap.Lists))
+	for i, l := range snap.Lists {
+		order[i] = l.ID
+		if l.ID != todostore.DefaultList {
+			m.handleStoreEvent(&todostore.ListAdded{ID: l.ID, Name: l.Name})
+		}
+	}
+	m.handleStoreEvent(&todostore.ListsReordered{Order: order})
+	for _, info := range snap.Items {
+		m.handleStoreEvent(&todostore.ItemAdded{ID: info.ID, Item: info.Item, Pos: info.Pos})
+	}
+}
+
+// addItem inserts a new item into its list.
+func (m *todoLists) addItem(it *item, data todostore.Item) {
+	l := m.byID[data.List]
+	if l == nil {
+		log.Println("ignoring item " + it.id + " of unknown list " + data.List)
+		return
+	}
+	it.setData(data)
+	m.searchIx.Set(it.id, searchText(&data))
+	l.insert(it)
+	m.items[it.id] = it
+}
+
+// changeItem sets the content of an item.
+func (m *todoLists) changeItem(it *item, data todostore.Item) {
+	old := it.stored
+	it.setData(data)
+	m.searchIx.Set(it.id, searchText(&data))
+	if data.List != it.list.id {
+		m.moveItem(it, data.List)
+	} else {
+		it.list.changed(it, &old)
+	}
+}
+
+// setPos changes the position of an item in its list.
+func (m *todoLists) setPos(it *item, pos todostore.Pos) {
+	l := it.list
+	l.delete(it)
+	it.pos = pos
+	l.insert(it)
+}
+
+// deleteItem removes an item.
+func (m *todoLists) deleteItem(it *item) {
+	it.list.delete(it)
+	delete(m.items, it.id)
+	m.searchIx.Remove(it.id)
+}
+
+func (m *todoLists) insertList(id todostore.ID, name string) {
+	l := newTodoModel(m.writer, &m.history, id, name)
+	l.searchIx = m.searchIx
+	m.lists = append(m.lists, l)
+	m.byID[id] = l
+}
+
+// removeList deletes a list and its items from the model. The store keeps the items,
+// see todostore.ListRemoved.
+func (m *todoLists) removeList(id todostore.ID) {
+	l := m.byID[id]
+	if l == nil || id == todostore.DefaultList {
+		return
+	}
+	for itemID := range l.items {
+		delete(m.items, itemID)
+		m.searchIx.Remove(itemID)
+	}
+	delete(m.byID, id)
+	for i := range m.lists {
+		if m.lists[i] == l {
+			m.lists = append(m.lists[:i], m.lists[i+1:]...)
+			break
+		}
+	}
+}
+
+// moveItem moves an item to the end of another list.
+// If the target list doesn't exist, the item is deleted.
+func (m *todoLists) moveItem(it *item, to todostore.ID) {
+	it.list.delete(it)
+	if l := m.byID[to]; l != nil {
+		it.pos = ""
+		l.insert(it)
+	} else {
+		delete(m.items, it.id)
+		m.searchIx.Remove(it.id)
+	}
+}
+
+// reorder sets the list order. Lists not contained in order keep their
+// relative position and are moved to the end.
+func (m *todoLists) reorder(order []todostore.ID) {
+	lists := make([]*todoModel, 0, len(m.lists))
+	seen := make(map[*todoModel]bool, len(m.lists))
+	for _, id := range order {
+		if l := m.byID[id]; l != nil && !seen[l] {
+			lists = append(lists, l)
+			seen[l] = true
+		}
+	}
+	for _, l := range m.lists {
+		if !seen[l] {
+			lists = append(lists, l)
+		}
+	}
+	m.lists = lists
+}
+
+// get returns the list with the given ID.
+// If there is no such list, it returns the default list.
+func (m *todoLists) get(id todostore.ID) *todoModel {
+	if l := m.byID[id]; l != nil {
+		return l
+	}
+	return m.byID[todostore.DefaultList]
+}
+
+// index returns the position of a list.
+func (m *todoLists) index(l *todoModel) int {
+	for i := range m.lists {
+		if m.lists[i] == l {
+			return i
+		}
+	}
+	return -1
+}
+
+// move changes the position of a list by delta.
+func (m *todoLists) move(l *todoModel, delta int) {
+	i := m.index(l)
+	j := i + delta
+	if i < 0 || j < 0 || j >= len(m.lists) {
+		return
+	}
+	old := m.order()
+	order := m.order()
+	order[i], order[j] = order[j], order[i]
+	m.store.ReorderLists(order)
+	m.history.record(&undoAction{
+		desc: "Moved list",
+		undo: func() { m.store.ReorderLists(old) },
+		redo: func() { m.store.ReorderLists(order) },
+	})
+}
+
+// order returns the IDs of all lists.
+func (m *todoLists) order() []todostore.ID {
+	order := make([]todostore.ID, len(m.lists))
+	for k := range m.lists {
+		order[k] = m.lists[k].id
+	}
+	return order
+}
+
+func (m *todoLists) addList(name string) {
+	id := m.store.AddList(name)
+	m.history.record(&undoAction{
+		desc: "Added list",
+		undo: func() { m.store.RemoveList(id) },
+		redo: func() { m.store.RestoreList(id, name) },
+	})
+}
+
+// lookup finds a list by name.
+func (m *todoLists) lookup(name string) (todostore.ID, bool) {
+	for _, l := range m.lists {
+		if l.name == name {
+			return l.id, true
+		}
+	}
+	return "", false
+}
+
+// importLists adds imported items. Items of unnamed lists are added to target.
+func (m *todoLists) importLists(lists []todoio.List, target todostore.ID) int {
+	added := todoio.Add(m.store, lists, target, m.lookup)
+	m.history.record(&undoAction{
+		desc: itemsDesc("Imported", len(added.Items)),
+		undo: func() {
+			removed := make([]todostore.Event, len(added.Items))
+			for i, it := range added.Items {
+				removed[i] = &todostore.ItemRemoved{ID: it.ID}
+			}
+			m.writer.Bulk(removed...)
+			for _, l := range added.Lists {
+				m.store.RemoveList(l.ID)
+			}
+		},
+		redo: func() {
+			for _, l := range added.Lists {
+				m.store.RestoreList(l.ID, l.Name)
+			}
+			// The items are placed at the end of their lists, like RestoreItem does.
+			var (
+				restored = make([]todostore.Event, len(added.Items))
+				ends     = make(map[todostore.ID]todostore.Pos)
+			)
+			for i, it := range added.Items {
+				var pos todostore.Pos
+				if last, ok := ends[it.Item.List]; ok {
+					pos = todostore.PosAfter(last)
+				} else if l := m.byID[it.Item.List]; l != nil {
+					pos = l.endPos()
+				}
+				if pos != "" {
+					ends[it.Item.List] = pos
+				}
+				restored[i] = &todostore.ItemAdded{ID: it.ID, Item: it.Item, Pos: pos}
+			}
+			m.writer.Bulk(restored...)
+		},
+	})
+	return len(added.Items)
+}
+
+func (m *todoLists) rename(l *todoModel, name string) {
+	id, old := l.id, l.name
+	m.store.RenameList(id, name)
+	m.history.record(&undoAction{
+		desc: "Renamed list",
+		undo: func() { m.store.RenameList(id, old) },
+		redo: func() { m.store.RenameList(id, name) },
+	})
+}
+
+func (m *todoLists) remove(l *todoModel) {
+	if l.id == todostore.DefaultList {
+		return
+	}
+	// Undo must restore the list, its items and the list order.
+	var (
+		id, name = l.id, l.name
+		order    = m.order()
+		saved    = l.saveItems(func(*item) bool { return true })
+	)
+	m.store.RemoveList(id)
+	m.history.record(&undoAction{
+		desc:        "Deleted list " + name,
+		destructive: true,
+		undo: func() {
+			m.store.RestoreList(id, name)
+			saved.restore(m.writer)
+			m.store.ReorderLists(order)
+		},
+		redo: func() { m.store.RemoveList(id) },
+	})
+}
+
+// insert adds an item to the list, according to its position. Items without position
+// are placed at the end and assigned a position there. Since events are always
+// replayed in the same order, this assignment is deterministic.
+func (m *todoModel) insert(it *item) {
+	it.list = m
+	last := m.all.Back()
+	if it.pos == "" {
+		it.pos = m.endPos()
+	}
+
+	// Find the insertion point, starting at the end because items
+	// are usually appended.
+	elem := last
+	for elem != nil && it.less(elem.Value.(*item)) {
+		elem = elem.Prev()
+	}
+	if elem == nil {
+		it.elem = m.all.PushFront(it)
+	} else {
+		it.elem = m.all.InsertAfter(it, elem)
+	}
+	m.items[it.id] = it
+
+	// The item may be a subtask, or the parent of existing items. Only a top-level
+	// item at the end of the list can be added without computing the tree again.
+	if !m.treeValid || it.parent != "" || it.elem.Next() != nil || m.missing[it.id] {
+		m.invalidate()
+		return
+	}
+	m.version++
+	it.parentItem, it.children, it.depth, it.hidden = nil, nil, 0, false
+	it.order = m.nextOrder
+	m.nextOrder++
+	m.roots = append(m.roots, it)
+	it.isDone, it.doneChildren = false, 0
+	m.setItemDone(it, it.stored.Done)
+	m.reindex(it)
+}
+
+// less reports whether it sorts before other. Items with equal position, which can
+// only be created by concurrent moves, are ordered by ID.
+func (it *item) less(other *item) bool {
+	if it.pos != other.pos {
+		return it.pos < other.pos
+	}
+	return it.id < other.id
+}
+
+// endPos returns a position behind all items.
+func (m *todoModel) endPos() todostore.Pos {
+	var lastPos todostore.Pos
+	if last := m.all.Back(); last != nil {
+		lastPos = last.Value.(*item).pos
+	}
+	return todostore.PosAfter(lastPos)
+}
+
+// delete removes an item from the list.
+func (m *todoModel) delete(it *item) {
+	m.all.Remove(it.elem)
+	delete(m.items, it.id)
+
+	// Items without subtasks are removed from the tree directly.
+	if !m.treeValid || len(it.children) > 0 {
+		m.invalidate()
+		return
+	}
+	m.version++
+	for _, f := range indexedFilters {
+		m.index[f] = indexSet(m.index[f], it, false)
+	}
+	if it.isDone {
+		m.ndone--
+	}
+	parent := it.parentItem
+	if parent == nil {
+		if i := searchItem(m.roots, it); i >= 0 {
+			m.roots = append(m.roots[:i], m.roots[i+1:]...)
+		}
+		return
+	}
+	if it.isDone {
+		parent.doneChildren--
+	}
+	if i := searchItem(parent.children, it); i >= 0 {
+		parent.children = append(parent.children[:i], parent.children[i+1:]...)
+	}
+	m.changed(parent, &parent.stored)
+}
+
+// invalidate marks the tree as outdated. It is computed again by updateTree, along
+// with the counters and indexes. This is used for changes of the tree structure.
+func (m *todoModel) invalidate() {
+	m.treeValid = false
+	m.version++
+}
+
+// changed updates the counters and indexes after the content of an item changed
+// from old. The completion state of its parents is derived again.
+func (m *todoModel) changed(it *item, old *todostore.Item) {
+	if !m.treeValid || old.Parent != it.parent {
+		m.invalidate()
+		return
+	}
+	m.version++
+	for p := it; p != nil; p = p.parentItem {
+		if !m.setItemDone(p, p.derivedDone()) && p != it {
+			break // parents don't change either
+		}
+		m.reindex(p)
+	}
+}
+
+// setItemDone sets the completion state of an item and updates the done counters.
+// It reports whether the state changed.
+func (m *todoModel) setItemDone(it *item, done bool) bool {
+	it.done.Value = done
+	if it.isDone == done {
+		return false
+	}
+	it.isDone = done
+	delta := 1
+	if !done {
+		delta = -1
+	}
+	m.ndone += delta
+	if it.parentItem != nil {
+		it.parentItem.doneChildren += delta
+	}
+	return true
+}
+
+// derivedDone returns the completion state of an item: an item with subtasks is done
+// when all of its subtasks are done.
+func (it *item) derivedDone() bool {
+	if len(it.children) == 0 {
+		return it.stored.Done
+	}
+	return it.doneChildren == len(it.children)
+}
+
+// reindex adds the item to the indexes of the filters it matches, and removes it
+// from the others.
+func (m *todoModel) reindex(it *item) {
+	for _, f := range indexedFilters {
+		m.index[f] = indexSet(m.index[f], it, !it.hidden && f.match(it, time.Time{}))
+	}
+}
+
+// indexSet adds or removes an item in an index, keeping the tree order. The index is
+// copied when an item is inserted or removed in the middle, because callers of
+// filteredItems may still use it.
+func indexSet(index []*item, it *item, member bool) []*item {
+	i := sort.Search(len(index), func(i int) bool { return index[i].order >= it.order })
+	found := i < len(index) && index[i] == it
+	switch {
+	case member && !found && i == len(index):
+		return append(index, it)
+	case member && !found:
+		cpy := make([]*item, len(index)+1)
+		copy(cpy, index[:i])
+		cpy[i] = it
+		copy(cpy[i+1:], index[i:])
+		return cpy
+	case !member && found:
+		cpy := make([]*item, len(index)-1)
+		copy(cpy, index[:i])
+		copy(cpy[i:], index[i+1:])
+		return cpy
+	}
+	return index
+}
+
+// searchItem returns the index of an item in items, which must be in tree order like
+// the results of filteredItems. It returns -1 when the item is nil or not contained.
+func searchItem(items []*item, it *item) int {
+	if it == nil {
+		return -1
+	}
+	i := sort.Search(len(items), func(i int) bool { return items[i].order >= it.order })
+	if i < len(items) && items[i] == it {
+		return i
+	}
+	return -1
+}
+
+// sortItems sorts items in tree order.
+func sortItems(items []*item) {
+	sort.Slice(items, func(i, j int) bool { return items[i].order < items[j].order })
+}
+
+// updateTree computes the tree of items from their stored parents. Parents which
+// don't exist in the list are ignored, as described in todostore.ResolveParents.
+// It also computes the completion state of items, the done counter and the indexes.
+func (m *todoModel) updateTree() {
+	if m.treeValid {
+		return
+	}
+	parents := make(map[todostore.ID]todostore.ID, len(m.items))
+	for id, it := range m.items {
+		parents[id] = it.parent
+	}
+	resolved := todostore.ResolveParents(parents)
+
+	for elem := m.all.Front(); elem != nil; elem = elem.Next() {
+		it := elem.Value.(*item)
+		it.children = it.children[:0]
+	}
+	m.roots = m.roots[:0]
+	m.missing = make(map[todostore.ID]bool)
+	for elem := m.all.Front(); elem != nil; elem = elem.Next() {
+		it := elem.Value.(*item)
+		it.parentItem = m.items[resolved[it.id]]
+		if it.parentItem != nil {
+			it.parentItem.children = append(it.parentItem.children, it)
+			continue
+		}
+		m.roots = append(m.roots, it)
+		if it.parent != "" && m.items[it.parent] == nil {
+			m.missing[it.parent] = true
+		}
+	}
+
+	ordered := make([]*item, 0, len(m.items))
+	for _, it := range m.roots {
+		ordered = m.updateSubtree(it, 0, false, ordered)
 	}
+	m.nextOrder = len(ordered)
+	m.ndone = 0
+	m.index = make(map[itemFilter][]*item, len(indexedFilters))
+	for _, it := range ordered {
+		if it.isDone {
+			m.ndone++
+		}
+		if it.hidden {
+			continue
+		}
+		for _, f := range indexedFilters {
+			if f.match(it, time.Time{}) {
+				m.index[f] = append(m.index[f], it)
+			}
+		}
+	}
+	m.treeValid = true
+}
+
+// updateSubtree sets the depth, order and visibility of the item and its subtasks,
+// and derives their completion state. It appends the items to ordered in tree order.
+func (m *todoModel) updateSubtree(it *item, depth int, hidden bool, ordered []*item) []*item {
+	it.depth, it.hidden = depth, hidden
+	it.order = len(ordered)
+	ordered = append(ordered, it)
+	it.doneChildren = 0
+	for _, child := range it.children {
+		ordered = m.updateSubtree(child, depth+1, hidden || it.collapsed, ordered)
+		if child.isDone {
+			it.doneChildren++
+		}
+	}
+	it.isDone = it.derivedDone()
+	it.done.Value = it.isDone
+	return ordered
+}
+
+// subtree returns the item and all its subtasks.
+func (it *item) subtree() []*item {
+	items := []*item{it}
+	for _, child := range it.children {
+		items = append(items, child.subtree()...)
+	}
+	return items
+}
+
+// toggleCollapsed shows or hides the subtasks of an item.
+func (m *todoModel) toggleCollapsed(it *item) {
+	it.collapsed = !it.collapsed
+	m.invalidate()
 }
 
 func (m *todoModel) len() int {
 	return m.all.Len()
 }
 
+// doneCount returns the number of done items.
 func (m *todoModel) doneCount() int {
-	count := 0
-	for _, it := range m.items {
-		if it.done.Value {
-			count++
-		}
-	}
-	return count
+	m.updateTree()
+	return m.ndone
 }
 
-// filteredItems returns all items that match the given filter.
-func (m *todoModel) filteredItems(filter itemFilter) []*item {
+// filteredItems returns all items that match the given filter, with subtasks
+// following their parent. Subtasks of collapsed items are left out, except when
+// searching.
+// If tag is non-empty, only items with that tag are returned.
+// If query is non-empty, only items containing the folded query are returned.
+//
+// The result must not be modified. For the indexed filters without tag and query,
+// it is the index of the filter. Other results are computed when needed and cached
+// until the list changes.
+func (m *todoModel) filteredItems(filter itemFilter, tag, query string) []*item {
 	if filter == filterInvalid {
 		panic("filteredItems(filterInvalid)")
 	}
-	if filter == m.cachedListFilter {
+	m.updateTree()
+	if tag == "" && query == "" && filter != filterOverdue {
+		return m.index[filter]
+	}
+	now := time.Now()
+	expired := !m.cachedListUntil.IsZero() && !now.Before(m.cachedListUntil)
+	if filter == m.cachedListFilter && tag == m.cachedListTag && query == m.cachedListQuery && m.version == m.cachedListVersion && !expired {
 		return m.cachedList // unchanged
 	}
 
-	m.cachedList = m.cachedList[:0]
+	m.cachedList = nil
 	m.cachedListFilter = filter
-	for elem := m.all.Front(); elem != nil; elem = elem.Next() {
-		it := elem.Value.(*item)
-		if filter.match(it) {
+	m.cachedListTag = tag
+	m.cachedListQuery = query
+	m.cachedListVersion = m.version
+	m.cachedListUntil = time.Time{}
+	items := m.index[filterAll]
+	if query != "" {
+		// Search results include the subtasks of collapsed items.
+		items = nil
+		for id := range m.searchIx.Search(query) {
+			if it := m.items[id]; it != nil {
+				items = append(items, it)
+			}
+		}
+		sortItems(items)
+	}
+	for _, it := range items {
+		if m.tagFilterMatch(it, now) {
 			m.cachedList = append(m.cachedList, it)
+		} else if filter == filterOverdue {
+			m.updateExpiry(it, now)
 		}
 	}
 	return m.cachedList
 }
 
-// match tells whether an item matches the filter.
-func (f itemFilter) match(it *item) bool {
+// updateExpiry moves the expiry of the cached overdue items to the time when the
+// item becomes overdue, if it is earlier.
+func (m *todoModel) updateExpiry(it *item, now time.Time) {
+	at, ok := it.overdueAt()
+	if ok && now.Before(at) && (m.cachedListUntil.IsZero() || at.Before(m.cachedListUntil)) {
+		m.cachedListUntil = at
+	}
+}
+
+// tagFilterMatch tells whether an item matches the cached tag and filter.
+func (m *todoModel) tagFilterMatch(it *item, now time.Time) bool {
+	if m.cachedListTag != "" && !it.stored.HasTag(m.cachedListTag) {
+		return false
+	}
+	return m.cachedListFilter.match(it, now)
+}
+
+// match tells whether an item matches the filter. The time is used by the overdue
+// filter only.
+func (f itemFilter) match(it *item, now time.Time) bool {
 	switch f {
 	case filterInvalid:
 		return false
 	case filterAll:
 		return true
 	case filterActive:
-		return !it.done.Value
+		return !it.isDone
 	case filterCompleted:
-		return it.done.Value
+		return it.isDone
+	case filterOverdue:
+		return it.overdue(now)
+	case filterPriority:
+		return it.priority > todostore.PriorityNone
 	default:
 		panic(fmt.Errorf("invalid filter %d", f))
 	}
 }
 
-func (m *todoModel) itemUpdated(it *item) {
+// updateItem changes the content of an item to data. The item is updated when the
+// change is applied to the model.
+func (m *todoModel) updateItem(it *item, data todostore.Item) {
 	if m.items[it.id] == nil {
 		log.Println("ignoring update of deleted item " + it.id)
 		return
 	}
-	m.store.UpdateItem(it.id, todostore.Item{
-		Text: it.text,
-		Done: it.done.Value,
+	id, old := it.id, it.stored
+	// Only the fields edited by the user are sent, so that concurrent changes
+	// of other fields on another device are kept. The same goes for undo.
+	fields := todostore.ChangedFields(&old, &data)
+	if len(fields) == 0 {
+		return // nothing changed
+	}
+	m.store.UpdateItem(id, data, fields...)
+	occurrence := m.newOccurrence(id, old, data)
+	m.history.record(&undoAction{
+		desc: "Changed item",
+		undo: func() {
+			m.store.UpdateItem(id, old, fields...)
+			m.removeOccurrences(occurrence)
+		},
+		redo: func() { m.store.UpdateItem(id, data, fields...) },
+	})
+}
+
+// newOccurrence returns the ID of the occurrence which the store adds when an item
+// changes from old to data, i.e. when a recurring item is completed. It returns the
+// empty ID when no occurrence is added.
+func (m *todoModel) newOccurrence(id todostore.ID, old, data todostore.Item) todostore.ID {
+	if old.Done || !data.Done {
+		return ""
+	}
+	next, _, ok := todostore.NextOccurrence(id, data)
+	if !ok || m.items[next] != nil {
+		return ""
+	}
+	return next
+}
+
+// removeOccurrences removes occurrences added by the store. Undo uses this when it
+// marks recurring items as not done again. Redo doesn't need to restore them, since
+// the store adds them again when the items are completed.
+func (m *todoModel) removeOccurrences(ids ...todostore.ID) {
+	for _, id := range ids {
+		if id != "" {
+			m.store.RemoveItem(id)
+		}
+	}
+}
+
+// setDone marks an item and its subtasks as done or not done.
+func (m *todoModel) setDone(it *item, done bool) {
+	m.updateTree()
+	if len(it.children) == 0 {
+		data := it.stored
+		data.Done = done
+		m.updateItem(it, data)
+		return
+	}
+	m.setDoneItems(it.subtree(), done, "Changed")
+}
+
+// setDoneItems marks the given items as done or not done, as one bulk change. The
+// verb describes the action for undo.
+func (m *todoModel) setDoneItems(items []*item, done bool, verb string) {
+	var changes, inverse []todostore.Event
+	for _, it := range items {
+		if it.stored.Done == done {
+			continue
+		}
+		changes = append(changes, &todostore.ItemChanged{ID: it.id, Item: todostore.Item{Done: done}, Fields: []todostore.Field{todostore.FieldDone}})
+		inverse = append(inverse, &todostore.ItemChanged{ID: it.id, Item: todostore.Item{Done: it.stored.Done}, Fields: []todostore.Field{todostore.FieldDone}})
+		data := it.stored
+		data.Done = done
+		// Occurrences added by the store are removed by undo. Redo doesn't need to
+		// restore them, see removeOccurrences.
+		if occ := m.newOccurrence(it.id, it.stored, data); occ != "" {
+			inverse = append(inverse, &todostore.ItemRemoved{ID: occ})
+		}
+	}
+	m.bulk(itemsDesc(verb, len(changes)), false, changes, inverse)
+}
+
+// bulk sends changes of several items as one bulk change, and records the action.
+// Undo sends the inverse changes, also as one bulk change.
+func (m *todoModel) bulk(desc string, destructive bool, changes, inverse []todostore.Event) {
+	if len(changes) == 0 {
+		return
+	}
+	m.store.Bulk(changes...)
+	m.history.record(&undoAction{
+		desc:        desc,
+		destructive: destructive,
+		undo:        func() { m.store.Bulk(inverse...) },
+		redo:        func() { m.store.Bulk(changes...) },
+	})
+}
+
+// indent makes an item the last subtask of the item in front of it.
+func (m *todoModel) indent(it *item) {
+	m.updateTree()
+	siblings := m.roots
+	if it.parentItem != nil {
+		siblings = it.parentItem.children
+	}
+	i := indexOf(siblings, it)
+	if i <= 0 {
+		return // no item in front
+	}
+	parent := siblings[i-1]
+	var after *item
+	if n := len(parent.children); n > 0 && it.less(parent.children[n-1]) {
+		after = parent.children[n-1]
+	}
+	m.setParent(it, parent, after, "Indented item")
+}
+
+// outdent moves a subtask up one level, placing it behind its former parent.
+func (m *todoModel) outdent(it *item) {
+	m.updateTree()
+	if it.parentItem == nil {
+		return
+	}
+	m.setParent(it, it.parentItem.parentItem, it.parentItem, "Outdented item")
+}
+
+// setParent changes the parent of an item. When after is non-nil, the item is also
+// moved behind it.
+func (m *todoModel) setParent(it, parent, after *item, desc string) {
+	id, oldParent, oldPos := it.id, it.parent, it.pos
+	var newParent todostore.ID
+	if parent != nil {
+		newParent = parent.id
+	}
+	newPos := oldPos
+	if after != nil {
+		newPos = m.posAfter(after)
+	}
+	apply := func(parent todostore.ID, pos todostore.Pos) {
+		m.store.UpdateItem(id, todostore.Item{Parent: parent}, todostore.FieldParent)
+		if after != nil {
+			m.store.MoveItem(id, pos)
+		}
+	}
+	apply(newParent, newPos)
+	m.history.record(&undoAction{
+		desc: desc,
+		undo: func() { apply(oldParent, oldPos) },
+		redo: func() { apply(newParent, newPos) },
 	})
 }
 
 func (m *todoModel) clearDone() {
-	for _, it := range m.items {
-		if it.done.Value {
-			m.store.RemoveItem(it.id)
+	m.removeItems(func(it *item) bool { return it.isDone })
+}
+
+// toggleAll marks all items as done. When all items are done already, they are
+// marked as not done.
+func (m *todoModel) toggleAll() {
+	done := m.doneCount() < m.len()
+	verb := "Completed"
+	if !done {
+		verb = "Reopened"
+	}
+	items := make([]*item, 0, m.len())
+	for elem := m.all.Front(); elem != nil; elem = elem.Next() {
+		items = append(items, elem.Value.(*item))
+	}
+	m.setDoneItems(items, done, verb)
+}
+
+// moveAfter moves an item directly behind another one.
+// If after is nil, the item is moved to the front of the list.
+func (m *todoModel) moveAfter(it, after *item) {
+	next := m.all.Front()
+	if after != nil {
+		next = after.elem.Next()
+	}
+	if after == it || (next != nil && next.Value.(*item) == it) {
+		return // already there
+	}
+	id, old, pos := it.id, it.pos, m.posAfter(after)
+	m.store.MoveItem(id, pos)
+	m.history.record(&undoAction{
+		desc: "Moved item",
+		undo: func() { m.store.MoveItem(id, old) },
+		redo: func() { m.store.MoveItem(id, pos) },
+	})
+}
+
+// posAfter returns a position directly behind an item, or at the front of the list
+// if after is nil.
+func (m *todoModel) posAfter(after *item) todostore.Pos {
+	var a, b todostore.Pos
+	next := m.all.Front()
+	if after != nil {
+		a = after.pos
+		next = after.elem.Next()
+	}
+	if next != nil {
+		b = next.Value.(*item).pos
+	}
+	return todostore.PosBetween(a, b)
+}
+
+// moveBy moves an item by delta positions among the given items, which are the
+// items of the list as shown. Items are only moved among their siblings, i.e. the
+// parent doesn't change.
+func (m *todoModel) moveBy(it *item, items []*item, delta int) {
+	items = siblings(items, it)
+	i := indexOf(items, it)
+	j := i + delta
+	if i < 0 || j < 0 || j >= len(items) || delta == 0 {
+		return
+	}
+	if delta > 0 {
+		m.moveToSiblingGap(it, items, j+1)
+	} else {
+		m.moveToSiblingGap(it, items, j)
+	}
+}
+
+// moveToGap moves an item in front of items[gap], or to the end if gap is len(items).
+// The items are the items of the list as shown. Like moveBy, this only changes the
+// order of siblings: the item is placed behind the siblings in front of the gap.
+func (m *todoModel) moveToGap(it *item, items []*item, gap int) {
+	siblingGap := len(siblings(items[:gap], it))
+	m.moveToSiblingGap(it, siblings(items, it), siblingGap)
+}
+
+// moveToSiblingGap moves an item in front of items[gap], or to the end if gap is
+// len(items). The items are the shown siblings of the item.
+func (m *todoModel) moveToSiblingGap(it *item, items []*item, gap int) {
+	if gap > 0 {
+		m.moveAfter(it, items[gap-1])
+		return
+	}
+	// Moving to the top: place after the predecessor of items[0] in the full list.
+	var after *item
+	if prev := items[0].elem.Prev(); prev != nil {
+		after = prev.Value.(*item)
+	}
+	m.moveAfter(it, after)
+}
+
+// siblings returns the items which have the same parent as it.
+func siblings(items []*item, it *item) []*item {
+	var result []*item
+	for _, other := range items {
+		if other.parentItem == it.parentItem {
+			result = append(result, other)
 		}
 	}
+	return result
 }
 
-func (m *todoModel) add(text string) {
-	m.store.AddItem(todostore.Item{Text: text})
+func indexOf(items []*item, it *item) int {
+	for i := range items {
+		if items[i] == it {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *todoModel) add(data todostore.Item) {
+	data.List = m.id
+	id := m.store.AddItem(data)
+	m.history.record(&undoAction{
+		desc: "Added item",
+		undo: func() { m.store.RemoveItem(id) },
+		redo: func() { m.store.RestoreItem(id, data, "") },
+	})
 }
 
 func (m *todoModel) remove(it *item) {
-	m.store.RemoveItem(it.id)
+	m.removeItems(func(other *item) bool { return other == it })
+}
+
+// removeItems deletes all items matching fn, along with their subtasks.
+func (m *todoModel) removeItems(fn func(*item) bool) {
+	m.updateTree()
+	saved := m.saveItems(func(it *item) bool {
+		for ; it != nil; it = it.parentItem {
+			if fn(it) {
+				return true
+			}
+		}
+		return false
+	})
+	changes := make([]todostore.Event, len(saved))
+	for i, s := range saved {
+		changes[i] = &todostore.ItemRemoved{ID: s.id}
+	}
+	m.bulk(itemsDesc("Deleted", len(saved)), true, changes, saved.events())
+}
+
+// savedItem is a copy of an item, kept for undo.
+type savedItem struct {
+	id   todostore.ID
+	data todostore.Item
+	pos  todostore.Pos
+}
+
+type savedItems []savedItem
+
+// saveItems copies all items matching fn, in list order.
+func (m *todoModel) saveItems(fn func(*item) bool) savedItems {
+	var saved savedItems
+	for elem := m.all.Front(); elem != nil; elem = elem.Next() {
+		it := elem.Value.(*item)
+		if fn(it) {
+			saved = append(saved, savedItem{it.id, it.stored, it.pos})
+		}
+	}
+	return saved
+}
+
+// restore adds the saved items back to the store.
+func (saved savedItems) restore(store *optimisticStore) {
+	store.Bulk(saved.events()...)
+}
+
+// events returns the changes which add the saved items.
+func (saved savedItems) events() []todostore.Event {
+	evs := make([]todostore.Event, len(saved))
+	for i, s := range saved {
+		evs[i] = &todostore.ItemAdded{ID: s.id, Item: s.data, Pos: s.pos}
+	}
+	return evs
 }
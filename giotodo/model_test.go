@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todoio"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// testSnapshot creates a snapshot of n items in the default list. Every tenth item has
+// three subtasks, and the items have various attributes.
+func testSnapshot(n int) *todostore.Snapshot {
+	snap := &todostore.Snapshot{Lists: []todostore.ListInfo{{ID: todostore.DefaultList}}}
+	var (
+		pos    todostore.Pos
+		parent todostore.ID
+		due    = time.Now().Add(-time.Hour)
+	)
+	for i := 0; i < n; i++ {
+		pos = todostore.PosAfter(pos)
+		info := todostore.ItemInfo{
+			ID:  todostore.ID(fmt.Sprintf("item%06d", i)),
+			Pos: pos,
+			Item: todostore.Item{
+				Text: fmt.Sprint("item ", i),
+				List: todostore.DefaultList,
+				Done: i%3 == 0,
+			},
+		}
+		switch {
+		case i%10 == 0:
+			parent = info.ID
+		case i%10 <= 3:
+			info.Item.Parent = parent
+		}
+		if i%7 == 0 {
+			info.Item.Tags = []string{"work"}
+		}
+		if i%11 == 0 {
+			info.Item.Priority = todostore.PriorityHigh
+		}
+		if i%13 == 0 {
+			info.Item.Due = &due
+		}
+		snap.Items = append(snap.Items, info)
+	}
+	return snap
+}
+
+func newTestLists(tb testing.TB, snap *todostore.Snapshot) *todoLists {
+	store := todostore.NewStore(tb.TempDir())
+	tb.Cleanup(store.Close)
+	m := newTodoLists(store)
+	m.applySnapshot(snap)
+	return m
+}
+
+// TestModelIncremental checks that the counters and indexes, which are updated when
+// items change, match the result of computing them again.
+func TestModelIncremental(t *testing.T) {
+	var (
+		m   = newTestLists(t, testSnapshot(200))
+		l   = m.get(todostore.DefaultList)
+		rng = rand.New(rand.NewSource(1))
+		ids []todostore.ID
+	)
+	for id := range l.items {
+		ids = append(ids, id)
+	}
+	type result struct {
+		done    int
+		filters map[string][]*item
+		isDone  map[*item]bool
+	}
+	compute := func() result {
+		r := result{done: l.doneCount(), filters: make(map[string][]*item), isDone: make(map[*item]bool)}
+		for _, f := range []itemFilter{filterAll, filterActive, filterCompleted, filterOverdue, filterPriority} {
+			for _, tag := range []string{"", "work"} {
+				name := fmt.Sprintf("filter %d, tag %q", f, tag)
+				r.filters[name] = append([]*item(nil), l.filteredItems(f, tag, "")...)
+			}
+		}
+		for _, it := range l.items {
+			r.isDone[it] = it.isDone
+		}
+		return r
+	}
+
+	for step := 0; step < 2000; step++ {
+		id := ids[rng.Intn(len(ids))]
+		it := l.items[id]
+		switch op := rng.Intn(10); {
+		case it == nil:
+			data := todostore.Item{Text: "new", List: todostore.DefaultList, Done: rng.Intn(2) == 0}
+			m.handleStoreEvent(&todostore.ItemAdded{ID: id, Item: data})
+		case op < 5:
+			data := it.stored
+			data.Done = !data.Done
+			m.handleStoreEvent(&todostore.ItemChanged{ID: id, Item: data})
+		case op < 6:
+			data := it.stored
+			data.Priority = todostore.Priority(rng.Intn(3))
+			m.handleStoreEvent(&todostore.ItemChanged{ID: id, Item: data})
+		case op < 7:
+			m.handleStoreEvent(&todostore.ItemRemoved{ID: id})
+		case op < 8:
+			m.handleStoreEvent(&todostore.ItemMoved{ID: id, Pos: l.endPos()})
+		case op < 9:
+			l.toggleCollapsed(it)
+		default:
+			l.filteredItems(filterAll, "", "") // compute the tree
+		}
+
+		got := compute()
+		l.invalidate()
+		want := compute()
+		if got.done != want.done {
+			t.Fatalf("step %d: done count %d, want %d", step, got.done, want.done)
+		}
+		for name, items := range want.filters {
+			if fmt.Sprint(got.filters[name]) != fmt.Sprint(items) {
+				t.Fatalf("step %d: %s has %d items, want %d", step, name, len(got.filters[name]), len(items))
+			}
+		}
+		for it, done := range want.isDone {
+			if got.isDone[it] != done {
+				t.Fatalf("step %d: item %s done: %t, want %t", step, it.id, got.isDone[it], done)
+			}
+		}
+	}
+}
+
+// largeListSize is the number of items in benchmarks of large lists.
+const largeListSize = 100000
+
+// BenchmarkModelReplay measures loading a large list into the model.
+func BenchmarkModelReplay(b *testing.B) {
+	snap := testSnapshot(largeListSize)
+	store := todostore.NewStore(b.TempDir())
+	defer store.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := newTodoLists(store)
+		m.applySnapshot(snap)
+		l := m.get(todostore.DefaultList)
+		if n := len(l.filteredItems(filterAll, "", "")); n != largeListSize {
+			b.Fatalf("%d items shown", n)
+		}
+	}
+}
+
+// BenchmarkFilterAfterChange measures filtering a large list after an item was
+// marked as done or not done.
+func BenchmarkFilterAfterChange(b *testing.B) {
+	m := newTestLists(b, testSnapshot(largeListSize))
+	l := m.get(todostore.DefaultList)
+	l.filteredItems(filterAll, "", "")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		it := l.items[todostore.ID(fmt.Sprintf("item%06d", i%largeListSize))]
+		data := it.stored
+		data.Done = !data.Done
+		m.handleStoreEvent(&todostore.ItemChanged{ID: it.id, Item: data})
+		l.filteredItems(filterActive, "", "")
+		l.doneCount()
+	}
+}
+
+// BenchmarkLayout measures drawing frames of a large list.
+func BenchmarkLayout(b *testing.B) {
+	d := newDriver(b)
+	d.model.applySnapshot(testSnapshot(largeListSize))
+	d.frame()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.frame()
+	}
+}
+
+// TestImportUndo checks that undoing an import removes the items, and redo brings
+// them back in their order.
+func TestImportUndo(t *testing.T) {
+	d := newDriver(t)
+	d.add("existing")
+	lists := []todoio.List{{Items: []todostore.Item{{Text: "a"}, {Text: "b"}, {Text: "c"}}}}
+	if n := d.model.importLists(lists, todostore.DefaultList); n != 3 {
+		t.Fatalf("imported %d items", n)
+	}
+	d.sync()
+	check := func(want string) {
+		t.Helper()
+		var texts []string
+		for _, it := range d.ui.todos.filteredItems(filterAll, "", "") {
+			texts = append(texts, it.text)
+		}
+		if got := fmt.Sprint(texts); got != want {
+			t.Fatalf("items %s, want %s", got, want)
+		}
+	}
+	check("[existing a b c]")
+	d.model.history.undo()
+	d.sync()
+	check("[existing]")
+	d.model.history.redo()
+	check("[existing a b c]")
+	d.sync()
+	check("[existing a b c]")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"gioui.org/io/event"
+	"gioui.org/io/key"
+
+	. "github.com/fjl/gio-demos/internal/cd"
+)
+
+// Items can be used without a mouse. The item list takes keyboard focus when an item
+// is clicked, or when Tab moves the focus to it. While it is focused, the arrow keys
+// move the focused item, Space toggles it, Enter or F2 edits it, and Delete removes it.
+
+// processItemKeys handles key events of the item list. items are the items as shown.
+func (ui *todoUI) processItemKeys(gtx C, items []*item) {
+	event.Op(gtx.Ops, &ui.list)
+	filters := []event.Filter{
+		key.FocusFilter{Target: &ui.list},
+		key.Filter{Focus: &ui.list, Name: key.NameUpArrow},
+		key.Filter{Focus: &ui.list, Name: key.NameDownArrow},
+		key.Filter{Focus: &ui.list, Name: key.NameHome},
+		key.Filter{Focus: &ui.list, Name: key.NameEnd},
+		key.Filter{Focus: &ui.list, Name: key.NameSpace},
+		key.Filter{Focus: &ui.list, Name: key.NameReturn},
+		key.Filter{Focus: &ui.list, Name: key.NameEnter},
+		key.Filter{Focus: &ui.list, Name: key.NameF2},
+		key.Filter{Focus: &ui.list, Name: key.NameDeleteForward},
+		key.Filter{Focus: &ui.list, Name: key.NameDeleteBackward},
+	}
+	for {
+		e, ok := gtx.Event(filters...)
+		if !ok {
+			break
+		}
+		switch e := e.(type) {
+		case key.FocusEvent:
+			// Focus starts at the first item.
+			if e.Focus && searchItem(items, ui.focusItem) < 0 && len(items) > 0 {
+				ui.focusItem = items[0]
+			}
+		case key.Event:
+			if e.State == key.Press {
+				ui.handleItemKey(gtx, items, e)
+			}
+		}
+	}
+}
+
+// handleItemKey handles a key press in the item list.
+func (ui *todoUI) handleItemKey(gtx C, items []*item, e key.Event) {
+	if len(items) == 0 {
+		return
+	}
+	i := searchItem(items, ui.focusItem)
+	switch e.Name {
+	case key.NameUpArrow:
+		ui.focusIndex(items, max(i-1, 0))
+		return
+	case key.NameDownArrow:
+		ui.focusIndex(items, min(i+1, len(items)-1))
+		return
+	case key.NameHome:
+		ui.focusIndex(items, 0)
+		return
+	case key.NameEnd:
+		ui.focusIndex(items, len(items)-1)
+		return
+	}
+
+	if i < 0 {
+		return
+	}
+	it := items[i]
+	switch e.Name {
+	case key.NameSpace:
+		ui.todos.setDone(it, !it.isDone)
+	case key.NameReturn, key.NameEnter, key.NameF2:
+		ui.startItemEdit(gtx, it)
+	case key.NameDeleteForward, key.NameDeleteBackward:
+		// The item behind the removed subtasks is focused, or the previous one at
+		// the end of the list.
+		next := i + 1
+		for next < len(items) && items[next].depth > it.depth {
+			next++
+		}
+		ui.focusItem = nil
+		if next < len(items) {
+			ui.focusItem = items[next]
+		} else if i > 0 {
+			ui.focusItem = items[i-1]
+		}
+		ui.todos.remove(it)
+	}
+}
+
+// focusIndex focuses items[i] and scrolls the list to make it visible.
+func (ui *todoUI) focusIndex(items []*item, i int) {
+	ui.focusItem = items[i]
+	pos := &ui.list.Position
+	last := pos.First + pos.Count - 1
+	if pos.OffsetLast < 0 {
+		last-- // the last visible item is cut off
+	}
+	switch {
+	case i < pos.First:
+		ui.list.ScrollTo(i)
+	case pos.Count > 0 && i > last:
+		ui.list.ScrollTo(pos.First + i - last)
+	}
+}
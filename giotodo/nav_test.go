@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+
+	"gioui.org/io/key"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+func (d *driver) checkFocus(want *item) {
+	d.t.Helper()
+	if d.ui.focusItem != want {
+		d.t.Fatalf("focused item %v, want %q", d.ui.focusItem, want.text)
+	}
+}
+
+func TestItemNavigation(t *testing.T) {
+	d := newDriver(t)
+	a, b, c := d.add("a"), d.add("b"), d.add("c")
+
+	// Focus starts at the first item.
+	d.focus(&d.ui.list)
+	d.checkFocus(a)
+
+	d.press(key.NameDownArrow)
+	d.checkFocus(b)
+	d.press(key.NameDownArrow)
+	d.press(key.NameDownArrow)
+	d.checkFocus(c) // stays at the end
+	d.press(key.NameUpArrow)
+	d.checkFocus(b)
+	d.press(key.NameHome)
+	d.checkFocus(a)
+	d.press(key.NameUpArrow)
+	d.checkFocus(a) // stays at the start
+	d.press(key.NameEnd)
+	d.checkFocus(c)
+}
+
+func TestItemToggle(t *testing.T) {
+	d := newDriver(t)
+	d.add("a")
+	b := d.add("b")
+
+	d.ui.focusItem = b
+	d.focus(&d.ui.list)
+	d.press(key.NameSpace)
+	d.sync()
+	if !b.isDone || !d.storedDone(b.id) {
+		t.Fatal("item not done after Space")
+	}
+	d.press(key.NameSpace)
+	d.sync()
+	if b.isDone || d.storedDone(b.id) {
+		t.Fatal("item still done after second Space")
+	}
+}
+
+func TestItemDelete(t *testing.T) {
+	d := newDriver(t)
+	a, b := d.add("a"), d.add("b")
+	d.ui.todos.add(todostore.Item{Text: "b1", Parent: b.id})
+	c := d.add("c")
+
+	// Deleting an item removes its subtasks, and focuses the item behind them.
+	d.ui.focusItem = b
+	d.focus(&d.ui.list)
+	d.press(key.NameDeleteForward)
+	d.checkFocus(c)
+	d.sync()
+	if _, ok := d.storedText(b.id); ok {
+		t.Fatal("item not removed")
+	}
+	if n := d.ui.todos.len(); n != 2 {
+		t.Fatalf("%d items left, want 2", n)
+	}
+
+	// At the end of the list, the previous item is focused.
+	d.press(key.NameDeleteBackward)
+	d.checkFocus(a)
+	d.sync()
+	if _, ok := d.storedText(c.id); ok {
+		t.Fatal("last item not removed")
+	}
+}
+
+func TestToggleAll(t *testing.T) {
+	d := newDriver(t)
+	items := []*item{d.add("a"), d.add("b"), d.add("c")}
+	d.ui.todos.setDone(items[1], true)
+	d.sync()
+
+	// When some items are active, all are completed.
+	d.ui.todos.toggleAll()
+	d.sync()
+	for _, it := range items {
+		if !it.isDone || !d.storedDone(it.id) {
+			t.Fatalf("item %q not done", it.text)
+		}
+	}
+
+	// When all are done, all are reopened.
+	d.ui.todos.toggleAll()
+	d.sync()
+	for _, it := range items {
+		if it.isDone || d.storedDone(it.id) {
+			t.Fatalf("item %q still done", it.text)
+		}
+	}
+
+	// Undo completes them again.
+	d.model.history.undo()
+	d.sync()
+	for _, it := range items {
+		if !d.storedDone(it.id) {
+			t.Fatalf("item %q not done after undo", it.text)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import "github.com/fjl/gio-demos/giotodo/internal/todostore"
+
+// Changes of items are applied to the model right away, before the store has written
+// them, so the app responds immediately even when storage is slow. Until the store
+// reports the WriteResult of a change, it is a pending operation.
+//
+// For items with pending operations, the model keeps the confirmed state, the base,
+// which is the state of the item according to the events received from the store.
+// The item is shown as the base with all pending operations applied on top. When
+// the store echoes an event about the item, it is applied to the base, and the
+// pending operations are applied again. A failed operation is removed, which rolls
+// the item back to the confirmed state plus the remaining pending operations.
+
+// optimisticStore changes items in the store and applies the changes to the model.
+// It has the same methods as todostore.Store for changing items.
+type optimisticStore struct {
+	store   *todostore.Store
+	model   *todoLists
+	pending map[todostore.ID]*pendingItem
+	byReq   map[todostore.RequestID]todostore.Event
+}
+
+// pendingItem is an item with pending operations.
+type pendingItem struct {
+	base itemState
+	item *item // kept while the item is removed, so rollback can restore it
+	ops  []pendingOp
+}
+
+type pendingOp struct {
+	req todostore.RequestID
+	ev  todostore.Event
+}
+
+// failedWrite is a change which couldn't be stored.
+type failedWrite struct {
+	res *todostore.WriteResult
+	ev  todostore.Event // optimistic operation, nil for changes of lists
+}
+
+// itemState is the content of an item.
+type itemState struct {
+	exists bool
+	data   todostore.Item
+	pos    todostore.Pos
+}
+
+func newOptimisticStore(store *todostore.Store, model *todoLists) *optimisticStore {
+	return &optimisticStore{
+		store:   store,
+		model:   model,
+		pending: make(map[todostore.ID]*pendingItem),
+		byReq:   make(map[todostore.RequestID]todostore.Event),
+	}
+}
+
+// AddItem adds a new item and returns its ID.
+func (o *optimisticStore) AddItem(data todostore.Item) todostore.ID {
+	id := todostore.NewID()
+	o.RestoreItem(id, data, "")
+	return id
+}
+
+// RestoreItem adds an item with a known ID. Items without position are placed at
+// the end of their list.
+func (o *optimisticStore) RestoreItem(id todostore.ID, data todostore.Item, pos todostore.Pos) {
+	if l := o.model.byID[data.List]; l != nil && pos == "" {
+		pos = l.endPos()
+	}
+	req := o.store.RestoreItem(id, data, pos)
+	o.send(req, &todostore.ItemAdded{ID: id, Item: data, Pos: pos})
+}
+
+// RemoveItem deletes an item.
+func (o *optimisticStore) RemoveItem(id todostore.ID) {
+	req := o.store.RemoveItem(id)
+	o.send(req, &todostore.ItemRemoved{ID: id})
+}
+
+// UpdateItem changes an item, like todostore.Store.UpdateItem.
+func (o *optimisticStore) UpdateItem(id todostore.ID, data todostore.Item, fields ...todostore.Field) {
+	req := o.store.UpdateItem(id, data, fields...)
+	o.send(req, &todostore.ItemChanged{ID: id, Item: data, Fields: fields})
+}
+
+// MoveItem changes the position of an item.
+func (o *optimisticStore) MoveItem(id todostore.ID, pos todostore.Pos) {
+	req := o.store.MoveItem(id, pos)
+	o.send(req, &todostore.ItemMoved{ID: id, Pos: pos})
+}
+
+// Bulk changes several items at once, like todostore.Store.Bulk. A single change is
+// sent as a normal request, so its failure is shown next to the item.
+func (o *optimisticStore) Bulk(changes ...todostore.Event) {
+	switch len(changes) {
+	case 0:
+		return
+	case 1:
+		switch ev := changes[0].(type) {
+		case *todostore.ItemAdded:
+			o.RestoreItem(ev.ID, ev.Item, ev.Pos)
+		case *todostore.ItemRemoved:
+			o.RemoveItem(ev.ID)
+		case *todostore.ItemChanged:
+			o.UpdateItem(ev.ID, ev.Item, ev.Fields...)
+		case *todostore.ItemMoved:
+			o.MoveItem(ev.ID, ev.Pos)
+		}
+		return
+	}
+	req := o.store.Bulk(changes...)
+	o.send(req, &todostore.BulkChange{Changes: changes})
+}
+
+// send records a pending operation and shows its effect. The changes of a bulk
+// change are pending operations of their items, which end together.
+func (o *optimisticStore) send(req todostore.RequestID, ev todostore.Event) {
+	o.byReq[req] = ev
+	for _, change := range itemChanges(ev) {
+		id := itemEventID(change)
+		p := o.pending[id]
+		if p == nil {
+			p = &pendingItem{base: o.model.itemState(id), item: o.model.items[id]}
+			o.pending[id] = p
+		}
+		p.ops = append(p.ops, pendingOp{req, change})
+		o.show(id, p)
+	}
+}
+
+// handleItemEvent applies an event of the store to the base of an item with pending
+// operations. It returns false for events about other items.
+func (o *optimisticStore) handleItemEvent(ev todostore.Event) bool {
+	id := itemEventID(ev)
+	p := o.pending[id]
+	if id == "" || p == nil {
+		return false
+	}
+	p.base.apply(ev)
+	o.show(id, p)
+	return true
+}
+
+// handleResult ends a pending operation. The store sends the WriteResult after the
+// events of the change, so these are already part of the base. It returns the
+// operation, or nil if the request wasn't sent by o.
+func (o *optimisticStore) handleResult(res *todostore.WriteResult) todostore.Event {
+	ev, ok := o.byReq[res.Request]
+	if !ok {
+		return nil
+	}
+	delete(o.byReq, res.Request)
+	for _, change := range itemChanges(ev) {
+		id := itemEventID(change)
+		p := o.pending[id]
+		if p == nil {
+			continue // already ended by another change of the bulk
+		}
+		ops := p.ops[:0]
+		for _, op := range p.ops {
+			if op.req != res.Request {
+				ops = append(ops, op)
+			}
+		}
+		p.ops = ops
+		o.show(id, p)
+		if len(p.ops) == 0 {
+			delete(o.pending, id)
+		}
+	}
+	return ev
+}
+
+// show updates the model to the base with pending operations applied.
+func (o *optimisticStore) show(id todostore.ID, p *pendingItem) {
+	st := p.base
+	for _, op := range p.ops {
+		st.apply(op.ev)
+	}
+	if it := o.model.setItemState(id, st, p.item); it != nil {
+		p.item = it
+	}
+}
+
+// apply changes the state according to an event.
+func (st *itemState) apply(ev todostore.Event) {
+	switch ev := ev.(type) {
+	case *todostore.ItemAdded:
+		st.exists, st.data, st.pos = true, ev.Item, ev.Pos
+	case *todostore.ItemRemoved:
+		st.exists = false
+	case *todostore.ItemChanged:
+		// Events of the store contain the whole item.
+		if len(ev.Fields) == 0 {
+			st.data = ev.Item
+		} else {
+			todostore.SetFields(&st.data, &ev.Item, ev.Fields)
+		}
+	case *todostore.ItemMoved:
+		st.pos = ev.Pos
+	}
+}
+
+// itemChanges returns the changes of items made by an operation.
+func itemChanges(ev todostore.Event) []todostore.Event {
+	if b, ok := ev.(*todostore.BulkChange); ok {
+		return b.Changes
+	}
+	return []todostore.Event{ev}
+}
+
+// itemEventID returns the item of an event, or the empty ID for other events.
+func itemEventID(ev todostore.Event) todostore.ID {
+	switch ev := ev.(type) {
+	case *todostore.ItemAdded:
+		return ev.ID
+	case *todostore.ItemRemoved:
+		return ev.ID
+	case *todostore.ItemChanged:
+		return ev.ID
+	case *todostore.ItemMoved:
+		return ev.ID
+	}
+	return ""
+}
+
+// itemState returns the current state of an item.
+func (m *todoLists) itemState(id todostore.ID) itemState {
+	it := m.items[id]
+	if it == nil {
+		return itemState{}
+	}
+	return itemState{exists: true, data: it.stored, pos: it.pos}
+}
+
+// setItemState updates an item to the given state. When the item is added, the
+// given item object is reused if non-nil. It returns the item object.
+func (m *todoLists) setItemState(id todostore.ID, st itemState, reuse *item) *item {
+	it := m.items[id]
+	switch {
+	case !st.exists:
+		if it != nil {
+			m.deleteItem(it)
+		}
+	case it == nil:
+		if it = reuse; it == nil {
+			it = &item{id: id}
+		}
+		it.pos = st.pos
+		m.addItem(it, st.data)
+	default:
+		moved := st.data.List != it.list.id
+		m.changeItem(it, st.data)
+		// Items moved to another list are placed at its end, as in handleStoreEvent.
+		if !moved && st.pos != it.pos {
+			m.setPos(it, st.pos)
+		}
+	}
+	return it
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// These tests send changes through the optimisticStore of a model, but don't apply
+// the events of the store. Instead, they deliver the events which the store would
+// send, in the order needed by the test.
+
+// newPendingModel creates a model of the default list with three items. The items
+// are named item0 to item2, and their text is "item 0" to "item 2".
+func newPendingModel(t *testing.T) *todoLists {
+	store := todostore.NewStore(t.TempDir())
+	t.Cleanup(store.Close)
+	m := newTodoLists(store)
+	var pos todostore.Pos
+	for i := 0; i < 3; i++ {
+		pos = todostore.PosBetween(pos, "")
+		data := todostore.Item{Text: fmt.Sprint("item ", i), List: todostore.DefaultList}
+		m.handleStoreEvent(&todostore.ItemAdded{ID: todostore.ID(fmt.Sprint("item", i)), Item: data, Pos: pos})
+	}
+	return m
+}
+
+// lastRequest returns the request of the newest pending operation of an item.
+func lastRequest(t *testing.T, m *todoLists, id todostore.ID) todostore.RequestID {
+	t.Helper()
+	p := m.writer.pending[id]
+	if p == nil || len(p.ops) == 0 {
+		t.Fatalf("item %s has no pending operations", id)
+	}
+	return p.ops[len(p.ops)-1].req
+}
+
+func checkText(t *testing.T, m *todoLists, id todostore.ID, want string) {
+	t.Helper()
+	it := m.items[id]
+	switch {
+	case it == nil && want != "":
+		t.Fatalf("item %s not shown, want %q", id, want)
+	case it != nil && it.stored.Text != want:
+		t.Fatalf("item %s has text %q, want %q", id, it.stored.Text, want)
+	}
+}
+
+func TestPendingRollback(t *testing.T) {
+	var (
+		m       = newPendingModel(t)
+		id      = todostore.ID("item0")
+		errDisk = errors.New("disk full")
+	)
+
+	// A failed change restores the item.
+	m.writer.UpdateItem(id, todostore.Item{Text: "changed"}, todostore.FieldText)
+	checkText(t, m, id, "changed")
+	req := lastRequest(t, m, id)
+	m.handleStoreEvent(&todostore.WriteResult{Request: req, Target: id, Err: errDisk})
+	checkText(t, m, id, "item 0")
+	if len(m.writer.pending) != 0 {
+		t.Fatalf("pending operations left: %v", m.writer.pending)
+	}
+	if f := m.failedFor(id); len(f) != 1 || f[0].res.Err != errDisk {
+		t.Fatalf("wrong failed changes %v", f)
+	}
+
+	// A failed removal brings the item back.
+	m.writer.RemoveItem(id)
+	checkText(t, m, id, "")
+	req = lastRequest(t, m, id)
+	m.handleStoreEvent(&todostore.WriteResult{Request: req, Target: id, Err: errDisk})
+	checkText(t, m, id, "item 0")
+
+	// A failed addition removes the item.
+	newID := m.writer.AddItem(todostore.Item{Text: "new", List: todostore.DefaultList})
+	checkText(t, m, newID, "new")
+	req = lastRequest(t, m, newID)
+	m.handleStoreEvent(&todostore.WriteResult{Request: req, Target: newID, Err: errDisk})
+	checkText(t, m, newID, "")
+	if n := len(m.get(todostore.DefaultList).filteredItems(filterAll, "", "")); n != 3 {
+		t.Fatalf("%d items shown, want 3", n)
+	}
+}
+
+// This checks that a change of another device, which the store applied after the
+// pending change, is shown when the change is stored.
+func TestPendingOverride(t *testing.T) {
+	var (
+		m    = newPendingModel(t)
+		id   = todostore.ID("item1")
+		data = m.items[id].stored
+	)
+
+	m.writer.UpdateItem(id, todostore.Item{Text: "mine"}, todostore.FieldText)
+	req := lastRequest(t, m, id)
+
+	// The store applies the change, then the change of the other device.
+	mine, theirs := data, data
+	mine.Text, theirs.Text = "mine", "theirs"
+	m.handleStoreEvent(&todostore.ItemChanged{ID: id, Item: mine})
+	m.handleStoreEvent(&todostore.ItemChanged{ID: id, Item: theirs})
+	checkText(t, m, id, "mine") // still pending
+
+	m.handleStoreEvent(&todostore.WriteResult{Request: req, Target: id})
+	checkText(t, m, id, "theirs")
+	if len(m.writer.pending) != 0 {
+		t.Fatalf("pending operations left: %v", m.writer.pending)
+	}
+}
+
+// This checks that events which don't touch the changed field don't undo a pending
+// change, and that a failed change keeps them.
+func TestPendingUnrelatedEvent(t *testing.T) {
+	var (
+		m     = newPendingModel(t)
+		id    = todostore.ID("item1")
+		other = todostore.ID("item2")
+		data  = m.items[id].stored
+	)
+
+	m.writer.UpdateItem(id, todostore.Item{Done: true}, todostore.FieldDone)
+	req := lastRequest(t, m, id)
+
+	// Events of another item and of another field of the item arrive.
+	otherData := m.items[other].stored
+	otherData.Text = "other changed"
+	m.handleStoreEvent(&todostore.ItemChanged{ID: other, Item: otherData})
+	changed := data
+	changed.Priority = todostore.PriorityHigh
+	m.handleStoreEvent(&todostore.ItemChanged{ID: id, Item: changed})
+
+	it := m.items[id]
+	if !it.stored.Done || it.stored.Priority != todostore.PriorityHigh {
+		t.Fatalf("wrong item after unrelated events: %+v", it.stored)
+	}
+	checkText(t, m, other, "other changed")
+
+	// The change fails. Only the pending change is rolled back.
+	m.handleStoreEvent(&todostore.WriteResult{Request: req, Target: id, Err: errors.New("disk full")})
+	if it := m.items[id]; it.stored.Done || it.stored.Priority != todostore.PriorityHigh {
+		t.Fatalf("wrong item after rollback: %+v", it.stored)
+	}
+}
+
+// This checks that the result of a bulk change ends the pending operations of all
+// its items.
+func TestPendingBulk(t *testing.T) {
+	var (
+		m       = newPendingModel(t)
+		ids     = []todostore.ID{"item0", "item1", "item2"}
+		changes []todostore.Event
+	)
+	for _, id := range ids[:2] {
+		changes = append(changes, &todostore.ItemChanged{ID: id, Item: todostore.Item{Done: true}, Fields: []todostore.Field{todostore.FieldDone}})
+	}
+	changes = append(changes, &todostore.ItemRemoved{ID: ids[2]})
+
+	// The bulk change fails. All items are rolled back.
+	m.writer.Bulk(changes...)
+	if !m.items[ids[0]].stored.Done || !m.items[ids[1]].stored.Done || m.items[ids[2]] != nil {
+		t.Fatal("bulk change not shown")
+	}
+	req := lastRequest(t, m, ids[0])
+	m.handleStoreEvent(&todostore.WriteResult{Request: req, Err: errors.New("disk full")})
+	if m.items[ids[0]].stored.Done || m.items[ids[1]].stored.Done {
+		t.Fatal("items still done after failed bulk change")
+	}
+	checkText(t, m, ids[2], "item 2")
+	if len(m.writer.pending) != 0 {
+		t.Fatalf("pending operations left: %v", m.writer.pending)
+	}
+
+	// The bulk change is stored. The store sends the events, then the result.
+	m.writer.Bulk(changes...)
+	req = lastRequest(t, m, ids[0])
+	for _, id := range ids[:2] {
+		data := m.writer.pending[id].base.data
+		data.Done = true
+		m.handleStoreEvent(&todostore.ItemChanged{ID: id, Item: data})
+	}
+	m.handleStoreEvent(&todostore.ItemRemoved{ID: ids[2]})
+	m.handleStoreEvent(&todostore.WriteResult{Request: req})
+	if len(m.writer.pending) != 0 {
+		t.Fatalf("pending operations left: %v", m.writer.pending)
+	}
+	if !m.items[ids[0]].stored.Done || !m.items[ids[1]].stored.Done || m.items[ids[2]] != nil {
+		t.Fatal("bulk change not applied")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"gioui.org/layout"
+	"gioui.org/widget"
+	"github.com/fjl/gio-demos/giotodo/internal/notify"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+
+	. "github.com/fjl/gio-demos/internal/cd"
+)
+
+// bannerNotifier collects reminders for display in the reminder banner.
+// It is a todostore.Notifier.
+type bannerNotifier struct {
+	mu      sync.Mutex
+	pending []todostore.Reminder
+}
+
+func (b *bannerNotifier) Notify(r todostore.Reminder) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, r)
+	return nil
+}
+
+// take returns the reminders delivered since the last call.
+func (b *bannerNotifier) take() []todostore.Reminder {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rs := b.pending
+	b.pending = nil
+	return rs
+}
+
+// reminderBanner shows reminders at the top of the window.
+type reminderBanner struct {
+	notifier  *bannerNotifier
+	reminders []todostore.Reminder
+	show      widget.Clickable
+	dismiss   widget.Clickable
+}
+
+// processReminders updates the reminder banner.
+func (ui *todoUI) processReminders(gtx C) {
+	b := &ui.banner
+	if b.notifier != nil {
+		b.reminders = append(b.reminders, b.notifier.take()...)
+	}
+	if len(b.reminders) == 0 {
+		return
+	}
+	if b.show.Clicked(gtx) {
+		// Go to the item of the first reminder.
+		if it := ui.lists.items[b.reminders[0].ID]; it != nil {
+			ui.selectList(it.list)
+			ui.focusItem = it
+			ui.showMenu = false
+		}
+		b.reminders = b.reminders[1:]
+	}
+	if b.dismiss.Clicked(gtx) {
+		b.reminders = nil
+	}
+}
+
+// layoutBanner draws the first reminder.
+func (ui *todoUI) layoutBanner(gtx C) D {
+	b := &ui.banner
+	if len(b.reminders) == 0 {
+		return D{}
+	}
+	title, body := notify.Message(b.reminders[0])
+	text := title + " · " + body
+	if n := len(b.reminders) - 1; n > 0 {
+		text += fmt.Sprintf(" (%d more)", n)
+	}
+	label := ui.theme.StatusLabel(text)
+	label.Color = ui.theme.Color.Priority
+	show := ui.theme.StatusButton(&b.show, "Show", true)
+	dismiss := ui.theme.StatusButton(&b.dismiss, "×", false)
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		layout.Flexed(1, func(gtx C) D {
+			return ui.theme.Pad.Button.Layout(gtx, label.Layout)
+		}),
+		layout.Rigid(show.Layout),
+		layout.Rigid(dismiss.Layout),
+	)
+}
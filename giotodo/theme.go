@@ -3,6 +3,9 @@ package main
 import (
 	"image"
 	"image/color"
+	"strconv"
+	"strings"
+	"time"
 
 	colorEmoji "eliasnaur.com/font/noto/emoji/color"
 	"gioui.org/f32"
@@ -16,6 +19,9 @@ import (
 	"gioui.org/text"
 	"gioui.org/unit"
 	"gioui.org/widget"
+	"github.com/fjl/gio-demos/giotodo/internal/recur"
+	"github.com/fjl/gio-demos/giotodo/internal/search"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
 
 	. "github.com/fjl/gio-demos/internal/cd"
 )
@@ -29,6 +35,7 @@ type todoTheme struct {
 		Item       color.NRGBA
 		ItemDone   color.NRGBA
 		ItemEditBG color.NRGBA
+		ItemFocus  color.NRGBA
 		HintText   color.NRGBA
 		StatusText color.NRGBA
 		Error      color.NRGBA
@@ -38,6 +45,9 @@ type todoTheme struct {
 		Checkmark  color.NRGBA
 		Remove     color.NRGBA
 		RemoveBG   color.NRGBA
+		Priority   color.NRGBA
+		TagBG      color.NRGBA
+		Highlight  color.NRGBA
 	}
 	Size struct {
 		ItemText     unit.Sp
@@ -45,6 +55,8 @@ type todoTheme struct {
 		CornerRadius unit.Dp
 		Checkbox     unit.Dp
 		Remove       unit.Dp
+		Expand       unit.Dp // expand/collapse button of items with subtasks
+		Indent       unit.Dp // indentation of subtasks per level
 		MinWidth     unit.Dp
 		MaxWidth     unit.Dp
 		PrefWidth    unit.Dp
@@ -98,10 +110,14 @@ func newTodoTheme() *todoTheme {
 	th.Color.Item = color.NRGBA{77, 77, 77, 255}
 	th.Color.ItemDone = color.NRGBA{217, 217, 217, 255}
 	th.Color.ItemEditBG = color.NRGBA{77, 77, 77, 18}
+	th.Color.ItemFocus = color.NRGBA{77, 77, 77, 8}
 	th.Color.Checkmark = color.NRGBA{93, 194, 175, 255}
 	th.Color.Remove = color.NRGBA{175, 91, 94, 255}
 	th.Color.RemoveBG = th.Color.Remove
 	th.Color.RemoveBG.A = 30
+	th.Color.Priority = color.NRGBA{175, 47, 47, 255}
+	th.Color.TagBG = color.NRGBA{93, 194, 175, 40}
+	th.Color.Highlight = color.NRGBA{255, 214, 0, 90}
 
 	// Sizes.
 	th.Size.ItemText = 26
@@ -109,6 +125,8 @@ func newTodoTheme() *todoTheme {
 	th.Size.CornerRadius = 3
 	th.Size.Checkbox = 30
 	th.Size.Remove = 20
+	th.Size.Expand = 20
+	th.Size.Indent = 26
 	th.Size.MinWidth = 350
 	th.Size.PrefWidth = 550
 	th.Size.MaxWidth = 700
@@ -157,6 +175,7 @@ type labelStyle struct {
 	TextSize      unit.Sp
 	StrikeThrough bool
 	Alignment     text.Alignment
+	Highlight     []search.Range // parts of Text drawn with highlight background
 	theme         *todoTheme
 }
 
@@ -192,7 +211,23 @@ func (l *labelStyle) Layout(gtx C) D {
 	mingtx := gtx
 	mingtx.Constraints.Min = image.ZP
 	label := widget.Label{MaxLines: 1}
+	r := op.Record(gtx.Ops)
 	dim := label.Layout(mingtx, l.theme.Shaper, l.Font, l.TextSize, l.Text, textMaterial)
+	call := r.Stop()
+
+	// Draw highlights under the text. The position of each range is found by
+	// measuring the text before it.
+	for _, hl := range l.Highlight {
+		x0, x1 := l.textWidth(mingtx, l.Text[:hl.Start]), l.textWidth(mingtx, l.Text[:hl.End])
+		if x1 > dim.Size.X {
+			x1 = dim.Size.X // truncated
+		}
+		if x0 < x1 {
+			rect := clip.Rect(image.Rect(x0, 0, x1, dim.Size.Y))
+			paint.FillShape(gtx.Ops, l.theme.Color.Highlight, rect.Op())
+		}
+	}
+	call.Add(gtx.Ops)
 
 	// Draw strikethrough.
 	if l.StrikeThrough {
@@ -203,6 +238,18 @@ func (l *labelStyle) Layout(gtx C) D {
 	return dim
 }
 
+// textWidth returns the width of txt in the font of the label.
+func (l *labelStyle) textWidth(gtx C, txt string) int {
+	if txt == "" {
+		return 0
+	}
+	r := op.Record(gtx.Ops)
+	label := widget.Label{MaxLines: 1}
+	dim := label.Layout(gtx, l.theme.Shaper, l.Font, l.TextSize, txt, op.CallOp{})
+	r.Stop()
+	return dim.Size.X
+}
+
 // Editor.
 
 type editorStyle struct {
@@ -253,6 +300,14 @@ func (e *editorStyle) Layout(gtx C) D {
 // Items.
 
 type itemStyle struct {
+	Focused    bool // item has keyboard focus
+	Selected   bool // item is selected for bulk actions
+	Dragged    bool // item is being dragged
+	DropBefore bool // show drop indicator above item
+	DropAfter  bool // show drop indicator below item
+
+	WriteError error // last change of the item couldn't be stored
+
 	item    *item
 	theme   *todoTheme
 	label   labelStyle
@@ -286,19 +341,52 @@ func (it *itemStyle) Layout(gtx C) D {
 	mac := r.Stop()
 
 	// Put background under item when editing.
-	if it.editing {
-		bg := clip.Rect(image.Rectangle{Max: dim.Size})
+	bg := clip.Rect(image.Rectangle{Max: dim.Size})
+	switch {
+	case it.editing || it.Dragged:
 		paint.FillShape(gtx.Ops, it.theme.Color.ItemEditBG, bg.Op())
+	case it.Selected:
+		paint.FillShape(gtx.Ops, it.theme.Color.Selection, bg.Op())
+	case it.Focused:
+		paint.FillShape(gtx.Ops, it.theme.Color.ItemFocus, bg.Op())
 	}
 
-	// Now draw item over.
+	// Now draw item over. The drag handler is registered for the whole item.
+	area := bg.Push(gtx.Ops)
+	it.item.drag.Add(gtx.Ops)
 	mac.Add(gtx.Ops)
+	area.Pop()
+
+	// Draw drop indicator.
+	if it.DropBefore {
+		it.drawDropLine(gtx, 0, dim.Size.X)
+	}
+	if it.DropAfter {
+		it.drawDropLine(gtx, dim.Size.Y-gtx.Dp(2), dim.Size.X)
+	}
 	return dim
 }
 
+// drawDropLine draws the drop indicator at height y.
+func (it *itemStyle) drawDropLine(gtx C, y, width int) {
+	rect := clip.Rect(image.Rect(0, y, width, y+gtx.Dp(2)))
+	paint.FillShape(gtx.Ops, it.theme.Color.Checkmark, rect.Op())
+}
+
 // layoutRow draws an item.
 func (it *itemStyle) layoutRow(gtx C) D {
 	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		// Indentation and expand button.
+		layout.Rigid(func(gtx C) D {
+			indent := gtx.Dp(it.theme.Size.Indent) * it.item.depth
+			sz := gtx.Dp(it.theme.Size.Expand)
+			if len(it.item.children) > 0 {
+				defer op.Offset(image.Pt(indent, 0)).Push(gtx.Ops).Pop()
+				gtx.Constraints = layout.Exact(image.Pt(sz, sz))
+				it.item.expand.Layout(gtx, it.layoutExpandButton)
+			}
+			return D{Size: image.Pt(indent+sz, sz)}
+		}),
 		// Checkbox.
 		layout.Rigid(func(gtx C) D {
 			sz := gtx.Dp(it.theme.Size.Checkbox)
@@ -332,6 +420,23 @@ func (it *itemStyle) layoutText(gtx C) D {
 			label.StrikeThrough = true
 		}
 		textWidget = label.Layout
+		if it.item.hasAttributes() || it.WriteError != nil {
+			textWidget = func(gtx C) D {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+					layout.Rigid(label.Layout),
+					layout.Rigid(func(gtx C) D {
+						return showIf(it.item.hasAttributes(), gtx, it.layoutAttributes)
+					}),
+					layout.Rigid(func(gtx C) D {
+						// Not drawn with showIf, which would lay out the nil error.
+						if it.WriteError == nil {
+							return D{}
+						}
+						return it.layoutWriteError(gtx)
+					}),
+				)
+			}
+		}
 	}
 
 	dim := it.theme.Pad.Item.Layout(gtx, textWidget)
@@ -342,6 +447,68 @@ func (it *itemStyle) layoutText(gtx C) D {
 	return dim
 }
 
+// layoutAttributes draws the line of optional item attributes below the text.
+func (it *itemStyle) layoutAttributes(gtx C) D {
+	var (
+		children []layout.FlexChild
+		pad      = layout.Inset{Right: it.theme.Pad.Button.Right}
+	)
+	add := func(w layout.Widget) {
+		children = append(children, layout.Rigid(func(gtx C) D {
+			return pad.Layout(gtx, w)
+		}))
+	}
+
+	if p := it.item.priority; p > todostore.PriorityNone {
+		label := it.theme.StatusLabel(strings.Repeat("!", int(p)))
+		label.Color = it.theme.Color.Priority
+		add(label.Layout)
+	}
+	if due := it.item.due; due != nil {
+		label := it.theme.StatusLabel("due " + formatDueLabel(*due))
+		now := gtx.Now
+		if it.item.overdue(now) {
+			label.Color = it.theme.Color.Error
+		} else if !it.item.done.Value {
+			// Redraw when the item becomes overdue.
+			gtx.Execute(op.InvalidateCmd{At: *due})
+		}
+		add(label.Layout)
+	}
+	if rule, err := recur.Parse(it.item.repeat); err == nil {
+		label := it.theme.StatusLabel("every " + rule.Short())
+		add(label.Layout)
+	}
+	for i, tag := range it.item.tags {
+		b := it.theme.TagButton(&it.item.tagBtn[i], tag)
+		add(b.Layout)
+	}
+	if it.item.notes != "" {
+		label := it.theme.StatusLabel(it.item.notes)
+		children = append(children, layout.Flexed(1, label.Layout))
+	}
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx, children...)
+}
+
+// layoutWriteError draws the error of a failed change with a retry button.
+func (it *itemStyle) layoutWriteError(gtx C) D {
+	label := it.theme.StatusLabel("Not saved: " + it.WriteError.Error())
+	label.Color = it.theme.Color.Error
+	retry := it.theme.StatusButton(&it.item.retry, "Retry", true)
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		layout.Flexed(1, label.Layout),
+		layout.Rigid(retry.Layout),
+	)
+}
+
+// formatDueLabel formats a due date for display.
+func formatDueLabel(t time.Time) string {
+	if h, m, s := t.Clock(); h == 0 && m == 0 && s == 0 {
+		return t.Format("Jan 2")
+	}
+	return t.Format("Jan 2 15:04")
+}
+
 // layoutCheckbox draws the checkbox.
 func (it *itemStyle) layoutCheckbox(gtx C) D {
 	var (
@@ -397,6 +564,29 @@ func (it *itemStyle) layoutRemoveButton(gtx C) D {
 	})
 }
 
+// layoutExpandButton draws a triangle, which points down when the subtasks are
+// shown, and to the right when they are hidden.
+func (it *itemStyle) layoutExpandButton(gtx C) D {
+	var (
+		spx  = float32(gtx.Constraints.Min.X)
+		size = image.Pt(gtx.Constraints.Min.X, gtx.Constraints.Min.X)
+		path clip.Path
+	)
+	path.Begin(gtx.Ops)
+	if it.item.collapsed {
+		path.MoveTo(f32.Pt(spx*0.3, spx*0.2))
+		path.LineTo(f32.Pt(spx*0.8, spx*0.5))
+		path.LineTo(f32.Pt(spx*0.3, spx*0.8))
+	} else {
+		path.MoveTo(f32.Pt(spx*0.2, spx*0.3))
+		path.LineTo(f32.Pt(spx*0.8, spx*0.3))
+		path.LineTo(f32.Pt(spx*0.5, spx*0.8))
+	}
+	path.Close()
+	paint.FillShape(gtx.Ops, it.theme.Color.StatusText, clip.Outline{Path: path.End()}.Op())
+	return D{Size: size}
+}
+
 // layoutRemoveCross draws the remove button icon.
 func (it *itemStyle) layoutRemoveCross(gtx C) D {
 	var (
@@ -418,14 +608,88 @@ func (it *itemStyle) layoutRemoveCross(gtx C) D {
 	return D{Size: size}
 }
 
+// Lists.
+
+type listStyle struct {
+	list    *todoModel
+	theme   *todoTheme
+	label   labelStyle
+	editor  editorStyle
+	editing bool
+}
+
+// ListRow renders a row of the list manager.
+// When edit is non-nil, the list name is editable.
+func (th *todoTheme) ListRow(l *todoModel, current bool, edit *widget.Editor) listStyle {
+	s := listStyle{list: l, theme: th}
+	if edit != nil {
+		s.editing = true
+		s.editor = th.Editor(edit, "")
+	} else {
+		s.label = th.ItemLabel(l.name)
+		if current {
+			s.label.Color = th.Color.Checkmark
+		}
+	}
+	return s
+}
+
+// Layout draws a list row.
+func (ls *listStyle) Layout(gtx C) D {
+	r := op.Record(gtx.Ops)
+	dim := ls.theme.Pad.MainItem.Layout(gtx, func(gtx C) D {
+		return ls.list.btn.click.Layout(gtx, ls.layoutRow)
+	})
+	mac := r.Stop()
+
+	if ls.editing {
+		bg := clip.Rect(image.Rectangle{Max: dim.Size})
+		paint.FillShape(gtx.Ops, ls.theme.Color.ItemEditBG, bg.Op())
+	}
+	mac.Add(gtx.Ops)
+	return dim
+}
+
+func (ls *listStyle) layoutRow(gtx C) D {
+	var (
+		up     = ls.theme.Clickable(&ls.list.btn.up, "↑")
+		down   = ls.theme.Clickable(&ls.list.btn.down, "↓")
+		rename = ls.theme.Clickable(&ls.list.btn.rename, "Rename")
+		remove = ls.theme.Clickable(&ls.list.btn.remove, "Delete")
+		count  = ls.theme.StatusLabel(strconv.Itoa(ls.list.len() - ls.list.doneCount()))
+	)
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		layout.Flexed(1, func(gtx C) D {
+			w := ls.label.Layout
+			if ls.editing {
+				w = ls.editor.Layout
+			}
+			dim := ls.theme.Pad.Item.Layout(gtx, w)
+			dim.Size.X = gtx.Constraints.Max.X
+			return dim
+		}),
+		layout.Rigid(func(gtx C) D {
+			return ls.theme.Pad.Button.Layout(gtx, count.Layout)
+		}),
+		layout.Rigid(up.Layout),
+		layout.Rigid(down.Layout),
+		layout.Rigid(rename.Layout),
+		layout.Rigid(func(gtx C) D {
+			// The default list can't be deleted.
+			return showIf(ls.list.id != todostore.DefaultList, gtx, remove.Layout)
+		}),
+	)
+}
+
 // Buttons.
 
 type buttonStyle struct {
-	Label  labelStyle
-	Border color.NRGBA
-	Active bool
-	Button *widget.Clickable
-	theme  *todoTheme
+	Label      labelStyle
+	Border     color.NRGBA
+	Background color.NRGBA
+	Active     bool
+	Button     *widget.Clickable
+	theme      *todoTheme
 }
 
 // StatusButton makes a button with a border.
@@ -449,6 +713,49 @@ func (th *todoTheme) Clickable(click *widget.Clickable, txt string) buttonStyle
 	}
 }
 
+// TagButton makes a tag chip.
+func (th *todoTheme) TagButton(click *widget.Clickable, tag string) buttonStyle {
+	return buttonStyle{
+		Label:      th.StatusLabel("#" + tag),
+		Background: th.Color.TagBG,
+		Button:     click,
+		theme:      th,
+	}
+}
+
+// toggleAllStyle is the button which marks all items as done or not done.
+type toggleAllStyle struct {
+	Button  *widget.Clickable
+	AllDone bool
+	theme   *todoTheme
+}
+
+// ToggleAll makes the toggle-all button. It is highlighted when all items are done.
+func (th *todoTheme) ToggleAll(click *widget.Clickable, allDone bool) toggleAllStyle {
+	return toggleAllStyle{Button: click, AllDone: allDone, theme: th}
+}
+
+// Layout draws a chevron pointing down.
+func (t *toggleAllStyle) Layout(gtx C) D {
+	return t.Button.Layout(gtx, func(gtx C) D {
+		var (
+			spx   = gtx.Dp(t.theme.Size.Checkbox)
+			w     = float32(spx)
+			color = t.theme.Color.Border
+			path  clip.Path
+		)
+		if t.AllDone {
+			color = t.theme.Color.Item
+		}
+		path.Begin(gtx.Ops)
+		path.MoveTo(f32.Pt(w*0.2, w*0.35))
+		path.LineTo(f32.Pt(w*0.5, w*0.65))
+		path.LineTo(f32.Pt(w*0.8, w*0.35))
+		fillPath(gtx, path.End(), color, gtx.Dp(1.8))
+		return D{Size: image.Pt(spx, spx)}
+	})
+}
+
 func (b *buttonStyle) Layout(gtx C) D {
 	border := widget.Border{CornerRadius: b.theme.Size.CornerRadius, Width: 1}
 	if b.Active {
@@ -456,9 +763,20 @@ func (b *buttonStyle) Layout(gtx C) D {
 	}
 
 	return b.Button.Layout(gtx, func(gtx C) D {
-		return border.Layout(gtx, func(gtx C) D {
+		r := op.Record(gtx.Ops)
+		dim := border.Layout(gtx, func(gtx C) D {
 			return b.theme.Pad.Button.Layout(gtx, b.Label.Layout)
 		})
+		call := r.Stop()
+
+		// Draw background under the button.
+		if b.Background.A > 0 {
+			rect := image.Rectangle{Max: dim.Size}
+			rr := clip.UniformRRect(rect, gtx.Dp(b.theme.Size.CornerRadius))
+			paint.FillShape(gtx.Ops, b.Background, rr.Op(gtx.Ops))
+		}
+		call.Add(gtx.Ops)
+		return dim
 	})
 }
 
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/unit"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+)
+
+// TestItemLayoutAttributes checks that an item with attributes and without write
+// error can be drawn.
+func TestItemLayoutAttributes(t *testing.T) {
+	it := &item{id: "a"}
+	it.setData(todostore.Item{Text: "buy milk", Tags: []string{"shop"}, Priority: todostore.PriorityHigh})
+	var ops op.Ops
+	gtx := layout.Context{
+		Ops:         &ops,
+		Now:         time.Now(),
+		Metric:      unit.Metric{PxPerDp: 1, PxPerSp: 1},
+		Constraints: layout.Exact(image.Pt(400, 600)),
+	}
+	style := newTodoTheme().Item(it, nil)
+	if dim := style.Layout(gtx); dim.Size.Y == 0 {
+		t.Fatal("item has no height")
+	}
+}
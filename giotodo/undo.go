@@ -0,0 +1,73 @@
+package main
+
+import "fmt"
+
+// maxUndo is the number of actions kept in the undo history.
+const maxUndo = 100
+
+// undoHistory records user actions for undo and redo.
+//
+// Since the store is event-sourced, actions are not undone by rolling back the log.
+// Instead, undo sends compensating events: a removed item is added again with the
+// same ID, content and position, a change is reverted by changing the item back, etc.
+type undoHistory struct {
+	done   []*undoAction
+	undone []*undoAction
+	seq    int // incremented when an action is recorded
+}
+
+// undoAction is a reversible user action.
+type undoAction struct {
+	desc        string // shown to the user, e.g. "Deleted 3 items"
+	destructive bool   // action removed data
+	undo        func()
+	redo        func()
+}
+
+// record adds an action to the history. This clears the redo stack.
+func (h *undoHistory) record(a *undoAction) {
+	h.done = append(h.done, a)
+	if len(h.done) > maxUndo {
+		h.done = append(h.done[:0], h.done[len(h.done)-maxUndo:]...)
+	}
+	h.undone = h.undone[:0]
+	h.seq++
+}
+
+// last returns the most recently recorded action.
+func (h *undoHistory) last() *undoAction {
+	if len(h.done) == 0 {
+		return nil
+	}
+	return h.done[len(h.done)-1]
+}
+
+// undo reverts the last action.
+func (h *undoHistory) undo() {
+	if len(h.done) == 0 {
+		return
+	}
+	a := h.done[len(h.done)-1]
+	h.done = h.done[:len(h.done)-1]
+	h.undone = append(h.undone, a)
+	a.undo()
+}
+
+// redo repeats the last undone action.
+func (h *undoHistory) redo() {
+	if len(h.undone) == 0 {
+		return
+	}
+	a := h.undone[len(h.undone)-1]
+	h.undone = h.undone[:len(h.undone)-1]
+	h.done = append(h.done, a)
+	a.redo()
+}
+
+// itemsDesc describes an action on n items.
+func itemsDesc(verb string, n int) string {
+	if n == 1 {
+		return verb + " item"
+	}
+	return fmt.Sprintf("%s %d items", verb, n)
+}
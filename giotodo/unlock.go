@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+
+	"gioui.org/app"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/widget"
+	"github.com/fjl/gio-demos/giotodo/internal/todostore"
+
+	. "github.com/fjl/gio-demos/internal/cd"
+)
+
+// unlockScreen asks for the passphrase of an encrypted store.
+type unlockScreen struct {
+	theme   *todoTheme
+	dir     string
+	input   widget.Editor
+	button  widget.Clickable
+	focused bool
+	busy    bool
+	err     string
+	key     *todostore.Key
+	results chan unlockResult
+	w       *app.Window
+}
+
+type unlockResult struct {
+	key *todostore.Key
+	err error
+}
+
+// unlock shows the unlock screen until the passphrase of the store in dir is entered.
+// It returns a nil key when the store is not encrypted, and the DestroyEvent when the
+// window is closed before the store is unlocked.
+func unlock(w *app.Window, theme *todoTheme, dir string) (*todostore.Key, *app.DestroyEvent) {
+	if enc, _ := todostore.IsEncrypted(dir); !enc {
+		return nil, nil
+	}
+	screen := &unlockScreen{
+		theme:   theme,
+		dir:     dir,
+		input:   widget.Editor{Submit: true, SingleLine: true, Mask: '•', InputHint: key.HintPassword},
+		results: make(chan unlockResult, 1),
+		w:       w,
+	}
+	var ops op.Ops
+	for screen.key == nil {
+		switch e := w.NextEvent().(type) {
+		case app.DestroyEvent:
+			return nil, &e
+		case app.FrameEvent:
+			gtx := app.NewContext(&ops, e)
+			paint.Fill(gtx.Ops, theme.Color.MainPanel)
+			screen.Layout(gtx)
+			e.Frame(gtx.Ops)
+		}
+	}
+	w.Invalidate() // draw the app
+	return screen.key, nil
+}
+
+// start checks the passphrase. Key derivation is slow, so it runs on a separate goroutine.
+func (s *unlockScreen) start(passphrase string) {
+	s.busy, s.err = true, ""
+	go func() {
+		key, err := todostore.Unlock(s.dir, passphrase)
+		s.results <- unlockResult{key, err}
+		s.w.Invalidate()
+	}()
+}
+
+func (s *unlockScreen) Layout(gtx C) D {
+	if !s.focused {
+		gtx.Execute(key.FocusCmd{Tag: &s.input})
+		s.focused = true
+	}
+	select {
+	case res := <-s.results:
+		s.busy = false
+		switch {
+		case errors.Is(res.err, todostore.ErrWrongPassphrase):
+			s.err = "Wrong passphrase."
+			s.input.SetText("")
+		case res.err != nil:
+			s.err = res.err.Error()
+		default:
+			s.key = res.key
+		}
+	default:
+	}
+	for {
+		e, ok := s.input.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := e.(widget.SubmitEvent); ok && !s.busy {
+			s.start(s.input.Text())
+		}
+	}
+	if s.button.Clicked(gtx) && !s.busy {
+		s.start(s.input.Text())
+	}
+
+	var status labelStyle
+	switch {
+	case s.busy:
+		status = s.theme.StatusLabel("Unlocking…")
+	case s.err != "":
+		status = s.theme.StatusLabel(s.err)
+		status.Color = s.theme.Color.Error
+	default:
+		status = s.theme.StatusLabel("The todo lists are encrypted.")
+	}
+	status.Alignment = text.Middle
+	return layout.Center.Layout(gtx, func(gtx C) D {
+		if w := gtx.Dp(s.theme.Size.PrefWidth); gtx.Constraints.Max.X > w {
+			gtx.Constraints.Max.X = w
+		}
+		gtx.Constraints.Min.X = gtx.Constraints.Max.X
+		return s.theme.Pad.Main.Layout(gtx, func(gtx C) D {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					return s.theme.Pad.Item.Layout(gtx, status.Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+						layout.Flexed(1, func(gtx C) D {
+							ed := s.theme.Editor(&s.input, "Passphrase")
+							return s.theme.Pad.Item.Layout(gtx, ed.Layout)
+						}),
+						layout.Rigid(func(gtx C) D {
+							btn := s.theme.StatusButton(&s.button, "Unlock", true)
+							return btn.Layout(gtx)
+						}),
+					)
+				}),
+			)
+		})
+	})
+}
@@ -1,17 +1,21 @@
 module github.com/fjl/gio-demos
 
-go 1.20
+go 1.21
 
 require (
 	gioui.org v0.5.0
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9 // indirect
-	golang.org/x/sys v0.12.0 // indirect
+	golang.org/x/sys v0.13.0
 )
 
 require (
 	eliasnaur.com/font v0.0.0-20230308162249-dd43949cb42d
 	gioui.org/cmd v0.0.0-20220314104259-3fd231367f4a
 	gioui.org/x v0.5.0
+	github.com/godbus/dbus/v5 v5.0.6
+	golang.org/x/crypto v0.14.0
+	golang.org/x/term v0.13.0
+	golang.org/x/text v0.13.0
 )
 
 require (
@@ -20,11 +24,9 @@ require (
 	git.wow.st/gmp/jni v0.0.0-20210610011705-34026c7e22d0 // indirect
 	github.com/akavel/rsrc v0.10.1 // indirect
 	github.com/go-text/typesetting v0.0.0-20230803102845-24e03d8b5372 // indirect
-	github.com/godbus/dbus/v5 v5.0.6 // indirect
 	golang.org/x/exp/shiny v0.0.0-20220827204233-334a2380cb91 // indirect
 	golang.org/x/image v0.7.0 // indirect
 	golang.org/x/mod v0.12.0 // indirect
 	golang.org/x/sync v0.3.0 // indirect
-	golang.org/x/text v0.9.0 // indirect
 	golang.org/x/tools v0.13.0 // indirect
 )